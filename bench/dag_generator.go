@@ -48,6 +48,10 @@ func GenerateDAG(structure DAGStructure, numNodes int) (*myipld.MyNode, []*myipl
 }
 
 func GenerateLinearDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
+	return generateLinearDAG(numNodes, wallClock)
+}
+
+func generateLinearDAG(numNodes int, stamp func() int64) (*myipld.MyNode, []*myipld.MyNode, error) {
 	if numNodes <= 0 {
 		return nil, nil, fmt.Errorf("numNodes must be positive")
 	}
@@ -58,7 +62,7 @@ func GenerateLinearDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
 	for i := 0; i < numNodes; i++ {
 		nodeData := map[string]interface{}{
 			"index":     i,
-			"timestamp": time.Now().UnixNano(),
+			"timestamp": stamp(),
 			"message":   fmt.Sprintf("node-%d-data", i),
 		}
 
@@ -222,90 +226,70 @@ func GenerateCustomDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
 
 // bench/dag_generator.go
 func GenerateBinaryTreeDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
+    return generateBinaryTreeDAG(numNodes, wallClock)
+}
+
+func generateBinaryTreeDAG(numNodes int, stamp func() int64) (*myipld.MyNode, []*myipld.MyNode, error) {
     if numNodes <= 0 {
         return nil, nil, fmt.Errorf("numNodes must be positive")
     }
 
-    var nodes []*myipld.MyNode
-
-    rootData := map[string]interface{}{
-        "index":     0,
-        "timestamp": time.Now().UnixNano(),
-        "message":   "root-node",
-    }
-    rootNode, err := myipld.NewMyNode(rootData)
-    if err != nil {
-        return nil, nil, fmt.Errorf("failed to create root node: %w", err)
-    }
-    nodes = append(nodes, rootNode)
-
-    if numNodes == 1 {
-        return rootNode, nodes, nil
+    // nodes are created first in BFS order (children of i are 2i+1 and
+    // 2i+2) and linked from the last index back to the root, AddLink
+    // changes a node's CID so every child has to be final before its
+    // parent links to it
+    nodes := make([]*myipld.MyNode, 0, numNodes)
+    for index := 0; index < numNodes; index++ {
+        message := fmt.Sprintf("node-%d-data", index)
+        if index == 0 {
+            message = "root-node"
+        }
+        nodeData := map[string]interface{}{
+            "index":     index,
+            "timestamp": stamp(),
+            "message":   message,
+        }
+        node, err := myipld.NewMyNode(nodeData)
+        if err != nil {
+            if index == 0 {
+                return nil, nil, fmt.Errorf("failed to create root node: %w", err)
+            }
+            return nil, nil, fmt.Errorf("failed to create child %d: %w", index, err)
+        }
+        nodes = append(nodes, node)
     }
 
-    queue := []*myipld.MyNode{rootNode}
-    index := 1
-
-    for len(queue) > 0 && index < numNodes {
-        current := queue[0]
-        queue = queue[1:]
+    for index := numNodes - 1; index >= 0; index-- {
+        current := nodes[index]
 
         // Left child
-        if index < numNodes {
-            leftData := map[string]interface{}{
-                "index":     index,
-                "timestamp": time.Now().UnixNano(),
-                "message":   fmt.Sprintf("node-%d-data", index),
-            }
-            leftNode, err := myipld.NewMyNode(leftData)
-            if err != nil {
-                return nil, nil, fmt.Errorf("failed to create left child %d: %w", index, err)
-            }
-
+        if left := 2*index + 1; left < numNodes {
+            leftNode := nodes[left]
             linkName := fmt.Sprintf("left-%x", leftNode.Cid.Hash[:8])
             if err := current.AddLink(linkName, leftNode.Cid); err != nil {
                 return nil, nil, fmt.Errorf("failed to add left link: %w", err)
             }
-
-            nodes = append(nodes, leftNode)
-            queue = append(queue, leftNode)
-            index++
         }
 
         // Right child
-        if index < numNodes {
-            rightData := map[string]interface{}{
-                "index":     index,
-                "timestamp": time.Now().UnixNano(),
-                "message":   fmt.Sprintf("node-%d-data", index),
-            }
-            rightNode, err := myipld.NewMyNode(rightData)
-            if err != nil {
-                return nil, nil, fmt.Errorf("failed to create right child %d: %w", index, err)
-            }
-
+        if right := 2*index + 2; right < numNodes {
+            rightNode := nodes[right]
             linkName := fmt.Sprintf("right-%x", rightNode.Cid.Hash[:8])
             if err := current.AddLink(linkName, rightNode.Cid); err != nil {
                 return nil, nil, fmt.Errorf("failed to add right link: %w", err)
             }
-
-            nodes = append(nodes, rightNode)
-            queue = append(queue, rightNode)
-            index++
         }
     }
+    rootNode := nodes[0]
 
     // Final validation: ensure all linked nodes are in `nodes`
+    known := make(map[myipld.MyCID]bool, len(nodes))
+    for _, node := range nodes {
+        known[node.Cid] = true
+    }
     for _, node := range nodes {
         for _, link := range node.Links {
-            found := false
-            for _, n := range nodes {
-                if n.Cid == link.Cid {
-                    found = true
-                    break
-                }
-            }
-            if !found {
+            if !known[link.Cid] {
                 return nil, nil, fmt.Errorf("node %x has link to %x not found in nodes", node.Cid.Hash[:8], link.Cid.Hash[:8])
             }
         }
@@ -319,6 +303,10 @@ func GenerateBinaryTreeDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, erro
 // some rocket science ??
 
 func GenerateStarDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
+	return generateStarDAG(numNodes, wallClock)
+}
+
+func generateStarDAG(numNodes int, stamp func() int64) (*myipld.MyNode, []*myipld.MyNode, error) {
 	if numNodes <= 0 {
 		return nil, nil, fmt.Errorf("numNodes must be positive")
 	}
@@ -327,7 +315,7 @@ func GenerateStarDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
 
 	centerData := map[string]interface{}{
 		"index":     0,
-		"timestamp": time.Now().UnixNano(),
+		"timestamp": stamp(),
 		"message":   "center-node",
 	}
 
@@ -343,7 +331,7 @@ func GenerateStarDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
 	for i := 1; i < numNodes; i++ {
 		leafData := map[string]interface{}{
 			"index":     i,
-			"timestamp": time.Now().UnixNano(),
+			"timestamp": stamp(),
 			"message":   fmt.Sprintf("leaf-node-%d", i),
 		}
 
@@ -364,6 +352,10 @@ func GenerateStarDAG(numNodes int) (*myipld.MyNode, []*myipld.MyNode, error) {
 }
 
 func GenerateRandomDAG(numNodes int, maxLinks int) (*myipld.MyNode, []*myipld.MyNode, error) {
+	return generateRandomDAG(numNodes, maxLinks, rand.New(rand.NewSource(time.Now().UnixNano())), wallClock)
+}
+
+func generateRandomDAG(numNodes int, maxLinks int, rng *rand.Rand, stamp func() int64) (*myipld.MyNode, []*myipld.MyNode, error) {
 	if numNodes <= 0 {
 		return nil, nil, fmt.Errorf("numNodes must be positive")
 	}
@@ -374,15 +366,14 @@ func GenerateRandomDAG(numNodes int, maxLinks int) (*myipld.MyNode, []*myipld.My
 
 	// why seed ain't working normally like brooo com on T_T
 	// kam karjaa bhai T_T
-
-	rand.Seed(time.Now().UnixNano())
+	// (the global rand is shared, rng is ours so a seed sticks)
 
 	nodes := make([]*myipld.MyNode, 0, numNodes)
 
 	for i := 0; i < numNodes; i++ {
 		nodeData := map[string]interface{}{
 			"index":     i,
-			"timestamp": time.Now().UnixNano(),
+			"timestamp": stamp(),
 			"message":   fmt.Sprintf("node-%d-data", i),
 		}
 
@@ -398,10 +389,10 @@ func GenerateRandomDAG(numNodes int, maxLinks int) (*myipld.MyNode, []*myipld.My
 	for i := 1; i < numNodes; i++ {
 		current := nodes[i]
 
-		numLinks := rand.Intn(maxLinks) + 1
+		numLinks := rng.Intn(maxLinks) + 1
 
 		for j := 0; j < numLinks; j++ {
-			targetIndex := rand.Intn(i)
+			targetIndex := rng.Intn(i)
 			targetNode := nodes[targetIndex]
 
 			linkName := fmt.Sprintf("random-link-to-%x", targetNode.Cid.Hash[:8])
@@ -0,0 +1,149 @@
+package bench
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"ipld-benchmark/dht"
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/netsim"
+)
+
+type DHTLookupStats struct {
+	Peers   int
+	Lookups int
+	// Failures counts lookups that did not find the provider
+	Failures    int
+	MeanHops    float64
+	MaxHops     int
+	MeanQueries float64
+	MeanLatency time.Duration
+	P90Latency  time.Duration
+	MaxLatency  time.Duration
+	// MessagesPerLookup covers both the provide and the find
+	MessagesPerLookup float64
+}
+
+// BuildDHT joins numPeers dht nodes one after the other on sim, every
+// node bootstraps off a random node already in and looks up itself
+func BuildDHT(sim *netsim.Sim, numPeers int, cfg dht.Config) ([]*dht.Node, error) {
+	nw := netsim.NewDHTNetwork(sim)
+	nodes := make([]*dht.Node, 0, numPeers)
+
+	for i := 0; i < numPeers; i++ {
+		node, err := dht.NewNode(exchange.PeerID(fmt.Sprintf("peer-%d", i)), nw, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			node.AddPeer(nodes[sim.Rand().Intn(i)].ID())
+			if err := runLookup(sim, node.NewFindNode(node.Key())); err != nil {
+				return nil, fmt.Errorf("peer %d failed to join: %w", i, err)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func runLookup(sim *netsim.Sim, l *dht.Lookup) error {
+	l.Start()
+	if !runUntilDone(sim, l.Done()) {
+		return fmt.Errorf("simulation ran out of events before the lookup finished (%s)", sim)
+	}
+	return l.Err()
+}
+
+// BenchmarkDHTLookups builds a numPeers DHT on a simulated network, then
+// numLookups times has a random peer provide a random key and another
+// random peer look it up. same seed, same numbers
+func BenchmarkDHTLookups(numPeers, numLookups int, seed int64, link netsim.LinkConfig) (*DHTLookupStats, error) {
+	if numPeers < 2 {
+		return nil, fmt.Errorf("need at least 2 peers, got %d", numPeers)
+	}
+
+	sim := netsim.New(seed)
+	sim.SetDefaultLink(link)
+	nodes, err := BuildDHT(sim, numPeers, dht.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DHTLookupStats{Peers: numPeers, Lookups: numLookups}
+	var latencies []time.Duration
+	totalHops, totalQueries := 0, 0
+	messagesBefore := sim.Stats().MessagesSent
+
+	for i := 0; i < numLookups; i++ {
+		var key dht.Key
+		sim.Rand().Read(key[:])
+
+		provider := nodes[sim.Rand().Intn(numPeers)]
+		seeker := nodes[sim.Rand().Intn(numPeers)]
+		for seeker == provider {
+			seeker = nodes[sim.Rand().Intn(numPeers)]
+		}
+
+		if err := runLookup(sim, provider.NewProvide(key)); err != nil {
+			return nil, fmt.Errorf("provide %d failed: %w", i, err)
+		}
+		// let the ADD_PROVIDER messages land before anyone asks
+		sim.Run()
+
+		find := seeker.NewFindProviders(key)
+		if err := runLookup(sim, find); err != nil || !containsPeer(find.Result().Providers, provider.ID()) {
+			stats.Failures++
+			continue
+		}
+
+		result := find.Result()
+		totalHops += result.Hops
+		totalQueries += result.Queries
+		if result.Hops > stats.MaxHops {
+			stats.MaxHops = result.Hops
+		}
+		latencies = append(latencies, result.Duration)
+	}
+
+	if found := len(latencies); found > 0 {
+		stats.MeanHops = float64(totalHops) / float64(found)
+		stats.MeanQueries = float64(totalQueries) / float64(found)
+
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var total time.Duration
+		for _, d := range latencies {
+			total += d
+		}
+		stats.MeanLatency = total / time.Duration(found)
+		stats.P90Latency = latencies[(found*9)/10]
+		stats.MaxLatency = latencies[found-1]
+	}
+	if numLookups > 0 {
+		stats.MessagesPerLookup = float64(sim.Stats().MessagesSent-messagesBefore) / float64(numLookups)
+	}
+	return stats, nil
+}
+
+// DHTScaling runs BenchmarkDHTLookups for each network size, e.g. 10 up
+// to 10000 peers
+func DHTScaling(sizes []int, numLookups int, seed int64, link netsim.LinkConfig) ([]*DHTLookupStats, error) {
+	results := make([]*DHTLookupStats, 0, len(sizes))
+	for _, size := range sizes {
+		stats, err := BenchmarkDHTLookups(size, numLookups, seed, link)
+		if err != nil {
+			return nil, fmt.Errorf("%d peers: %w", size, err)
+		}
+		results = append(results, stats)
+	}
+	return results, nil
+}
+
+func containsPeer(ids []exchange.PeerID, id exchange.PeerID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,320 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+)
+
+// BenchmarkBlockExchange spreads a generated DAG over numPeers-1 seeders
+// and times one empty peer fetching all of it. transport is "mem" for
+// the in-process network or "tcp" for real sockets on 127.0.0.1, where
+// every peer gets its own TCPNetwork in this process. see
+// BenchmarkBlockExchangeProcess for a seeder in another process
+func BenchmarkBlockExchange(structure DAGStructure, numNodes, numPeers int, transport string) (*PerformanceMetrics, *exchange.FetchStats, error) {
+	if numPeers < 2 {
+		return nil, nil, fmt.Errorf("need at least 2 peers, got %d", numPeers)
+	}
+
+	root, nodes, err := GenerateDAG(structure, numNodes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	peers, ids, err := newExchangeSwarm(numPeers, transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		for _, p := range peers {
+			p.Close()
+		}
+	}()
+
+	for i, node := range nodes {
+		if err := myipld.PutNode(peers[1+i%(numPeers-1)].Blockstore(), node); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var stats *exchange.FetchStats
+	metrics, err := CollectMetrics(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		var err error
+		stats, err = peers[0].Fetch(ctx, root.Cid, ids[1:])
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s fetch failed: %w", transport, err)
+	}
+
+	if metrics.TotalTime.Seconds() > 0 {
+		metrics.NodesPerSecond = float64(stats.Blocks) / metrics.TotalTime.Seconds()
+	}
+	metrics.SerializedSize = stats.Bytes
+	return metrics, stats, nil
+}
+
+func newExchangeSwarm(numPeers int, transport string) ([]*exchange.Peer, []exchange.PeerID, error) {
+	ids := make([]exchange.PeerID, numPeers)
+	for i := range ids {
+		ids[i] = exchange.PeerID(fmt.Sprintf("peer-%d", i))
+	}
+
+	peers := make([]*exchange.Peer, 0, numPeers)
+	cleanup := func() {
+		for _, p := range peers {
+			p.Close()
+		}
+	}
+
+	switch transport {
+	case "mem":
+		nw := exchange.NewMemNetwork()
+		for _, id := range ids {
+			p, err := exchange.NewPeer(id, myipld.NewMemBlockstore(), nw)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			peers = append(peers, p)
+		}
+
+	case "tcp":
+		networks := make([]*exchange.TCPNetwork, numPeers)
+		addrs := make([]string, numPeers)
+		for i, id := range ids {
+			networks[i] = exchange.NewTCPNetwork()
+			p, err := exchange.NewPeer(id, myipld.NewMemBlockstore(), networks[i])
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			peers = append(peers, p)
+			addrs[i], _ = networks[i].Lookup(id)
+		}
+		for _, nw := range networks {
+			for i, id := range ids {
+				nw.AddPeer(id, addrs[i])
+			}
+		}
+
+	default:
+		return nil, nil, fmt.Errorf("unknown transport %q", transport)
+	}
+
+	return peers, ids, nil
+}
+
+// SeederEnv tells a process started by BenchmarkBlockExchangeProcess
+// what to seed, as structure:nodes:fetcher address
+const SeederEnv = "IPLD_BENCH_SEEDER"
+
+// BenchmarkBlockExchangeProcess times a fetch over TCP from a seeder
+// running in another process. seeder is started with SeederEnv set and
+// must call RunSeeder, it is stopped by closing its stdin
+func BenchmarkBlockExchangeProcess(structure DAGStructure, numNodes int, seeder *exec.Cmd) (*PerformanceMetrics, *exchange.FetchStats, error) {
+	nw := exchange.NewTCPNetwork()
+	fetcher, err := exchange.NewPeer("fetcher", myipld.NewMemBlockstore(), nw)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fetcher.Close()
+	addr, _ := nw.Lookup("fetcher")
+
+	seeder.Env = append(seeder.Environ(), fmt.Sprintf("%s=%s:%d:%s", SeederEnv, structure, numNodes, addr))
+	stdin, err := seeder.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := seeder.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := seeder.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to start seeder : %w", err)
+	}
+	defer func() {
+		stdin.Close()
+		seeder.Wait()
+	}()
+
+	seederAddr, root, err := readSeederLine(stdout)
+	if err != nil {
+		return nil, nil, err
+	}
+	go io.Copy(io.Discard, stdout)
+	nw.AddPeer("seeder", seederAddr)
+
+	var stats *exchange.FetchStats
+	metrics, err := CollectMetrics(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		var err error
+		stats, err = fetcher.Fetch(ctx, root, []exchange.PeerID{"seeder"})
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetch from seeder process failed: %w", err)
+	}
+
+	if metrics.TotalTime.Seconds() > 0 {
+		metrics.NodesPerSecond = float64(stats.Blocks) / metrics.TotalTime.Seconds()
+	}
+	metrics.SerializedSize = stats.Bytes
+	return metrics, stats, nil
+}
+
+// readSeederLine skips whatever the seeder prints before its
+// "seeder <addr> <root>" line, a test binary may log first
+func readSeederLine(r io.Reader) (string, myipld.MyCID, error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 || fields[0] != "seeder" {
+			continue
+		}
+		root, err := myipld.ParseMyCID(fields[2])
+		return fields[1], root, err
+	}
+	if err := sc.Err(); err != nil {
+		return "", myipld.MyCID{}, err
+	}
+	return "", myipld.MyCID{}, fmt.Errorf("seeder exited before printing its address")
+}
+
+// RunSeeder is the other side of BenchmarkBlockExchangeProcess. it
+// generates the DAG described by spec, the value of SeederEnv, prints
+// "seeder <addr> <root>" to out and serves blocks until stop is closed
+func RunSeeder(spec string, out io.Writer, stop io.Reader) error {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid %s %q", SeederEnv, spec)
+	}
+	structure, err := ParseStructure(parts[0])
+	if err != nil {
+		return err
+	}
+	numNodes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid node count %q : %w", parts[1], err)
+	}
+
+	root, nodes, err := GenerateDAG(structure, numNodes)
+	if err != nil {
+		return err
+	}
+
+	nw := exchange.NewTCPNetwork()
+	nw.AddPeer("fetcher", parts[2])
+	seeder, err := exchange.NewPeer("seeder", myipld.NewMemBlockstore(), nw)
+	if err != nil {
+		return err
+	}
+	defer seeder.Close()
+	for _, node := range nodes {
+		if err := myipld.PutNode(seeder.Blockstore(), node); err != nil {
+			return err
+		}
+	}
+
+	addr, _ := nw.Lookup("seeder")
+	if _, err := fmt.Fprintf(out, "seeder %s %s\n", addr, root.Cid.Hex()); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, stop)
+	return err
+}
+
+type ProtocolComparison struct {
+	Structure  DAGStructure
+	Protocol   string
+	Blocks     int
+	Messages   int64
+	RoundTrips int
+	TotalTime  time.Duration
+}
+
+// CompareExchangeProtocols fetches the same DAG from a single seeder with
+// the block-by-block exchange and with one graphsync request, for every
+// DAGStructure. latency is added to every message so round trips show up
+// in the total time
+func CompareExchangeProtocols(numNodes int, latency time.Duration) ([]ProtocolComparison, error) {
+	structures := []DAGStructure{LinearDAG, BinaryTreeDAG, StarDAG, RandomDAG}
+	results := make([]ProtocolComparison, 0, 2*len(structures))
+
+	for _, structure := range structures {
+		root, nodes, err := GenerateDAG(structure, numNodes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, protocol := range []string{"bitswap", "graphsync"} {
+			result, err := runProtocol(protocol, root.Cid, nodes, latency)
+			if err != nil {
+				return nil, fmt.Errorf("%s on %s failed: %w", protocol, structure, err)
+			}
+			result.Structure = structure
+			results = append(results, *result)
+		}
+	}
+	return results, nil
+}
+
+func runProtocol(protocol string, root myipld.MyCID, nodes []*myipld.MyNode, latency time.Duration) (*ProtocolComparison, error) {
+	nw := exchange.NewMemNetwork()
+	nw.Latency = latency
+
+	seeder, err := exchange.NewPeer("seeder", myipld.NewMemBlockstore(), nw)
+	if err != nil {
+		return nil, err
+	}
+	defer seeder.Close()
+	fetcher, err := exchange.NewPeer("fetcher", myipld.NewMemBlockstore(), nw)
+	if err != nil {
+		return nil, err
+	}
+	defer fetcher.Close()
+
+	for _, node := range nodes {
+		if err := myipld.PutNode(seeder.Blockstore(), node); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var stats *exchange.FetchStats
+	switch protocol {
+	case "bitswap":
+		stats, err = fetcher.Fetch(ctx, root, []exchange.PeerID{"seeder"})
+	case "graphsync":
+		stats, err = fetcher.GraphsyncFetch(ctx, root, "seeder", exchange.SelectAll())
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages, _ := nw.Stats()
+	return &ProtocolComparison{
+		Protocol:   protocol,
+		Blocks:     stats.Blocks,
+		Messages:   messages,
+		RoundTrips: stats.RoundTrips,
+		TotalTime:  stats.Duration,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+// GenerateOptions describe a DAG for GenerateWithOptions
+type GenerateOptions struct {
+	Structure DAGStructure
+	Nodes     int
+	// MaxLinks bounds the links per node of a RandomDAG, 3 when zero
+	MaxLinks int
+	// Seed makes the DAG reproducible, same options same CIDs. 0 keeps
+	// the wall clock timestamps and a random shape like GenerateDAG
+	Seed int64
+}
+
+/* {comment}
+
+the generators stamp every node with time.Now so two runs never share a
+CID, with a seed the stamps count up from the seed instead and RandomDAG
+draws its links from its own rand, which makes the whole DAG a function
+of the options
+
+{/comment} */
+
+func GenerateWithOptions(opts GenerateOptions) (*myipld.MyNode, []*myipld.MyNode, error) {
+	maxLinks := opts.MaxLinks
+	if maxLinks == 0 {
+		maxLinks = 3
+	}
+
+	stamp := wallClock
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if opts.Seed != 0 {
+		stamp = seededClock(opts.Seed)
+		rng = rand.New(rand.NewSource(opts.Seed))
+	}
+
+	switch opts.Structure {
+	case LinearDAG:
+		return generateLinearDAG(opts.Nodes, stamp)
+	case BinaryTreeDAG:
+		return generateBinaryTreeDAG(opts.Nodes, stamp)
+	case StarDAG:
+		return generateStarDAG(opts.Nodes, stamp)
+	case RandomDAG:
+		return generateRandomDAG(opts.Nodes, maxLinks, rng, stamp)
+	default:
+		return nil, nil, fmt.Errorf("unknown DAG structure")
+	}
+}
+
+// Structures lists every DAGStructure GenerateWithOptions knows
+func Structures() []DAGStructure {
+	return []DAGStructure{LinearDAG, BinaryTreeDAG, StarDAG, RandomDAG}
+}
+
+// ParseStructure takes the String() name or a short one, "linear",
+// "binary", "star" or "random", in any case
+func ParseStructure(s string) (DAGStructure, error) {
+	name := strings.ToLower(s)
+	for _, d := range Structures() {
+		full := strings.ToLower(d.String())
+		if name == full || name == strings.TrimSuffix(full, "dag") {
+			return d, nil
+		}
+	}
+	switch name {
+	case "binary", "tree", "binary-tree":
+		return BinaryTreeDAG, nil
+	}
+	return 0, fmt.Errorf("unknown DAG structure %q", s)
+}
+
+func wallClock() int64 {
+	return time.Now().UnixNano()
+}
+
+func seededClock(seed int64) func() int64 {
+	next := seed
+	return func() int64 {
+		next++
+		return next
+	}
+}
@@ -0,0 +1,284 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/netsim"
+)
+
+type IncentiveConfig struct {
+	// Contributors own one shard each and serve it, FreeRiders own
+	// nothing. everybody fetches every shard it does not own, straight
+	// from the owner
+	Contributors int
+	FreeRiders   int
+	// ShardSize is in bytes, 1MiB by default, cut into 64KiB blocks
+	ShardSize int
+	Seed      int64
+	Link      netsim.LinkConfig
+	// Upload caps the uplink of every peer in bytes per second, uploads
+	// have to be scarce for a policy to matter
+	Upload float64
+	// Policy is "none" (default), "choke" or "debt-ratio"
+	Policy string
+	Choker exchange.ChokerConfig
+	// Grace is how many bytes the debt-ratio policy gives anyone
+	Grace int64
+	// Timeout ends the run on the virtual clock, peers that are not done
+	// by then are reported incomplete. 10 minutes by default
+	Timeout time.Duration
+}
+
+// PeerFairness is one peer's side of the exchange
+type PeerFairness struct {
+	Peer       exchange.PeerID
+	FreeRider  bool
+	Uploaded   int64
+	Downloaded int64
+	// ShareRatio is uploaded / downloaded
+	ShareRatio float64
+	Complete   bool
+	// DownloadTime is until the last shard arrived, Timeout when the
+	// peer never finished
+	DownloadTime time.Duration
+	// DownloadRate is Downloaded over DownloadTime in bytes per second
+	DownloadRate float64
+}
+
+type IncentiveResult struct {
+	Policy string
+	Peers  []PeerFairness
+	// mean DownloadTime of each group
+	ContributorTime time.Duration
+	FreeRiderTime   time.Duration
+	// ContributorFairness is Jain's index over the download rates of the
+	// contributors, 1 when all of them got the same
+	ContributorFairness float64
+	Incomplete          int
+	VirtualTime         time.Duration
+}
+
+/* {comment}
+
+SimulateIncentives runs a swarm where uploads are the bottleneck. with
+nobody rationing them a free-rider downloads as fast as anyone, with
+choking or the debt-ratio policy the contributors serve each other first
+and a free-rider only gets the optimistic unchoke or the grace bytes
+
+{/comment} */
+
+func SimulateIncentives(cfg IncentiveConfig) (*IncentiveResult, error) {
+	if cfg.Contributors < 1 || cfg.Contributors+cfg.FreeRiders < 2 {
+		return nil, fmt.Errorf("need at least 1 contributor and 2 peers, got %d and %d", cfg.Contributors, cfg.Contributors+cfg.FreeRiders)
+	}
+	if cfg.ShardSize <= 0 {
+		cfg.ShardSize = 1 << 20
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Minute
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = "none"
+	}
+
+	sim := netsim.New(cfg.Seed)
+	sim.SetDefaultLink(cfg.Link)
+	numPeers := cfg.Contributors + cfg.FreeRiders
+	peers, ids, err := newSimSwarm(sim, numPeers, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([]myipld.MyCID, cfg.Contributors)
+	for i := range shards {
+		shards[i], err = syntheticShard(i, cfg.ShardSize, cfg.Seed, peers[i].Blockstore())
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, p := range peers {
+		if cfg.Upload > 0 {
+			sim.SetNodeBandwidth(string(ids[i]), cfg.Upload, 0)
+		}
+		policy, err := newServePolicy(cfg, cfg.Seed*1000+int64(i))
+		if err != nil {
+			return nil, err
+		}
+		p.SetServePolicy(policy)
+	}
+
+	// every peer fetches all shards at once
+	start := sim.Now()
+	sessions := make([][]*exchange.Session, numPeers)
+	for i, p := range peers {
+		for j, shard := range shards {
+			if i == j {
+				continue
+			}
+			s := p.NewSession(shard, []exchange.PeerID{ids[j]})
+			s.Start()
+			sessions[i] = append(sessions[i], s)
+		}
+	}
+
+	finished := make([]time.Duration, numPeers)
+	complete := func(i int) bool {
+		if finished[i] > 0 {
+			return true
+		}
+		for _, s := range sessions[i] {
+			select {
+			case <-s.Done():
+			default:
+				return false
+			}
+		}
+		finished[i] = sim.Now().Sub(start)
+		return true
+	}
+	sim.RunUntil(func() bool {
+		all := true
+		for i := range peers {
+			// no short circuit, every finish time has to be taken
+			all = complete(i) && all
+		}
+		return all || sim.Now().Sub(start) >= cfg.Timeout
+	})
+
+	result := &IncentiveResult{Policy: cfg.Policy, VirtualTime: sim.Now().Sub(start)}
+	var contributorTime, freeRiderTime time.Duration
+	var rates []float64
+	for i, p := range peers {
+		f := PeerFairness{Peer: ids[i], FreeRider: i >= cfg.Contributors, Complete: finished[i] > 0}
+		for _, s := range sessions[i] {
+			if s.Err() != nil {
+				return nil, fmt.Errorf("%s : %w", ids[i], s.Err())
+			}
+		}
+		for _, l := range p.Ledgers() {
+			f.Uploaded += l.BytesSent
+			f.Downloaded += l.BytesReceived
+		}
+		if f.Downloaded > 0 {
+			f.ShareRatio = float64(f.Uploaded) / float64(f.Downloaded)
+		}
+		f.DownloadTime = finished[i]
+		if !f.Complete {
+			f.DownloadTime = cfg.Timeout
+			result.Incomplete++
+		}
+		if f.DownloadTime > 0 {
+			f.DownloadRate = float64(f.Downloaded) / f.DownloadTime.Seconds()
+		}
+
+		if f.FreeRider {
+			freeRiderTime += f.DownloadTime
+		} else {
+			contributorTime += f.DownloadTime
+			rates = append(rates, f.DownloadRate)
+		}
+		result.Peers = append(result.Peers, f)
+	}
+	result.ContributorTime = contributorTime / time.Duration(cfg.Contributors)
+	if cfg.FreeRiders > 0 {
+		result.FreeRiderTime = freeRiderTime / time.Duration(cfg.FreeRiders)
+	}
+	result.ContributorFairness = JainIndex(rates)
+
+	for _, p := range peers {
+		p.Close()
+	}
+	return result, nil
+}
+
+// FairShare is the swarm without free-riders, everybody should end up
+// with a share ratio near 1 and the same download rate
+func FairShare(cfg IncentiveConfig) (*IncentiveResult, error) {
+	cfg.FreeRiders = 0
+	return SimulateIncentives(cfg)
+}
+
+// CompareIncentives runs the same swarm once per policy
+func CompareIncentives(cfg IncentiveConfig, policies []string) ([]*IncentiveResult, error) {
+	results := make([]*IncentiveResult, 0, len(policies))
+	for _, policy := range policies {
+		cfg.Policy = policy
+		result, err := SimulateIncentives(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", policy, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// JainIndex is (sum x)^2 / (n * sum x^2), 1 when every x is the same
+// and 1/n when one takes everything
+func JainIndex(xs []float64) float64 {
+	var sum, squares float64
+	for _, x := range xs {
+		sum += x
+		squares += x * x
+	}
+	if squares == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(xs)) * squares)
+}
+
+func newServePolicy(cfg IncentiveConfig, seed int64) (exchange.ServePolicy, error) {
+	switch cfg.Policy {
+	case "none":
+		return nil, nil
+	case "choke":
+		return exchange.NewChoker(cfg.Choker, seed), nil
+	case "debt-ratio":
+		return exchange.NewDebtRatio(cfg.Grace, seed), nil
+	default:
+		return nil, fmt.Errorf("unknown policy %q", cfg.Policy)
+	}
+}
+
+type shardChunk struct {
+	Shard int    `json:"shard"`
+	Index int    `json:"index"`
+	Bytes []byte `json:"bytes"`
+}
+
+// syntheticShard stores size random bytes in 64KiB chunk blocks under
+// one root and returns the root
+func syntheticShard(shard, size int, seed int64, bs myipld.Blockstore) (myipld.MyCID, error) {
+	const chunkSize = 64 << 10
+	rng := rand.New(rand.NewSource(seed + int64(shard)))
+
+	var links []myipld.MyLink
+	for offset := 0; offset < size; offset += chunkSize {
+		n := chunkSize
+		if size-offset < n {
+			n = size - offset
+		}
+		data := make([]byte, n)
+		rng.Read(data)
+		chunk, err := myipld.NewMyNode(shardChunk{Shard: shard, Index: len(links), Bytes: data})
+		if err != nil {
+			return myipld.MyCID{}, err
+		}
+		if err := myipld.PutNode(bs, chunk); err != nil {
+			return myipld.MyCID{}, err
+		}
+		links = append(links, myipld.MyLink{Name: fmt.Sprintf("chunk-%d", len(links)), Cid: chunk.Cid})
+	}
+
+	root, err := myipld.NewMyNodeWithLinks(map[string]int{"shard": shard, "size": size}, links)
+	if err != nil {
+		return myipld.MyCID{}, err
+	}
+	if err := myipld.PutNode(bs, root); err != nil {
+		return myipld.MyCID{}, err
+	}
+	return root.Cid, nil
+}
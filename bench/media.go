@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+)
+
+// SyntheticTitle imports random bytes for every rendition of ladder,
+// sized for its bandwidth over duration. seeded, so the CIDs are stable
+func SyntheticTitle(name string, duration, segDur time.Duration, ladder []media.Rendition, seed int64, bs myipld.Blockstore) (*media.Title, error) {
+	sources := make([]media.RenditionSource, 0, len(ladder))
+	for i, r := range ladder {
+		size := int64(float64(r.Bandwidth) / 8 * duration.Seconds())
+		data := make([]byte, size)
+		rand.New(rand.NewSource(seed + int64(i))).Read(data)
+		sources = append(sources, media.RenditionSource{Rendition: r, Reader: bytes.NewReader(data), Size: size})
+	}
+
+	return media.ImportTitle(media.TitleOptions{
+		Name:            name,
+		Duration:        duration,
+		SegmentDuration: segDur,
+	}, sources, bs)
+}
+
+type RenditionBlocks struct {
+	Name   string
+	Blocks int
+	Bytes  int64
+	// SegmentBytes is the average segment subtree, which is all a switch
+	// to this rendition costs per segment
+	SegmentBytes int64
+}
+
+type RenditionSharing struct {
+	Renditions []RenditionBlocks
+	// TotalBlocks and TotalBytes count every rendition on its own,
+	// UniqueBlocks and UniqueBytes what a blockstore actually keeps
+	TotalBlocks  int
+	UniqueBlocks int
+	TotalBytes   int64
+	UniqueBytes  int64
+	// SharedBlocks are blocks reachable from more than one rendition
+	SharedBlocks int
+	SharedRatio  float64
+}
+
+// MeasureRenditionSharing walks every rendition subtree of title and
+// counts the blocks they have in common. independently encoded
+// renditions share next to nothing, identical segments (slates, a
+// duplicated rendition) are where sharing comes from
+func MeasureRenditionSharing(title *media.Title, bs myipld.BlockGetter) (*RenditionSharing, error) {
+	result := &RenditionSharing{}
+	owners := make(map[myipld.MyCID]int)
+	sizes := make(map[myipld.MyCID]int64)
+
+	for _, r := range title.Renditions {
+		blocks := make(map[myipld.MyCID]bool)
+		if err := collectBlocks(bs, r.Asset.Manifest.Cid, blocks, sizes); err != nil {
+			return nil, fmt.Errorf("rendition %s : %w", r.Name, err)
+		}
+
+		stats := RenditionBlocks{Name: r.Name, Blocks: len(blocks)}
+		for cid := range blocks {
+			stats.Bytes += sizes[cid]
+			owners[cid]++
+		}
+		if n := len(r.Asset.Segments); n > 0 {
+			// everything but the manifest is segment subtrees
+			stats.SegmentBytes = (stats.Bytes - sizes[r.Asset.Manifest.Cid]) / int64(n)
+		}
+		result.Renditions = append(result.Renditions, stats)
+		result.TotalBlocks += stats.Blocks
+		result.TotalBytes += stats.Bytes
+	}
+
+	for cid, n := range owners {
+		result.UniqueBlocks++
+		result.UniqueBytes += sizes[cid]
+		if n > 1 {
+			result.SharedBlocks++
+		}
+	}
+	if result.UniqueBlocks > 0 {
+		result.SharedRatio = float64(result.SharedBlocks) / float64(result.UniqueBlocks)
+	}
+	return result, nil
+}
+
+func collectBlocks(bs myipld.BlockGetter, cid myipld.MyCID, seen map[myipld.MyCID]bool, sizes map[myipld.MyCID]int64) error {
+	if seen[cid] {
+		return nil
+	}
+	seen[cid] = true
+
+	raw, err := bs.Get(cid)
+	if err != nil {
+		return err
+	}
+	sizes[cid] = int64(len(raw))
+
+	node, err := myipld.FromBytes(raw)
+	if err != nil {
+		return err
+	}
+	for _, link := range node.Links {
+		if err := collectBlocks(bs, link.Cid, seen, sizes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BenchmarkRenditionSharing builds a synthetic title over the default
+// ladder and measures what its renditions share
+func BenchmarkRenditionSharing(duration, segDur time.Duration, seed int64) (*RenditionSharing, error) {
+	bs := myipld.NewMemBlockstore()
+	title, err := SyntheticTitle("synthetic", duration, segDur, media.DefaultLadder, seed, bs)
+	if err != nil {
+		return nil, err
+	}
+	return MeasureRenditionSharing(title, bs)
+}
@@ -0,0 +1,138 @@
+package bench
+
+import (
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* {comment}
+
+what CollectMetrics needs beyond two MemStats. MemStats is a snapshot,
+the peak heap of a run is only seen by looking while it runs, every
+HeapPollInterval. the GC CPU time the runtime keeps is only brought up
+to date by a collection, so the share is over the run up to its last
+collection, 0 when there was none. the peak RSS is the kernel's high
+water mark, reset before the run through /proc/self/clear_refs where
+that is allowed and for the whole process where it isn't
+
+{/comment} */
+
+// HeapPollInterval is how often CollectMetrics looks at the heap
+var HeapPollInterval = time.Millisecond
+
+// heapInuse is HeapInuse without stopping the world like ReadMemStats
+var heapInuse = []string{"/memory/classes/heap/objects:bytes", "/memory/classes/heap/unused:bytes"}
+
+// watchHeap polls the heap until the returned stop, which gives the
+// most in use it saw
+func watchHeap(every time.Duration) func() uint64 {
+	samples := make([]metrics.Sample, len(heapInuse))
+	for i, name := range heapInuse {
+		samples[i].Name = name
+	}
+	var (
+		peak uint64
+		wg   sync.WaitGroup
+	)
+	look := func() {
+		metrics.Read(samples)
+		var inuse uint64
+		for _, s := range samples {
+			if s.Value.Kind() == metrics.KindUint64 {
+				inuse += s.Value.Uint64()
+			}
+		}
+		if inuse > peak {
+			peak = inuse
+		}
+	}
+	look()
+
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				look()
+				return
+			case <-t.C:
+				look()
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
+
+// gcCPU is the CPU time the GC and everything took so far, as of the
+// last collection
+func gcCPU() (gc, total float64) {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/gc/total:cpu-seconds"},
+		{Name: "/cpu/classes/total:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindFloat64 || samples[1].Value.Kind() != metrics.KindFloat64 {
+		return 0, 0
+	}
+	return samples[0].Value.Float64(), samples[1].Value.Float64()
+}
+
+// resetPeakRSS starts the high water mark over at the current RSS, it
+// fails quietly where the kernel doesn't have or allow it
+func resetPeakRSS() {
+	os.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+}
+
+// peakRSS is VmHWM of /proc/self/status in bytes, 0 without one
+func peakRSS() uint64 {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		value, ok := strings.CutPrefix(line, "VmHWM:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "kB")), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb << 10
+	}
+	return 0
+}
+
+// gcPauses are the total and longest stop the world pause between two
+// MemStats, the longest out of the last 256 when there were more
+func gcPauses(m1, m2 *runtime.MemStats) (total, max time.Duration) {
+	total = time.Duration(m2.PauseTotalNs - m1.PauseTotalNs)
+	for n := m2.NumGC; n > m1.NumGC && m2.NumGC-n < uint32(len(m2.PauseNs)); n-- {
+		// the pause of collection n is at (n+255)%256
+		if p := time.Duration(m2.PauseNs[(n+uint32(len(m2.PauseNs))-1)%uint32(len(m2.PauseNs))]); p > max {
+			max = p
+		}
+	}
+	return total, max
+}
+
+// grown is b-a, 0 when it shrank instead of wrapping around
+func grown(a, b uint64) uint64 {
+	if b < a {
+		return 0
+	}
+	return b - a
+}
@@ -0,0 +1,390 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"time"
+
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	mdtest "github.com/ipfs/boxo/ipld/merkledag/test"
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+
+	"ipld-benchmark/myipld"
+)
+
+/* {comment}
+
+the same scenarios on merkledag ProtoNodes (DAG-PB) in an in-memory
+DAGService, the way IPFS builds a DAG, to hold MyNode up against. both
+sides build the DAG GenerateWithOptions makes from the same data bytes
+and links, children first, into their own store, so what differs is
+the node, its codec and the store around it
+
+{/comment} */
+
+// Implementation is one side of a head to head
+type Implementation struct {
+	Name        string
+	Generate    OpResult
+	Traverse    OpResult
+	Serialize   OpResult
+	Deserialize OpResult
+	// RetainedBytes is the heap a built DAG and its store hold on to
+	RetainedBytes int64
+	Blocks        int
+	BlockBytes    int64
+	MaxBlock      int
+}
+
+// Noisy names the operations whose samples vary too much to trust
+func (impl *Implementation) Noisy() []string {
+	var names []string
+	for _, op := range []struct {
+		name string
+		r    OpResult
+	}{
+		{"generate", impl.Generate},
+		{"traverse", impl.Traverse},
+		{"serialize", impl.Serialize},
+		{"deserialize", impl.Deserialize},
+	} {
+		if op.r.HighVariance {
+			names = append(names, op.name)
+		}
+	}
+	return names
+}
+
+// OpResult is an operation sampled by the runner, Time is the median,
+// Allocs and Bytes the mean per run
+type OpResult struct {
+	Time         time.Duration
+	Allocs       float64
+	Bytes        float64
+	HighVariance bool
+}
+
+func opResult(s *Stats) OpResult {
+	return OpResult{Time: s.Time.Median, Allocs: s.Allocs.Mean, Bytes: s.Bytes.Mean, HighVariance: s.HighVariance}
+}
+
+// contender builds, walks and codes one kind of node, build replaces
+// whatever the last build made
+type contender interface {
+	name() string
+	build(plan []planNode, root int) error
+	traverse() (int, error)
+	encode() error
+	decode() error
+	blocks() [][]byte
+}
+
+// HeadToHead runs the DAG opts describe on MyNode and on merkledag, in
+// that order, every operation sampled as ro says
+func HeadToHead(opts GenerateOptions, ro RunnerOptions) ([]*Implementation, error) {
+	root, nodes, err := GenerateWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	plan, rootIdx, err := planOf(root, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Implementation
+	for _, c := range []contender{&myipldContender{}, &merkledagContender{}} {
+		impl, err := runContender(c, plan, rootIdx, ro)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", c.name(), err)
+		}
+		out = append(out, impl)
+	}
+	return out, nil
+}
+
+func runContender(c contender, plan []planNode, root int, ro RunnerOptions) (*Implementation, error) {
+	impl := &Implementation{Name: c.name()}
+
+	var m1, m2 runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m1)
+	if err := c.build(plan, root); err != nil {
+		return nil, err
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&m2)
+	impl.RetainedBytes = int64(m2.HeapAlloc) - int64(m1.HeapAlloc)
+
+	for _, b := range c.blocks() {
+		impl.Blocks++
+		impl.BlockBytes += int64(len(b))
+		if len(b) > impl.MaxBlock {
+			impl.MaxBlock = len(b)
+		}
+	}
+
+	visited, err := c.traverse()
+	if err != nil {
+		return nil, err
+	}
+	if want := reachable(plan, root); visited != want {
+		return nil, fmt.Errorf("traversal visited %d of %d nodes", visited, want)
+	}
+
+	ops := []struct {
+		name   string
+		result *OpResult
+		fn     func() error
+	}{
+		{"generate", &impl.Generate, func() error { return c.build(plan, root) }},
+		{"traverse", &impl.Traverse, func() error {
+			_, err := c.traverse()
+			return err
+		}},
+		{"serialize", &impl.Serialize, c.encode},
+		{"deserialize", &impl.Deserialize, c.decode},
+	}
+	for _, op := range ops {
+		s, err := Sample(ro, op.fn)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", op.name, err)
+		}
+		*op.result = opResult(s)
+	}
+	return impl, nil
+}
+
+// planNode is a node either side can build, links index nodes before it
+type planNode struct {
+	data  []byte
+	names []string
+	links []int
+}
+
+// planOf orders the DAG children first, root is its index in the plan
+func planOf(root *myipld.MyNode, nodes []*myipld.MyNode) ([]planNode, int, error) {
+	index := make(map[myipld.MyCID]int, len(nodes))
+	for i, n := range nodes {
+		index[n.Cid] = i
+	}
+	plan := make([]planNode, 0, len(nodes))
+	at := make(map[int]int, len(nodes))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		if _, ok := at[i]; ok {
+			return nil
+		}
+		n := nodes[i]
+		p := planNode{data: n.Data}
+		for _, l := range n.Links {
+			j, ok := index[l.Cid]
+			if !ok {
+				return fmt.Errorf("node %s links to %s which is not in the DAG", n.Cid, l.Cid)
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+			p.names = append(p.names, l.Name)
+			p.links = append(p.links, at[j])
+		}
+		at[i] = len(plan)
+		plan = append(plan, p)
+		return nil
+	}
+	for i := range nodes {
+		if err := visit(i); err != nil {
+			return nil, 0, err
+		}
+	}
+	r, ok := index[root.Cid]
+	if !ok {
+		return nil, 0, fmt.Errorf("root %s is not in the DAG", root.Cid)
+	}
+	return plan, at[r], nil
+}
+
+// reachable counts the nodes under root, root included
+func reachable(plan []planNode, root int) int {
+	seen := map[int]bool{}
+	stack := []int{root}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		stack = append(stack, plan[i].links...)
+	}
+	return len(seen)
+}
+
+type myipldContender struct {
+	bs    *myipld.MemBlockstore
+	root  myipld.MyCID
+	nodes []*myipld.MyNode
+}
+
+func (c *myipldContender) name() string { return "myipld" }
+
+func (c *myipldContender) build(plan []planNode, root int) error {
+	c.bs = myipld.NewMemBlockstore()
+	c.nodes = make([]*myipld.MyNode, len(plan))
+	for i, p := range plan {
+		links := make([]myipld.MyLink, len(p.links))
+		for k, j := range p.links {
+			links[k] = myipld.MyLink{Name: p.names[k], Cid: c.nodes[j].Cid}
+		}
+		node, err := myipld.NewMyNodeWithLinks(json.RawMessage(p.data), links)
+		if err != nil {
+			return fmt.Errorf("node %d : %w", i, err)
+		}
+		if err := myipld.PutNode(c.bs, node); err != nil {
+			return err
+		}
+		c.nodes[i] = node
+	}
+	c.root = c.nodes[root].Cid
+	return nil
+}
+
+// traverse loads every node from the store, depth first from the root
+func (c *myipldContender) traverse() (int, error) {
+	seen := map[myipld.MyCID]bool{}
+	stack := []myipld.MyCID{c.root}
+	for len(stack) > 0 {
+		curr := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[curr] {
+			continue
+		}
+		seen[curr] = true
+		node, err := myipld.GetNode(c.bs, curr)
+		if err != nil {
+			return 0, err
+		}
+		for _, l := range node.Links {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return len(seen), nil
+}
+
+// encode works on copies, a node caches its bytes
+func (c *myipldContender) encode() error {
+	for _, n := range c.nodes {
+		fresh := &myipld.MyNode{Data: n.Data, Links: n.Links, Signature: n.Signature}
+		if _, err := fresh.ToBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *myipldContender) decode() error {
+	for _, n := range c.nodes {
+		raw, _ := n.ToBytes()
+		if _, err := myipld.FromBytes(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *myipldContender) blocks() [][]byte {
+	out := make([][]byte, len(c.nodes))
+	for i, n := range c.nodes {
+		out[i], _ = n.ToBytes()
+	}
+	return out
+}
+
+type merkledagContender struct {
+	ds    format.DAGService
+	root  cid.Cid
+	nodes []*merkledag.ProtoNode
+}
+
+func (c *merkledagContender) name() string { return "merkledag" }
+
+func (c *merkledagContender) build(plan []planNode, root int) error {
+	ctx := context.Background()
+	c.ds = mdtest.Mock()
+	c.nodes = make([]*merkledag.ProtoNode, len(plan))
+	for i, p := range plan {
+		node := merkledag.NodeWithData(append([]byte(nil), p.data...))
+		links := make([]*format.Link, len(p.links))
+		for k, j := range p.links {
+			l, err := format.MakeLink(c.nodes[j])
+			if err != nil {
+				return fmt.Errorf("node %d : %w", i, err)
+			}
+			l.Name = p.names[k]
+			links[k] = l
+		}
+		if err := node.SetLinks(links); err != nil {
+			return fmt.Errorf("node %d : %w", i, err)
+		}
+		if err := c.ds.Add(ctx, node); err != nil {
+			return err
+		}
+		c.nodes[i] = node
+	}
+	c.root = c.nodes[root].Cid()
+	return nil
+}
+
+func (c *merkledagContender) traverse() (int, error) {
+	ctx := context.Background()
+	seen := map[cid.Cid]bool{}
+	stack := []cid.Cid{c.root}
+	for len(stack) > 0 {
+		curr := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[curr] {
+			continue
+		}
+		seen[curr] = true
+		node, err := c.ds.Get(ctx, curr)
+		if err != nil {
+			return 0, err
+		}
+		for _, l := range node.Links() {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return len(seen), nil
+}
+
+// encode and decode hash as well, a MyNode always knows its CID
+func (c *merkledagContender) encode() error {
+	for _, n := range c.nodes {
+		if _, err := n.EncodeProtobuf(true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *merkledagContender) decode() error {
+	for _, n := range c.nodes {
+		node, err := merkledag.DecodeProtobuf(n.RawData())
+		if err != nil {
+			return err
+		}
+		node.Cid()
+	}
+	return nil
+}
+
+func (c *merkledagContender) blocks() [][]byte {
+	out := make([][]byte, len(c.nodes))
+	for i, n := range c.nodes {
+		out[i] = n.RawData()
+	}
+	return out
+}
@@ -10,10 +10,27 @@ import (
 type PerformanceMetrics struct {
 	TotalTime      time.Duration
 	NodesPerSecond float64
-	MemoryAlloc    uint64
-	MemoryTotal    uint64
-	GCPercentage   float64
+	// MemoryAlloc is how much the heap grew, 0 when it shrank, and
+	// MemoryTotal every byte allocated
+	MemoryAlloc uint64
+	MemoryTotal uint64
+	// GCPercentage is the share of the CPU the GC took, up to the last
+	// collection of the run
+	GCPercentage float64
+	NumGC        uint32
+	GCPauseTotal time.Duration
+	GCPauseMax   time.Duration
+	// HeapObjects is the change in live objects, negative when the run
+	// freed more than it kept
+	HeapObjects int64
+	// PeakHeapInuse is the most heap in use seen during the run, PeakRSS
+	// the resident high water mark (0 without /proc/self/status)
+	PeakHeapInuse uint64
+	PeakRSS       uint64
+	// SerializedSize is the raw encoded size, CompressedSize the same
+	// blocks after going through the Compressor (0 when not measured)
 	SerializedSize int
+	CompressedSize int
 }
 
 type DAGMetrics struct {
@@ -25,16 +42,39 @@ type DAGMetrics struct {
 	Diameter     int
 }
 
+// PlaybackMetrics are the viewer side numbers of a simulated playback,
+// PerformanceMetrics holds the real time and memory the simulation took
+type PlaybackMetrics struct {
+	PerformanceMetrics
+	TimeToFirstFrame time.Duration
+	Rebuffers        int
+	StallTime        time.Duration
+	BitrateSwitches  int
+	// AverageBitrate is in bits per second
+	AverageBitrate float64
+	Segments       int
+	BytesFetched   int64
+	// SessionTime is virtual time from pressing play to the last frame
+	SessionTime time.Duration
+	// DuplicateBlocks counts blocks that arrived more than once
+	DuplicateBlocks int
+}
+
 func CollectMetrics(runFunc func() error) (*PerformanceMetrics, error) {
 	var m1, m2 runtime.MemStats
 	runtime.GC()
+	resetPeakRSS()
 	runtime.ReadMemStats(&m1)
+	gc1, cpu1 := gcCPU()
+	stopWatch := watchHeap(HeapPollInterval)
 
 	start := time.Now()
 	err := runFunc()
 	duration := time.Since(start)
 
+	peakHeap := stopWatch()
 	runtime.ReadMemStats(&m2)
+	gc2, cpu2 := gcCPU()
 
 	if err != nil {
 		return nil, err
@@ -46,14 +86,51 @@ func CollectMetrics(runFunc func() error) (*PerformanceMetrics, error) {
 		nodesPerSecond = 1 / duration.Seconds()
 	}
 
+	gcPercentage := 0.0
+	if cpu2 > cpu1 {
+		gcPercentage = 100 * (gc2 - gc1) / (cpu2 - cpu1)
+	}
+	pauseTotal, pauseMax := gcPauses(&m1, &m2)
+	peakHeap = max(peakHeap, m1.HeapInuse, m2.HeapInuse)
+
 	return &PerformanceMetrics{
 		TotalTime:      duration,
 		NodesPerSecond: nodesPerSecond,
-		MemoryAlloc:    m2.Alloc - m1.Alloc,
-		MemoryTotal:    m2.TotalAlloc - m1.TotalAlloc,
+		MemoryAlloc:    grown(m1.Alloc, m2.Alloc),
+		MemoryTotal:    grown(m1.TotalAlloc, m2.TotalAlloc),
+		GCPercentage:   gcPercentage,
+		NumGC:          m2.NumGC - m1.NumGC,
+		GCPauseTotal:   pauseTotal,
+		GCPauseMax:     pauseMax,
+		HeapObjects:    int64(m2.HeapObjects) - int64(m1.HeapObjects),
+		PeakHeapInuse:  peakHeap,
+		PeakRSS:        peakRSS(),
 	}, nil
 }
 
+// MeasureSerializedSize sums the encoded size of every node, raw and
+// after compression
+func MeasureSerializedSize(nodes []*myipld.MyNode, c myipld.Compressor) (int, int, error) {
+	rawSize, compressedSize := 0, 0
+	for _, node := range nodes {
+		raw, err := node.ToBytes()
+		if err != nil {
+			return 0, 0, err
+		}
+		rawSize += len(raw)
+
+		if c == nil {
+			continue
+		}
+		compressed, err := c.Compress(raw)
+		if err != nil {
+			return 0, 0, err
+		}
+		compressedSize += len(compressed)
+	}
+	return rawSize, compressedSize, nil
+}
+
 // func AnalyzeDAGStructure(root *myipld.MyNode, allNodes []*myipld.MyNode) *DAGMetrics {
 // 	depths := make(map[myipld.MyCID]int)
 // 	maxDepth := 0
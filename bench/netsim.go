@@ -0,0 +1,139 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/netsim"
+)
+
+type SimFetchConfig struct {
+	NumPeers int
+	Seed     int64
+	Link     netsim.LinkConfig
+	// Protocol is "bitswap" (default) or "graphsync". the DAG is spread
+	// round robin over the seeders for bitswap, graphsync asks a single
+	// responder so that one gets a full copy
+	Protocol string
+	// RetryTimeout is needed whenever Link.Loss > 0
+	RetryTimeout time.Duration
+	// MaxInflight > 0 limits the fetcher to that many want-blocks at a
+	// time in the order Strategy picks, see Peer.SetScheduler
+	Strategy    exchange.Strategy
+	MaxInflight int
+}
+
+type SimFetchResult struct {
+	// VirtualTime is how long the fetch took on the simulated clock
+	VirtualTime time.Duration
+	Fetch       exchange.FetchStats
+	Network     netsim.Stats
+}
+
+func newSimSwarm(sim *netsim.Sim, numPeers int, retry time.Duration) ([]*exchange.Peer, []exchange.PeerID, error) {
+	nw := netsim.NewExchangeNetwork(sim)
+	peers := make([]*exchange.Peer, 0, numPeers)
+	ids := make([]exchange.PeerID, 0, numPeers)
+
+	for i := 0; i < numPeers; i++ {
+		id := exchange.PeerID(fmt.Sprintf("peer-%d", i))
+		p, err := exchange.NewPeer(id, myipld.NewMemBlockstore(), nw)
+		if err != nil {
+			return nil, nil, err
+		}
+		if retry > 0 {
+			p.SetRetryTimeout(retry, 10)
+		}
+		peers = append(peers, p)
+		ids = append(ids, id)
+	}
+	return peers, ids, nil
+}
+
+// SimulateFetch has peer-0 fetch the DAG from the other peers on a
+// simulated network. with the same DAG, config and seed the result is
+// identical on every run
+func SimulateFetch(root *myipld.MyNode, nodes []*myipld.MyNode, cfg SimFetchConfig) (*SimFetchResult, error) {
+	if cfg.NumPeers < 2 {
+		return nil, fmt.Errorf("need at least 2 peers, got %d", cfg.NumPeers)
+	}
+
+	sim := netsim.New(cfg.Seed)
+	sim.SetDefaultLink(cfg.Link)
+	peers, ids, err := newSimSwarm(sim, cfg.NumPeers, cfg.RetryTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, node := range nodes {
+		holder := peers[1+i%(cfg.NumPeers-1)]
+		if cfg.Protocol == "graphsync" {
+			holder = peers[1]
+		}
+		if err := myipld.PutNode(holder.Blockstore(), node); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.MaxInflight > 0 {
+		peers[0].SetScheduler(cfg.Strategy, cfg.MaxInflight)
+	}
+
+	var (
+		done     <-chan struct{}
+		finished func() (*exchange.FetchStats, error)
+	)
+	switch cfg.Protocol {
+	case "", "bitswap":
+		s := peers[0].NewSession(root.Cid, ids[1:])
+		s.Start()
+		done = s.Done()
+		finished = func() (*exchange.FetchStats, error) { return s.Stats(), s.Err() }
+	case "graphsync":
+		s := peers[0].NewGraphsyncSession(root.Cid, ids[1], exchange.SelectAll())
+		s.Start()
+		done = s.Done()
+		finished = func() (*exchange.FetchStats, error) { return s.Stats(), s.Err() }
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", cfg.Protocol)
+	}
+
+	if !runUntilDone(sim, done) {
+		return nil, fmt.Errorf("simulation ran out of events before the fetch finished (%s)", sim)
+	}
+
+	stats, err := finished()
+	if err != nil {
+		return nil, fmt.Errorf("simulated fetch failed: %w", err)
+	}
+	return &SimFetchResult{
+		VirtualTime: stats.Duration,
+		Fetch:       *stats,
+		Network:     sim.Stats(),
+	}, nil
+}
+
+// runUntilDone runs sim until done is closed, false if it ran out of
+// events first
+func runUntilDone(sim *netsim.Sim, done <-chan struct{}) bool {
+	return sim.RunUntil(func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+// BenchmarkSimulatedFetch generates the DAG and runs SimulateFetch on it,
+// e.g. a 10000 node BinaryTreeDAG over 100 peers
+func BenchmarkSimulatedFetch(structure DAGStructure, numNodes int, cfg SimFetchConfig) (*SimFetchResult, error) {
+	root, nodes, err := GenerateDAG(structure, numNodes)
+	if err != nil {
+		return nil, err
+	}
+	return SimulateFetch(root, nodes, cfg)
+}
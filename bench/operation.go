@@ -1,8 +1,9 @@
 package bench
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
-	"log"
 	"time"
 
 	"ipld-benchmark/myipld"
@@ -10,51 +11,113 @@ import (
 
 
 func BenchmarkDAGOperations(structure DAGStructure, numNodes int) (*PerformanceMetrics, *DAGMetrics, error) {
-	generateMetrics, err := CollectMetrics(func() error {
-		_, _, err := GenerateDAG(structure, numNodes)
+	return BenchmarkDAGOperationsWithOptions(GenerateOptions{Structure: structure, Nodes: numNodes}, myipld.GzipCompressor{})
+}
+
+// BenchmarkDAGOperationsWithOptions runs the same operations on the DAG
+// opts describe, CompressedSize goes through c (nil leaves it at 0)
+func BenchmarkDAGOperationsWithOptions(opts GenerateOptions, c myipld.Compressor) (*PerformanceMetrics, *DAGMetrics, error) {
+	perf, dagMetrics, _, err := BenchmarkDAGOperationsProfiled(opts, c, nil)
+	return perf, dagMetrics, err
+}
+
+// BenchmarkDAGOperationsProfiled is BenchmarkDAGOperationsWithOptions
+// with every phase run again under prof once it is measured, nil
+// profiles nothing and returns nil Profiles
+func BenchmarkDAGOperationsProfiled(opts GenerateOptions, c myipld.Compressor, prof *Profiler) (*PerformanceMetrics, *DAGMetrics, *Profiles, error) {
+	profiles := &Profiles{}
+	collect := func(phase string, into *PhaseProfile, fn func() error) (*PerformanceMetrics, error) {
+		m, err := CollectMetrics(fn)
+		if err != nil || prof == nil {
+			return m, err
+		}
+		pp, err := prof.Phase(phase, fn)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", phase, err)
+		}
+		*into = *pp
+		return m, nil
+	}
+
+	numNodes := opts.Nodes
+	generateMetrics, err := collect("generate", &profiles.Generate, func() error {
+		_, _, err := GenerateWithOptions(opts)
 		return err
 	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("DAG generation failed: %w", err)
+		return nil, nil, nil, fmt.Errorf("DAG generation failed: %w", err)
 	}
 	generateMetrics.NodesPerSecond = float64(numNodes) / generateMetrics.TotalTime.Seconds()
 
-	root, nodes, err := GenerateDAG(structure, numNodes)
+	root, nodes, err := GenerateWithOptions(opts)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	traversalMetrics, err := CollectMetrics(func() error {
+	traversalMetrics, err := collect("traverse", &profiles.Traverse, func() error {
 		BenchmarkTraversal(root, nodes)
 		return nil
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	serializationMetrics, err := CollectMetrics(func() error {
-		BenchmarkSerialization(root)
-		return nil
+	serializationMetrics, err := collect("serialize", &profiles.Serialize, func() error {
+		return serializeAll(nodes)
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	deserializationMetrics, err := CollectMetrics(func() error {
-		BenchmarkDeserialization(root)
-		return nil
+	blocks, err := blocksOf(nodes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	deserializationMetrics, err := collect("deserialize", &profiles.Deserialize, func() error {
+		return deserializeAll(blocks)
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	serializedSize, compressedSize, err := MeasureSerializedSize(nodes, c)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("measuring serialized size failed: %w", err)
 	}
 	combinedMetrics := &PerformanceMetrics{
 		TotalTime:      generateMetrics.TotalTime + traversalMetrics.TotalTime + serializationMetrics.TotalTime + deserializationMetrics.TotalTime,
 		NodesPerSecond: generateMetrics.NodesPerSecond,
 		MemoryAlloc:    generateMetrics.MemoryAlloc + traversalMetrics.MemoryAlloc + serializationMetrics.MemoryAlloc + deserializationMetrics.MemoryAlloc,
 		MemoryTotal:    generateMetrics.MemoryTotal + traversalMetrics.MemoryTotal + serializationMetrics.MemoryTotal + deserializationMetrics.MemoryTotal,
+		SerializedSize: serializedSize,
+		CompressedSize: compressedSize,
 	}
+	combineGC(combinedMetrics, generateMetrics, traversalMetrics, serializationMetrics, deserializationMetrics)
 	dagMetrics := AnalyzeDAGStructure(root, nodes)
+	dagMetrics.PerformanceMetrics = *combinedMetrics
 
-	return combinedMetrics, dagMetrics, nil
+	if prof == nil {
+		profiles = nil
+	}
+	return combinedMetrics, dagMetrics, profiles, nil
+}
+
+// combineGC adds up the GC numbers of phases into m, the GC share is
+// weighted by how long each phase ran and the peaks are the highest
+func combineGC(m *PerformanceMetrics, phases ...*PerformanceMetrics) {
+	var weighted float64
+	var total time.Duration
+	for _, p := range phases {
+		m.NumGC += p.NumGC
+		m.GCPauseTotal += p.GCPauseTotal
+		m.GCPauseMax = max(m.GCPauseMax, p.GCPauseMax)
+		m.HeapObjects += p.HeapObjects
+		m.PeakHeapInuse = max(m.PeakHeapInuse, p.PeakHeapInuse)
+		m.PeakRSS = max(m.PeakRSS, p.PeakRSS)
+		weighted += p.GCPercentage * p.TotalTime.Seconds()
+		total += p.TotalTime
+	}
+	if total > 0 {
+		m.GCPercentage = weighted / total.Seconds()
+	}
 }
 
 func BenchmarkTraversal(root *myipld.MyNode, allNodes []*myipld.MyNode) {
@@ -107,20 +170,195 @@ func BenchmarkDeserialization(node *myipld.MyNode) {
 	myipld.FromBytes(data)
 }
 
-func BenchmarkCustomNodeCreation(numNodes int) {
-	start := time.Now()
+// BenchmarkCustomNodeCreation times creating numNodes nodes one by one
+func BenchmarkCustomNodeCreation(numNodes int) (*PerformanceMetrics, error) {
+	m, err := CollectMetrics(func() error {
+		return createNodes(numNodes, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.NodesPerSecond = float64(numNodes) / m.TotalTime.Seconds()
+	return m, nil
+}
+
+// BenchmarkSignedNodeCreation is BenchmarkCustomNodeCreation plus an
+// ed25519 signature per node, the difference between the two is the
+// signing overhead
+func BenchmarkSignedNodeCreation(numNodes int) (*PerformanceMetrics, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating signing key : %w", err)
+	}
+
+	m, err := CollectMetrics(func() error {
+		return createNodes(numNodes, priv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.NodesPerSecond = float64(numNodes) / m.TotalTime.Seconds()
+	return m, nil
+}
+
+// serializeAll encodes every node, copies of them since a node caches
+// its bytes
+func serializeAll(nodes []*myipld.MyNode) error {
+	for _, n := range nodes {
+		fresh := &myipld.MyNode{Data: n.Data, Links: n.Links, Signature: n.Signature}
+		if _, err := fresh.ToBytes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deserializeAll decodes every block
+func deserializeAll(blocks [][]byte) error {
+	for _, raw := range blocks {
+		if _, err := myipld.FromBytes(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blocksOf are the encoded nodes, in order
+func blocksOf(nodes []*myipld.MyNode) ([][]byte, error) {
+	blocks := make([][]byte, len(nodes))
+	for i, n := range nodes {
+		raw, err := n.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = raw
+	}
+	return blocks, nil
+}
+
+// createNodes makes numNodes nodes, signed when priv is set
+func createNodes(numNodes int, priv ed25519.PrivateKey) error {
 	for i := 0; i < numNodes; i++ {
 		data := map[string]interface{}{
 			"id":        i,
 			"timestamp": time.Now().UnixNano(),
 			"random":    "some-random-string-to-vary-data-size-and-hash",
 		}
-		_, err := myipld.NewMyNode(data)
+		node, err := myipld.NewMyNode(data)
+		if err != nil {
+			return fmt.Errorf("creating node %d : %w", i, err)
+		}
+		if priv == nil {
+			continue
+		}
+		if err := node.Sign(priv); err != nil {
+			return fmt.Errorf("signing node %d : %w", i, err)
+		}
+	}
+	return nil
+}
+
+// OperationStats are the operations of BenchmarkDAGOperations and node
+// creation, each run through Sample
+type OperationStats struct {
+	Generate           Stats
+	Traverse           Stats
+	Serialize          Stats
+	Deserialize        Stats
+	NodeCreation       Stats
+	SignedNodeCreation Stats
+}
+
+// NamedStats is one operation of OperationStats
+type NamedStats struct {
+	Name  string
+	Stats *Stats
+}
+
+// Named lists the operations with the names errors and reports use
+func (s *OperationStats) Named() []NamedStats {
+	return []NamedStats{
+		{"generate", &s.Generate},
+		{"traverse", &s.Traverse},
+		{"serialize", &s.Serialize},
+		{"deserialize", &s.Deserialize},
+		{"node creation", &s.NodeCreation},
+		{"signed node creation", &s.SignedNodeCreation},
+	}
+}
+
+// SampleDAGOperations samples every operation on the DAG opts describe,
+// generation makes a new DAG each run, the others share one and code
+// every node of it
+func SampleDAGOperations(opts GenerateOptions, ro RunnerOptions) (*OperationStats, error) {
+	root, nodes, err := GenerateWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := blocksOf(nodes)
+	if err != nil {
+		return nil, err
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating signing key : %w", err)
+	}
+
+	// in the order of Named
+	fns := []func() error{
+		func() error {
+			_, _, err := GenerateWithOptions(opts)
+			return err
+		},
+		func() error {
+			BenchmarkTraversal(root, nodes)
+			return nil
+		},
+		func() error { return serializeAll(nodes) },
+		func() error { return deserializeAll(blocks) },
+		func() error { return createNodes(opts.Nodes, nil) },
+		func() error { return createNodes(opts.Nodes, priv) },
+	}
+	stats := &OperationStats{}
+	for i, op := range stats.Named() {
+		s, err := Sample(ro, fns[i])
 		if err != nil {
-			log.Printf("Error creating custom node %d: %v", i, err)
-			return
+			return nil, fmt.Errorf("%s : %w", op.Name, err)
+		}
+		*op.Stats = *s
+	}
+	return stats, nil
+}
+
+// Performance is what the samples of an operation on nodes nodes say
+// as PerformanceMetrics, the median time and the mean bytes allocated
+func (s *Stats) Performance(nodes int) PerformanceMetrics {
+	m := PerformanceMetrics{TotalTime: s.Time.Median, MemoryTotal: uint64(s.Bytes.Mean)}
+	if s.Time.Median > 0 {
+		m.NodesPerSecond = float64(nodes) / s.Time.Median.Seconds()
+	}
+	return m
+}
+
+// DAGPerformance is Performance for generation, traversal and
+// (de)serialization together, nodes per second is generation's
+func (s *OperationStats) DAGPerformance(nodes int) PerformanceMetrics {
+	m := s.Generate.Performance(nodes)
+	for _, op := range []*Stats{&s.Traverse, &s.Serialize, &s.Deserialize} {
+		p := op.Performance(nodes)
+		m.TotalTime += p.TotalTime
+		m.MemoryTotal += p.MemoryTotal
+	}
+	return m
+}
+
+// Noisy names the operations whose samples vary too much to trust
+func (s *OperationStats) Noisy() []string {
+	var names []string
+	for _, op := range s.Named() {
+		if op.Stats.HighVariance {
+			names = append(names, op.Name)
 		}
 	}
-	duration := time.Since(start)
-	fmt.Printf("  Node Creation (%d Custom IPLD nodes): %s\n", numNodes, duration)
-}
\ No newline at end of file
+	return names
+}
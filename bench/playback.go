@@ -0,0 +1,264 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/netsim"
+	"ipld-benchmark/player"
+)
+
+type PlaybackConfig struct {
+	// NumPeers counts the viewer, the others seed the title
+	NumPeers int
+	Seed     int64
+	Link     netsim.LinkConfig
+	// Protocol fetches segments with "bitswap" (default) from every
+	// seeder or "graphsync" from the seeder holding the segment
+	Protocol     string
+	RetryTimeout time.Duration
+	Player       player.Config
+	// Lookahead is how many segments are fetched at once, 1 by default
+	Lookahead int
+	// MaxInflight > 0 has the viewer request blocks in the order
+	// Strategy picks, see Peer.SetScheduler. segments carry the time the
+	// player needs them as their deadline
+	Strategy    exchange.Strategy
+	MaxInflight int
+	// Replicas > 1 puts every segment on 1 to Replicas seeders picked at
+	// random, so some blocks are rarer than others
+	Replicas int
+	// ViewerDownlink caps what the viewer receives from all seeders
+	// together in bytes per second, 0 for no cap
+	ViewerDownlink float64
+}
+
+// SimulatePlayback has peer-0 play title on a simulated network. the
+// title and rendition manifests are on every seeder, segment i of every
+// rendition on seeder i round robin (and the Replicas after it). the
+// viewer first pulls the manifests with one depth-1 graphsync request,
+// then keeps Lookahead segments in flight in the rendition the player
+// asks for
+func SimulatePlayback(title *media.Title, src myipld.BlockGetter, cfg PlaybackConfig) (*PlaybackMetrics, error) {
+	var result *PlaybackMetrics
+	perf, err := CollectMetrics(func() error {
+		var err error
+		result, err = simulatePlayback(title, src, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.PerformanceMetrics = *perf
+	return result, nil
+}
+
+func simulatePlayback(title *media.Title, src myipld.BlockGetter, cfg PlaybackConfig) (*PlaybackMetrics, error) {
+	if cfg.NumPeers < 2 {
+		return nil, fmt.Errorf("need at least 2 peers, got %d", cfg.NumPeers)
+	}
+
+	sim := netsim.New(cfg.Seed)
+	sim.SetDefaultLink(cfg.Link)
+	peers, ids, err := newSimSwarm(sim, cfg.NumPeers, cfg.RetryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	viewer, seeders := peers[0], ids[1:]
+	if err := seedTitle(title, src, peers[1:], cfg.Replicas, sim); err != nil {
+		return nil, err
+	}
+	if cfg.ViewerDownlink > 0 {
+		sim.SetNodeBandwidth(string(viewer.ID()), 0, cfg.ViewerDownlink)
+	}
+	if cfg.MaxInflight > 0 {
+		viewer.SetScheduler(cfg.Strategy, cfg.MaxInflight)
+	}
+	lookahead := cfg.Lookahead
+	if lookahead <= 0 {
+		lookahead = 1
+	}
+
+	start := sim.Now()
+	manifests := viewer.NewGraphsyncSession(title.Node.Cid, seeders[0], exchange.Selector{MaxDepth: 1})
+	manifests.Start()
+	if !runUntilDone(sim, manifests.Done()) || manifests.Err() != nil {
+		return nil, fmt.Errorf("failed to fetch the manifests: %v", manifests.Err())
+	}
+	// play what was fetched, not what we were handed
+	fetched, err := media.LoadTitle(viewer.Blockstore(), title.Node.Cid)
+	if err != nil {
+		return nil, err
+	}
+
+	renditions := make([]media.Rendition, len(fetched.Renditions))
+	for i, r := range fetched.Renditions {
+		renditions[i] = r.Rendition
+	}
+	p := player.New(cfg.Player, renditions, fetched.Duration, start)
+
+	var window []*segmentFetch
+	duplicates := 0
+	for next := 0; next < fetched.SegmentCount() || len(window) > 0; {
+		for next < fetched.SegmentCount() && len(window) < lookahead {
+			// what is in flight counts against MaxBuffer already
+			ahead := fetched.Renditions[0].Asset.Segments[next].Duration
+			for _, f := range window {
+				ahead += f.seg.Duration
+			}
+			wait := p.Wait(sim.Now(), ahead)
+			if wait > 0 && len(window) > 0 {
+				break
+			}
+			if wait > 0 {
+				sim.RunFor(wait)
+			}
+
+			r := p.Next(sim.Now())
+			seg := fetched.Renditions[r].Asset.Segments[next]
+			f, err := startSegment(viewer, seg, r, seeders, next, cfg.Protocol, p.Deadline(sim.Now(), seg))
+			if err != nil {
+				return nil, err
+			}
+			window = append(window, f)
+			next++
+		}
+
+		// segments play in order, so only the oldest one is waited for
+		head := window[0]
+		window = window[1:]
+		if !runUntilDone(sim, head.done) {
+			return nil, fmt.Errorf("simulation ran out of events before segment %d arrived (%s)", head.seg.Index, sim)
+		}
+		stats, err := head.finished()
+		if err != nil {
+			return nil, fmt.Errorf("segment %d of %s : %w", head.seg.Index, renditions[head.rendition].Name, err)
+		}
+		p.Loaded(sim.Now(), head.seg, head.rendition, int64(stats.Bytes), stats.Duration)
+		duplicates += stats.Duplicates
+	}
+
+	final := p.Finish(sim.Now())
+	return &PlaybackMetrics{
+		TimeToFirstFrame: final.TimeToFirstFrame,
+		Rebuffers:        final.Rebuffers,
+		StallTime:        final.StallTime,
+		BitrateSwitches:  final.BitrateSwitches,
+		AverageBitrate:   final.AverageBitrate,
+		Segments:         final.Segments,
+		BytesFetched:     final.Bytes,
+		SessionTime:      final.SessionTime,
+		DuplicateBlocks:  duplicates,
+	}, nil
+}
+
+type segmentFetch struct {
+	seg       media.Segment
+	rendition int
+	done      <-chan struct{}
+	finished  func() (*exchange.FetchStats, error)
+}
+
+func startSegment(viewer *exchange.Peer, seg media.Segment, rendition int, seeders []exchange.PeerID, index int, protocol string, deadline time.Time) (*segmentFetch, error) {
+	f := &segmentFetch{seg: seg, rendition: rendition}
+	switch protocol {
+	case "", "bitswap":
+		s := viewer.NewSession(seg.Cid, seeders)
+		s.SetDeadline(deadline)
+		s.Start()
+		f.done = s.Done()
+		f.finished = func() (*exchange.FetchStats, error) { return s.Stats(), s.Err() }
+	case "graphsync":
+		s := viewer.NewGraphsyncSession(seg.Cid, seeders[index%len(seeders)], exchange.SelectAll())
+		s.Start()
+		f.done = s.Done()
+		f.finished = func() (*exchange.FetchStats, error) { return s.Stats(), s.Err() }
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", protocol)
+	}
+	return f, nil
+}
+
+func seedTitle(title *media.Title, src myipld.BlockGetter, seeders []*exchange.Peer, replicas int, sim *netsim.Sim) error {
+	for _, s := range seeders {
+		if err := copyBlock(src, s.Blockstore(), title.Node.Cid); err != nil {
+			return err
+		}
+		for _, r := range title.Renditions {
+			if err := copyBlock(src, s.Blockstore(), r.Asset.Manifest.Cid); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range title.Renditions {
+		for i, seg := range r.Asset.Segments {
+			blocks := make(map[myipld.MyCID]bool)
+			if err := collectBlocks(src, seg.Cid, blocks, make(map[myipld.MyCID]int64)); err != nil {
+				return err
+			}
+			copies := 1
+			if replicas > 1 {
+				copies += sim.Rand().Intn(replicas)
+			}
+			if copies > len(seeders) {
+				copies = len(seeders)
+			}
+			for k := 0; k < copies; k++ {
+				dst := seeders[(i+k)%len(seeders)].Blockstore()
+				for cid := range blocks {
+					if err := copyBlock(src, dst, cid); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func copyBlock(src myipld.BlockGetter, dst myipld.Blockstore, cid myipld.MyCID) error {
+	raw, err := src.Get(cid)
+	if err != nil {
+		return fmt.Errorf("block %s : %w", cid, err)
+	}
+	return dst.Put(cid, raw)
+}
+
+// BenchmarkPlayback plays a synthetic title over ladder on a simulated
+// network, see SimulatePlayback
+func BenchmarkPlayback(duration, segDur time.Duration, ladder []media.Rendition, cfg PlaybackConfig) (*PlaybackMetrics, error) {
+	bs := myipld.NewMemBlockstore()
+	title, err := SyntheticTitle("playback", duration, segDur, ladder, cfg.Seed, bs)
+	if err != nil {
+		return nil, err
+	}
+	return SimulatePlayback(title, bs, cfg)
+}
+
+type StrategyResult struct {
+	Strategy string
+	Metrics  *PlaybackMetrics
+}
+
+// CompareStrategies plays title once per strategy with everything else
+// in cfg the same, cfg.MaxInflight defaults to 4 so the strategies have
+// a queue to order
+func CompareStrategies(title *media.Title, src myipld.BlockGetter, cfg PlaybackConfig, strategies []exchange.Strategy) ([]StrategyResult, error) {
+	if cfg.MaxInflight <= 0 {
+		cfg.MaxInflight = 4
+	}
+	results := make([]StrategyResult, 0, len(strategies))
+	for _, strategy := range strategies {
+		cfg.Strategy = strategy
+		m, err := SimulatePlayback(title, src, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strategy.Name(), err)
+		}
+		results = append(results, StrategyResult{Strategy: strategy.Name(), Metrics: m})
+	}
+	return results, nil
+}
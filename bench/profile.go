@@ -0,0 +1,301 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+/* {comment}
+
+a Profiler records what a phase of BenchmarkDAGOperations spent its
+time and memory on, one file per kind and phase in Dir:
+
+	<name>.<phase>.cpu.pprof      CPU while the phase ran
+	<name>.<phase>.heap.pprof     what is in use after it
+	<name>.<phase>.allocs.pprof   everything it allocated
+	<name>.<phase>.block.pprof    where it waited on channels and locks
+	<name>.<phase>.mutex.pprof    where it held up other goroutines
+	<name>.<phase>.trace.out      execution trace, go tool trace reads it
+
+a phase runs again and again until MinTime is up, like testing.B, so
+that a phase of a few milliseconds still gets CPU samples at 100Hz and
+allocates more than the 512KB between two allocation samples. the
+allocs, block and mutex profiles count from the start of the process,
+the files are the difference across the phase like net/http/pprof does
+with ?seconds, without the samples of the profilers themselves. block
+and mutex profiling are on only while a phase runs
+
+{/comment} */
+
+type Profiler struct {
+	// Dir is where the files go, created when missing
+	Dir string
+	// Name starts every file name, a scenario with / turned into -
+	Name string
+	// Top is how many functions a summary keeps, 10 when zero
+	Top int
+	// MinTime is how long a phase keeps running, 1s when zero
+	MinTime time.Duration
+	// BlockProfileRate is the rate block profiling goes back to after a
+	// phase, the runtime has no way to ask for it. 0 is off
+	BlockProfileRate int
+}
+
+// PhaseProfile is where the profiles of a phase went and the functions
+// that took the most CPU and allocated the most bytes in it, over Runs
+// runs of the phase
+type PhaseProfile struct {
+	Runs      int
+	Files     []string
+	TopCPU    []HotFunction
+	TopAllocs []HotFunction
+}
+
+// Profiles are the phases of BenchmarkDAGOperations
+type Profiles struct {
+	Generate    PhaseProfile
+	Traverse    PhaseProfile
+	Serialize   PhaseProfile
+	Deserialize PhaseProfile
+}
+
+// HotFunction is a function and what it took of a profile, Flat in its
+// own code and Cum with everything it called, in Unit
+type HotFunction struct {
+	Function string
+	Flat     int64
+	Cum      int64
+	// Share is Flat over the whole profile
+	Share float64
+	Unit  string
+}
+
+func (h HotFunction) String() string {
+	return fmt.Sprintf("%.1f%% %s %s (cum %s)", h.Share*100, h.amount(h.Flat), h.Function, h.amount(h.Cum))
+}
+
+func (h HotFunction) amount(v int64) string {
+	if h.Unit == "nanoseconds" {
+		return time.Duration(v).String()
+	}
+	return fmt.Sprintf("%d %s", v, h.Unit)
+}
+
+// Phase runs fn for MinTime with every profile on and writes them out
+// named after phase, an error of fn comes back as is
+func (p *Profiler) Phase(phase string, fn func() error) (*PhaseProfile, error) {
+	if err := os.MkdirAll(p.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(p.Dir, strings.ReplaceAll(p.Name, "/", "-")+"."+phase)
+	pp := &PhaseProfile{}
+
+	cumulative := []string{"allocs", "block", "mutex"}
+	runtime.SetBlockProfileRate(1)
+	mutexRate := runtime.SetMutexProfileFraction(1)
+	defer func() {
+		runtime.SetBlockProfileRate(p.BlockProfileRate)
+		runtime.SetMutexProfileFraction(mutexRate)
+	}()
+	before := make(map[string]*profile.Profile)
+	// the allocs profile is as of the last GC
+	runtime.GC()
+	for _, name := range cumulative {
+		prof, err := snapshot(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s profile : %w", name, err)
+		}
+		before[name] = prof
+	}
+
+	cpuFile, err := os.Create(base + ".cpu.pprof")
+	if err != nil {
+		return nil, err
+	}
+	defer cpuFile.Close()
+	traceFile, err := os.Create(base + ".trace.out")
+	if err != nil {
+		return nil, err
+	}
+	defer traceFile.Close()
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		return nil, fmt.Errorf("cpu profile : %w", err)
+	}
+	if err := trace.Start(traceFile); err != nil {
+		pprof.StopCPUProfile()
+		return nil, fmt.Errorf("trace : %w", err)
+	}
+	var runErr error
+	for start := time.Now(); runErr == nil; {
+		runErr = fn()
+		pp.Runs++
+		if time.Since(start) >= p.minTime() {
+			break
+		}
+	}
+	trace.Stop()
+	pprof.StopCPUProfile()
+	if runErr != nil {
+		return nil, runErr
+	}
+	pp.Files = append(pp.Files, cpuFile.Name(), traceFile.Name())
+
+	runtime.GC()
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return nil, fmt.Errorf("heap profile : %w", err)
+	}
+	if err := os.WriteFile(base+".heap.pprof", heap.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	pp.Files = append(pp.Files, base+".heap.pprof")
+
+	for _, name := range cumulative {
+		after, err := snapshot(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s profile : %w", name, err)
+		}
+		before[name].Scale(-1)
+		delta, err := profile.Merge([]*profile.Profile{before[name], after})
+		if err != nil {
+			return nil, fmt.Errorf("%s profile : %w", name, err)
+		}
+		delta.TimeNanos = after.TimeNanos
+		delta.DurationNanos = after.TimeNanos - before[name].TimeNanos
+		delta.FilterSamplesByName(nil, profilerFrames, nil, nil)
+		if err := writeProfile(base+"."+name+".pprof", delta); err != nil {
+			return nil, err
+		}
+		pp.Files = append(pp.Files, base+"."+name+".pprof")
+		if name == "allocs" {
+			pp.TopAllocs = topFunctions(delta, "alloc_space", p.top())
+		}
+	}
+
+	// the file is complete once the profile stopped
+	if _, err := cpuFile.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	cpu, err := profile.Parse(cpuFile)
+	if err != nil {
+		return nil, fmt.Errorf("cpu profile : %w", err)
+	}
+	cpu.FilterSamplesByName(nil, profilerFrames, nil, nil)
+	pp.TopCPU = topFunctions(cpu, "cpu", p.top())
+	return pp, nil
+}
+
+// profilerFrames are the profilers at work while the phase runs,
+// starting, stopping, writing out and reading back the snapshots
+var profilerFrames = regexp.MustCompile(`^(runtime/(pprof|trace)|github\.com/google/pprof/profile)\.`)
+
+func (p *Profiler) top() int {
+	if p.Top <= 0 {
+		return 10
+	}
+	return p.Top
+}
+
+func (p *Profiler) minTime() time.Duration {
+	if p.MinTime <= 0 {
+		return time.Second
+	}
+	return p.MinTime
+}
+
+func snapshot(name string) (*profile.Profile, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup(name).WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return profile.Parse(&buf)
+}
+
+func writeProfile(path string, prof *profile.Profile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = prof.Write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// topFunctions ranks the functions of prof by their flat share of the
+// sampleType values, the most first
+func topFunctions(prof *profile.Profile, sampleType string, n int) []HotFunction {
+	idx := -1
+	for i, st := range prof.SampleType {
+		if st.Type == sampleType {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil
+	}
+
+	flat, cum := make(map[string]int64), make(map[string]int64)
+	var total int64
+	for _, s := range prof.Sample {
+		v := s.Value[idx]
+		if v <= 0 {
+			continue
+		}
+		total += v
+		// a function recursing or inlined twice counts once towards cum
+		seen := make(map[string]bool)
+		for i, loc := range s.Location {
+			// Line[0] is the innermost of what got inlined here
+			for j, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				name := line.Function.Name
+				if i == 0 && j == 0 {
+					flat[name] += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					cum[name] += v
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(cum))
+	for name := range cum {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if flat[a] != flat[b] {
+			return flat[a] > flat[b]
+		}
+		if cum[a] != cum[b] {
+			return cum[a] > cum[b]
+		}
+		return a < b
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	unit := prof.SampleType[idx].Unit
+	top := make([]HotFunction, len(names))
+	for i, name := range names {
+		top[i] = HotFunction{Function: name, Flat: flat[name], Cum: cum[name], Share: float64(flat[name]) / float64(total), Unit: unit}
+	}
+	return top
+}
@@ -0,0 +1,329 @@
+package bench
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"time"
+)
+
+/* {comment}
+
+CollectMetrics times one run, which on a shared machine is mostly
+noise. Sample runs a function Warmup times without looking, then
+Samples times with a GC before each, and summarizes time and
+allocations per run over the samples that are not outliers
+
+outliers are samples whose time is outside Tukey's fences, more than
+OutlierK interquartile ranges below the first or above the third
+quartile. the allocations of a dropped sample go with it. what is left
+gets mean, median, stddev, percentiles and a 95% confidence interval of
+the mean (Student's t), and HighVariance when the coefficient of
+variation is above MaxCV, at that point the mean says little
+
+{/comment} */
+
+type RunnerOptions struct {
+	Warmup int
+	// Samples is 10 when zero
+	Samples int
+	// OutlierK is the Tukey fence in IQRs, 1.5 when zero, negative keeps
+	// every sample
+	OutlierK float64
+	// MaxCV is the stddev/mean above which a result is HighVariance, 0.1
+	// when zero
+	MaxCV float64
+}
+
+// DefaultRunnerOptions are a warmup run and 10 samples
+var DefaultRunnerOptions = RunnerOptions{Warmup: 1, Samples: 10}
+
+func (o RunnerOptions) withDefaults() RunnerOptions {
+	if o.Samples <= 0 {
+		o.Samples = DefaultRunnerOptions.Samples
+	}
+	if o.Warmup < 0 {
+		o.Warmup = 0
+	}
+	if o.OutlierK == 0 {
+		o.OutlierK = 1.5
+	}
+	if o.MaxCV == 0 {
+		o.MaxCV = 0.1
+	}
+	return o
+}
+
+// Summary describes a set of samples
+type Summary struct {
+	Mean   float64
+	Median float64
+	StdDev float64
+	Min    float64
+	Max    float64
+	P50    float64
+	P90    float64
+	P99    float64
+	// CILow and CIHigh bound the mean with 95% confidence
+	CILow  float64
+	CIHigh float64
+	// CV is StdDev / Mean
+	CV float64
+}
+
+// TimeSummary is a Summary of nanoseconds as durations
+type TimeSummary struct {
+	Mean   time.Duration
+	Median time.Duration
+	StdDev time.Duration
+	Min    time.Duration
+	Max    time.Duration
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	CILow  time.Duration
+	CIHigh time.Duration
+	CV     float64
+}
+
+func durations(s Summary) TimeSummary {
+	d := func(ns float64) time.Duration { return time.Duration(math.Round(ns)) }
+	return TimeSummary{
+		Mean:   d(s.Mean),
+		Median: d(s.Median),
+		StdDev: d(s.StdDev),
+		Min:    d(s.Min),
+		Max:    d(s.Max),
+		P50:    d(s.P50),
+		P90:    d(s.P90),
+		P99:    d(s.P99),
+		CILow:  d(s.CILow),
+		CIHigh: d(s.CIHigh),
+		CV:     s.CV,
+	}
+}
+
+// Stats are the samples of one function, Time per run, Allocs in
+// allocations and Bytes in allocated bytes per run
+type Stats struct {
+	Samples      int
+	Dropped      int
+	Time         TimeSummary
+	Allocs       Summary
+	Bytes        Summary
+	HighVariance bool
+	// Times is every sample in nanoseconds, outliers too, for comparing
+	// runs later
+	Times []float64 `report:"-"`
+}
+
+// Sample runs fn as opts say and summarizes the runs, the first error
+// stops it
+func Sample(opts RunnerOptions, fn func() error) (*Stats, error) {
+	opts = opts.withDefaults()
+	for i := 0; i < opts.Warmup; i++ {
+		if err := fn(); err != nil {
+			return nil, err
+		}
+	}
+
+	times := make([]float64, opts.Samples)
+	allocs := make([]float64, opts.Samples)
+	bytes := make([]float64, opts.Samples)
+	var m1, m2 runtime.MemStats
+	for i := 0; i < opts.Samples; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&m1)
+		start := time.Now()
+		err := fn()
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&m2)
+		if err != nil {
+			return nil, err
+		}
+		// Mallocs and TotalAlloc only grow
+		times[i] = float64(elapsed.Nanoseconds())
+		allocs[i] = float64(m2.Mallocs - m1.Mallocs)
+		bytes[i] = float64(m2.TotalAlloc - m1.TotalAlloc)
+	}
+
+	keep := inliers(times, opts.OutlierK)
+	s := &Stats{
+		Samples: opts.Samples,
+		Dropped: opts.Samples - len(keep),
+		Time:    durations(Summarize(pick(times, keep))),
+		Allocs:  Summarize(pick(allocs, keep)),
+		Bytes:   Summarize(pick(bytes, keep)),
+		Times:   times,
+	}
+	s.HighVariance = s.Time.CV > opts.MaxCV
+	return s, nil
+}
+
+// inliers are the indexes of the values inside the fences, everything
+// with fewer than 4 values or a negative k
+func inliers(values []float64, k float64) []int {
+	keep := make([]int, 0, len(values))
+	lo, hi := math.Inf(-1), math.Inf(1)
+	if len(values) >= 4 && k >= 0 {
+		sorted := sortedCopy(values)
+		q1, q3 := percentile(sorted, 25), percentile(sorted, 75)
+		lo, hi = q1-k*(q3-q1), q3+k*(q3-q1)
+	}
+	for i, v := range values {
+		if v >= lo && v <= hi {
+			keep = append(keep, i)
+		}
+	}
+	return keep
+}
+
+func pick(values []float64, idx []int) []float64 {
+	out := make([]float64, len(idx))
+	for i, j := range idx {
+		out[i] = values[j]
+	}
+	return out
+}
+
+// Summarize describes values, the zero Summary when there are none
+func Summarize(values []float64) Summary {
+	n := len(values)
+	if n == 0 {
+		return Summary{}
+	}
+	sorted := sortedCopy(values)
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(n)
+	s := Summary{
+		Mean:   mean,
+		Median: percentile(sorted, 50),
+		Min:    sorted[0],
+		Max:    sorted[n-1],
+		P50:    percentile(sorted, 50),
+		P90:    percentile(sorted, 90),
+		P99:    percentile(sorted, 99),
+		CILow:  mean,
+		CIHigh: mean,
+	}
+	if n < 2 {
+		return s
+	}
+
+	sq := 0.0
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	s.StdDev = math.Sqrt(sq / float64(n-1))
+	half := tCritical(n-1) * s.StdDev / math.Sqrt(float64(n))
+	s.CILow, s.CIHigh = mean-half, mean+half
+	if mean != 0 {
+		s.CV = s.StdDev / math.Abs(mean)
+	}
+	return s
+}
+
+func sortedCopy(values []float64) []float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// percentile interpolates between the closest ranks of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	if lo >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := rank - float64(lo)
+	return sorted[lo] + frac*(sorted[lo+1]-sorted[lo])
+}
+
+// tTable is the two sided 95% critical value of Student's t for 1 to 30
+// degrees of freedom
+var tTable = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+func tCritical(df int) float64 {
+	switch {
+	case df <= 0:
+		return math.Inf(1)
+	case df <= len(tTable):
+		return tTable[df-1]
+	case df <= 40:
+		return 2.021
+	case df <= 60:
+		return 2.000
+	case df <= 120:
+		return 1.980
+	}
+	return 1.960
+}
+
+/* {comment}
+
+MannWhitney tests whether samples a and b come from the same
+distribution without assuming either is normal, which benchmark times
+rarely are. U counts the pairs where a is above b (ties count half),
+the p value is two sided from the normal approximation with a
+continuity and tie correction, good enough from about 8 samples a side
+
+{/comment} */
+
+func MannWhitney(a, b []float64) (u, p float64) {
+	n1, n2 := len(a), len(b)
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]obs, 0, n1+n2)
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// ties share the average of their ranks
+	n := float64(n1 + n2)
+	rankA, ties := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	u = rankA - float64(n1*(n1+1))/2
+	mean := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	z := math.Max(math.Abs(u-mean)-0.5, 0) / sigma
+	return u, math.Erfc(z / math.Sqrt2)
+}
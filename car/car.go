@@ -0,0 +1,355 @@
+package car
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"ipld-benchmark/myipld"
+)
+
+var ErrInvalidCAR = errors.New("invalid car")
+
+// MaxSectionSize bounds a single block section, anything larger is
+// treated as a broken stream rather than allocated
+const MaxSectionSize = 8 << 20
+
+/* {comment}
+
+CARv1 is a header followed by sections, every section is a varint length
+then the CID and the block bytes. the header is the dag-cbor map
+{"roots": [cid...], "version": 1} which is small enough to encode and
+decode by hand instead of pulling in a cbor library
+
+our blocks are sha256 of their bytes, on the wire they become CIDv1 with
+the raw codec so any IPFS client can check them without knowing how
+myipld encodes nodes
+
+{/comment} */
+
+const (
+	cborTagCID = 42
+)
+
+// ToCid is the CIDv1 (raw, sha2-256) of a block
+func ToCid(c myipld.MyCID) cid.Cid {
+	mh, err := multihash.Encode(c.Hash[:], multihash.SHA2_256)
+	if err != nil {
+		// only fails for unknown codes or a digest of the wrong length
+		panic(err)
+	}
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+// FromCid takes any CID version or codec as long as the hash is sha2-256
+func FromCid(c cid.Cid) (myipld.MyCID, error) {
+	var out myipld.MyCID
+	decoded, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return out, fmt.Errorf("cid %s : %w", c, err)
+	}
+	if decoded.Code != multihash.SHA2_256 || len(decoded.Digest) != myipld.HashSize {
+		return out, fmt.Errorf("cid %s : unsupported hash %s", c, multihash.Codes[decoded.Code])
+	}
+	copy(out.Hash[:], decoded.Digest)
+	return out, nil
+}
+
+// ParseCid accepts a multibase CID string or the hex form of MyCID
+func ParseCid(s string) (myipld.MyCID, error) {
+	if len(s) == 2*myipld.HashSize {
+		if c, err := myipld.ParseMyCID(s); err == nil {
+			return c, nil
+		}
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return myipld.MyCID{}, fmt.Errorf("invalid cid %q : %w", s, err)
+	}
+	return FromCid(c)
+}
+
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter writes the header for roots, blocks follow with Put
+func NewWriter(w io.Writer, roots ...myipld.MyCID) (*Writer, error) {
+	cw := &Writer{w: w}
+	if err := cw.section(encodeHeader(roots)); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *Writer) Put(c myipld.MyCID, data []byte) error {
+	return cw.section(ToCid(c).Bytes(), data)
+}
+
+func (cw *Writer) section(parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	cw.buf = binary.AppendUvarint(cw.buf[:0], uint64(size))
+	for _, p := range parts {
+		cw.buf = append(cw.buf, p...)
+	}
+	_, err := cw.w.Write(cw.buf)
+	return err
+}
+
+type Reader struct {
+	r     *bufio.Reader
+	Roots []myipld.MyCID
+}
+
+// NewReader reads the header, Next returns the blocks
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+	header, err := cr.readSection()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty stream", ErrInvalidCAR)
+	}
+	if err != nil {
+		return nil, err
+	}
+	roots, err := decodeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	cr.Roots = roots
+	return cr, nil
+}
+
+// Next returns the next block as the CID it was sent under and its
+// bytes, io.EOF at the end. the bytes are not checked against the CID,
+// that is up to the caller
+func (cr *Reader) Next() (myipld.MyCID, []byte, error) {
+	section, err := cr.readSection()
+	if err != nil {
+		return myipld.MyCID{}, nil, err
+	}
+	n, c, err := cid.CidFromBytes(section)
+	if err != nil {
+		return myipld.MyCID{}, nil, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+	}
+	mc, err := FromCid(c)
+	if err != nil {
+		return myipld.MyCID{}, nil, err
+	}
+	return mc, section[n:], nil
+}
+
+func (cr *Reader) readSection() ([]byte, error) {
+	size, err := binary.ReadUvarint(cr.r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: section length : %v", ErrInvalidCAR, err)
+	}
+	if size == 0 || size > MaxSectionSize {
+		return nil, fmt.Errorf("%w: section of %d bytes", ErrInvalidCAR, size)
+	}
+	section := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, section); err != nil {
+		return nil, fmt.Errorf("%w: truncated section : %v", ErrInvalidCAR, err)
+	}
+	return section, nil
+}
+
+// cbor major types
+const (
+	cborUint  = 0
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+	cborTag   = 6
+)
+
+func encodeHeader(roots []myipld.MyCID) []byte {
+	var b []byte
+	b = cborHead(b, cborMap, 2)
+	// dag-cbor sorts keys by length first, "roots" before "version"
+	b = cborString(b, "roots")
+	b = cborHead(b, cborArray, uint64(len(roots)))
+	for _, r := range roots {
+		raw := ToCid(r).Bytes()
+		b = cborHead(b, cborTag, cborTagCID)
+		// the leading 0x00 is the identity multibase prefix
+		b = cborHead(b, cborBytes, uint64(len(raw)+1))
+		b = append(b, 0)
+		b = append(b, raw...)
+	}
+	b = cborString(b, "version")
+	return cborHead(b, cborUint, 1)
+}
+
+func cborString(b []byte, s string) []byte {
+	b = cborHead(b, cborText, uint64(len(s)))
+	return append(b, s...)
+}
+
+func cborHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= 0xff:
+		return append(b, m|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, m|27), n)
+	}
+}
+
+type cborDecoder struct {
+	b []byte
+}
+
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if len(d.b) == 0 {
+		return 0, 0, fmt.Errorf("%w: header ends early", ErrInvalidCAR)
+	}
+	major, info := d.b[0]>>5, d.b[0]&0x1f
+	d.b = d.b[1:]
+
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("%w: indefinite lengths are not dag-cbor", ErrInvalidCAR)
+	}
+	if len(d.b) < size {
+		return 0, 0, fmt.Errorf("%w: header ends early", ErrInvalidCAR)
+	}
+	var n uint64
+	for _, c := range d.b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	d.b = d.b[size:]
+	return major, n, nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if uint64(len(d.b)) < n {
+		return nil, fmt.Errorf("%w: header ends early", ErrInvalidCAR)
+	}
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out, nil
+}
+
+func decodeHeader(header []byte) ([]myipld.MyCID, error) {
+	d := &cborDecoder{b: header}
+	major, fields, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborMap {
+		return nil, fmt.Errorf("%w: header is not a map", ErrInvalidCAR)
+	}
+
+	var roots []myipld.MyCID
+	version := uint64(0)
+	for i := uint64(0); i < fields; i++ {
+		major, n, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if major != cborText {
+			return nil, fmt.Errorf("%w: header key is not a string", ErrInvalidCAR)
+		}
+		key, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+
+		switch string(key) {
+		case "version":
+			if major, version, err = d.head(); err != nil {
+				return nil, err
+			}
+			if major != cborUint {
+				return nil, fmt.Errorf("%w: version is not an integer", ErrInvalidCAR)
+			}
+		case "roots":
+			if roots, err = decodeRoots(d); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: unexpected header field %q", ErrInvalidCAR, key)
+		}
+	}
+
+	if version != 1 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCAR, version)
+	}
+	return roots, nil
+}
+
+func decodeRoots(d *cborDecoder) ([]myipld.MyCID, error) {
+	major, count, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborArray {
+		return nil, fmt.Errorf("%w: roots is not a list", ErrInvalidCAR)
+	}
+	// every root takes a byte at least, a bigger count is a lie
+	if count > uint64(len(d.b)) {
+		return nil, fmt.Errorf("%w: %d roots with %d bytes of header left", ErrInvalidCAR, count, len(d.b))
+	}
+
+	roots := make([]myipld.MyCID, 0, count)
+	for i := uint64(0); i < count; i++ {
+		major, tag, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if major != cborTag || tag != cborTagCID {
+			return nil, fmt.Errorf("%w: root is not a cid", ErrInvalidCAR)
+		}
+		major, n, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		if major != cborBytes || len(raw) < 2 || raw[0] != 0 {
+			return nil, fmt.Errorf("%w: malformed root cid", ErrInvalidCAR)
+		}
+		c, err := cid.Cast(raw[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCAR, err)
+		}
+		mc, err := FromCid(c)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, mc)
+	}
+	return roots, nil
+}
@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const Name = "ipld-benchmark"
+
+// exit codes, the same for every subcommand
+const (
+	ExitOK = 0
+	// ExitError is a command that was understood but failed
+	ExitError = 1
+	// ExitUsage is a bad subcommand, flag or argument
+	ExitUsage = 2
+	// ExitRegression is compare finding a slowdown past
+	// -fail-on-regression
+	ExitRegression = 3
+)
+
+/* {comment}
+
+every subcommand gets its own flag set and reports back through an
+error, Run turns that into a single line on stderr and an exit code:
+
+	ipld-benchmark: <command>: <message>
+
+a usageError is the caller's fault and exits 2 with a hint, a
+regressionError exits 3, anything else exits 1. results go to stdout
+in the format asked for, nothing but results ever goes there
+
+{/comment} */
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(env *env, args []string) error
+}
+
+type env struct {
+	cmd    command
+	stdout io.Writer
+	stderr io.Writer
+	color  colorMode
+}
+
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// regressionError is compare failing the gate, the comparison itself
+// went fine
+type regressionError struct {
+	msg string
+}
+
+func (e *regressionError) Error() string {
+	return e.msg
+}
+
+func commands() []command {
+	return []command{
+		{"generate", "", "generate a DAG and write it as a CAR", runGenerate},
+		{"bench", "", "benchmark every -structure and -nodes given (comma separated)", runBench},
+		{"analyze", "[in.car]", "shape and size of a DAG, generated when no CAR is given", runAnalyze},
+		{"export", "in.car", "write a DAG as the JSON description or a CAR of a subtree", runExport},
+		{"import", "file", "import a media file as an asset", runImport},
+		{"inspect", "in.car [cid]", "show one block, the root by default", runInspect},
+		{"diff", "a.car b.car", "compare the blocks of two DAGs", runDiff},
+		{"compare", "[before] [after]", "test two bench runs from the history for significant changes", runCompare},
+		{"versus", "", "run every -structure and -nodes on MyNode and on merkledag ProtoNodes side by side", runVersus},
+	}
+}
+
+// Run runs the command line args (without the program name) and returns
+// the exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	e := &env{stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		printUsage(stderr)
+		return ExitUsage
+	}
+
+	name := args[0]
+	switch name {
+	case "-h", "-help", "--help", "help":
+		printUsage(stdout)
+		return ExitOK
+	}
+	for _, c := range commands() {
+		if c.name != name {
+			continue
+		}
+		e.cmd = c
+		err := c.run(e, args[1:])
+		var usage *usageError
+		var regression *regressionError
+		switch {
+		case err == nil:
+			return ExitOK
+		case errors.Is(err, flag.ErrHelp):
+			return ExitOK
+		case errors.As(err, &usage):
+			fmt.Fprintf(stderr, "%s: %s: %v\n", Name, name, err)
+			fmt.Fprintf(stderr, "run '%s %s -h' for usage\n", Name, name)
+			return ExitUsage
+		case errors.As(err, &regression):
+			fmt.Fprintf(stderr, "%s: %s: %v\n", Name, name, err)
+			return ExitRegression
+		default:
+			fmt.Fprintf(stderr, "%s: %s: %v\n", Name, name, err)
+			return ExitError
+		}
+	}
+
+	fmt.Fprintf(stderr, "%s: unknown command %q\n", Name, name)
+	printUsage(stderr)
+	return ExitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [args]\n\ncommands:\n", Name)
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nrun '%s <command> -h' for the flags of a command\n", Name)
+}
+
+// flagSet reports errors instead of printing them, Run prints the one
+// line. -h prints the usage to stdout
+type flagSet struct {
+	*flag.FlagSet
+	e *env
+}
+
+func newFlags(e *env) *flagSet {
+	fs := flag.NewFlagSet(e.cmd.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	return &flagSet{FlagSet: fs, e: e}
+}
+
+func (fs *flagSet) help() {
+	fmt.Fprintf(fs.e.stdout, "usage: %s %s [flags] %s\n\n%s\n\nflags:\n", Name, fs.e.cmd.name, fs.e.cmd.args, fs.e.cmd.summary)
+	fs.SetOutput(fs.e.stdout)
+	fs.PrintDefaults()
+	fs.SetOutput(io.Discard)
+}
+
+// parse parses flags and checks the number of positional args, flags
+// may come after them too (inspect x.car -format json)
+func (fs *flagSet) parse(args []string, min, max int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				fs.help()
+				return nil, err
+			}
+			return nil, &usageError{msg: err.Error()}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) < min || (max >= 0 && len(positional) > max) {
+		return nil, usagef("expected %s, got %d", argCount(min, max), len(positional))
+	}
+	return positional, nil
+}
+
+func argCount(min, max int) string {
+	switch {
+	case min == max:
+		return fmt.Sprintf("%d argument(s)", min)
+	case max < 0:
+		return fmt.Sprintf("at least %d argument(s)", min)
+	default:
+		return fmt.Sprintf("%d to %d arguments", min, max)
+	}
+}
+
+// choice checks a flag value against the allowed ones
+func choice(flagName, value string, allowed []string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return usagef("-%s must be one of %s, got %q", flagName, strings.Join(allowed, ", "), value)
+}
@@ -0,0 +1,688 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/car"
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/report"
+	"ipld-benchmark/source"
+)
+
+// shape is DAGMetrics without the empty PerformanceMetrics it embeds
+type shape struct {
+	MaxDepth     int
+	AverageDepth float64
+	MaxBreadth   int
+	LinkDensity  float64
+	Diameter     int
+}
+
+func shapeOf(m *bench.DAGMetrics) shape {
+	return shape{
+		MaxDepth:     m.MaxDepth,
+		AverageDepth: m.AverageDepth,
+		MaxBreadth:   m.MaxBreadth,
+		LinkDensity:  m.LinkDensity,
+		Diameter:     m.Diameter,
+	}
+}
+
+func cidString(c myipld.MyCID) string {
+	return car.ToCid(c).String()
+}
+
+type generateResult struct {
+	Root      string
+	Structure string
+	Nodes     int
+	Seed      int64
+	Bytes     int64
+	Output    string
+}
+
+func runGenerate(e *env, args []string) error {
+	fs := newFlags(e)
+	var d dagFlags
+	d.register(fs)
+	out := fs.String("o", "-", "CAR file to write, - for stdout")
+	format := formatFlag(fs)
+	if _, err := fs.parse(args, 0, 0); err != nil {
+		return err
+	}
+	opts, err := d.options()
+	if err != nil {
+		return err
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+
+	root, nodes, err := bench.GenerateWithOptions(opts)
+	if err != nil {
+		return err
+	}
+	size, err := writeCAR(e, *out, []myipld.MyCID{root.Cid}, nodes)
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		// stdout is the CAR
+		return nil
+	}
+	return output(e, *format, generateResult{
+		Root:      cidString(root.Cid),
+		Structure: opts.Structure.String(),
+		Nodes:     len(nodes),
+		Seed:      opts.Seed,
+		Bytes:     size,
+		Output:    *out,
+	})
+}
+
+func runBench(e *env, args []string) error {
+	fs := newFlags(e)
+	var d dagFlags
+	d.register(fs)
+	var ro runnerFlags
+	ro.register(fs)
+	history := fs.String("history", report.DefaultHistory, "append the records to this history, empty for none")
+	profileDir := fs.String("profile", "", "write CPU, heap, allocs, block and mutex profiles and a trace of every phase to this directory")
+	top := fs.Int("profile-top", 10, "hot functions to report per phase with -profile")
+	profileTime := fs.Duration("profile-time", time.Second, "how long every phase runs over and over with -profile")
+	codec := codecFlag(fs)
+	format := formatFlag(fs)
+	if _, err := fs.parse(args, 0, 0); err != nil {
+		return err
+	}
+	matrix, err := d.matrix()
+	if err != nil {
+		return err
+	}
+	runner, err := ro.options()
+	if err != nil {
+		return err
+	}
+	c, err := compressor(*codec)
+	if err != nil {
+		return err
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+	if *top <= 0 {
+		return usagef("-profile-top must be positive, got %d", *top)
+	}
+	if *profileTime <= 0 {
+		return usagef("-profile-time must be positive, got %s", *profileTime)
+	}
+
+	run, commit := report.NewRunID(time.Now()), report.GitCommit()
+	records := make([]*report.Record, 0, len(matrix))
+	for _, opts := range matrix {
+		var prof *bench.Profiler
+		if *profileDir != "" {
+			prof = &bench.Profiler{Dir: *profileDir, Name: report.Scenario(opts, *codec), Top: *top, MinTime: *profileTime}
+		}
+		rec, err := report.Benchmark(opts, *codec, c, runner, prof)
+		if err != nil {
+			return fmt.Errorf("%s : %w", report.Scenario(opts, *codec), err)
+		}
+		for _, op := range rec.Stats.Noisy() {
+			warnf(e, "%s %s varies more than -max-cv, don't trust its mean", rec.Scenario, op)
+		}
+		rec.Run, rec.Commit = run, commit
+		records = append(records, rec)
+	}
+
+	values := make([]interface{}, len(records))
+	for i, rec := range records {
+		values[i] = rec
+	}
+	if err := output(e, *format, values...); err != nil {
+		return err
+	}
+	if *history == "" {
+		return nil
+	}
+	if err := report.AppendHistory(*history, records...); err != nil {
+		return fmt.Errorf("history : %w", err)
+	}
+	return nil
+}
+
+// warnf is a line on stderr that doesn't fail the command
+func warnf(e *env, format string, args ...interface{}) {
+	fmt.Fprintf(e.stderr, "%s: %s: warning: %s\n", Name, e.cmd.name, fmt.Sprintf(format, args...))
+}
+
+func runCompare(e *env, args []string) error {
+	fs := newFlags(e)
+	history := fs.String("history", report.DefaultHistory, "history bench appended the runs to")
+	alpha := fs.Float64("alpha", 0.05, "significance level of the Mann-Whitney U test")
+	var failAt float64
+	fs.Func("fail-on-regression", "exit 3 when an operation got significantly slower by more than this many percent, e.g. 5%", func(s string) error {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("want a positive percentage")
+		}
+		failAt = v
+		return nil
+	})
+	format := formatFlag(fs)
+	refs, err := fs.parse(args, 0, 2)
+	if err != nil {
+		return err
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		return usagef("-alpha must be between 0 and 1, got %g", *alpha)
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+	// previous against latest, one ref against latest
+	beforeRef, afterRef := "previous", "latest"
+	switch len(refs) {
+	case 1:
+		beforeRef = refs[0]
+	case 2:
+		beforeRef, afterRef = refs[0], refs[1]
+	}
+
+	runs, err := report.ReadHistory(*history)
+	if err != nil {
+		return err
+	}
+	before, err := report.FindRun(runs, beforeRef)
+	if err != nil {
+		return err
+	}
+	after, err := report.FindRun(runs, afterRef)
+	if err != nil {
+		return err
+	}
+
+	comparisons := report.Compare(before, after, report.CompareOptions{Alpha: *alpha, Threshold: failAt})
+	if len(comparisons) == 0 {
+		return fmt.Errorf("runs %s and %s have no scenario in common", before.ID, after.ID)
+	}
+	values := make([]interface{}, len(comparisons))
+	regressions := 0
+	warned := make(map[string]bool)
+	for i, c := range comparisons {
+		values[i] = c
+		if c.Regression {
+			regressions++
+		}
+		// every scenario has its own records, and so its own environment
+		if !c.SameEnvironment && !warned[c.Scenario] {
+			warned[c.Scenario] = true
+			warnf(e, "%s: the Go version or CPU differ between %s and %s", c.Scenario, before.ID, after.ID)
+		}
+	}
+	if err := output(e, *format, values...); err != nil {
+		return err
+	}
+	if failAt > 0 && regressions > 0 {
+		return &regressionError{msg: fmt.Sprintf("%d operation(s) more than %g%% slower from %s to %s", regressions, failAt, before.ID, after.ID)}
+	}
+	return nil
+}
+
+// versusResult is one implementation on one scenario
+type versusResult struct {
+	Scenario string
+	bench.Implementation
+}
+
+func runVersus(e *env, args []string) error {
+	fs := newFlags(e)
+	var d dagFlags
+	d.register(fs)
+	var ro runnerFlags
+	ro.register(fs)
+	format := formatFlag(fs)
+	if _, err := fs.parse(args, 0, 0); err != nil {
+		return err
+	}
+	matrix, err := d.matrix()
+	if err != nil {
+		return err
+	}
+	runner, err := ro.options()
+	if err != nil {
+		return err
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+
+	var values []interface{}
+	for _, opts := range matrix {
+		scenario := fmt.Sprintf("%s/%d", opts.Structure, opts.Nodes)
+		impls, err := bench.HeadToHead(opts, runner)
+		if err != nil {
+			return fmt.Errorf("%s : %w", scenario, err)
+		}
+		for _, impl := range impls {
+			for _, op := range impl.Noisy() {
+				warnf(e, "%s %s %s varies more than -max-cv, don't trust its median", scenario, impl.Name, op)
+			}
+			values = append(values, versusResult{Scenario: scenario, Implementation: *impl})
+		}
+	}
+	return output(e, *format, values...)
+}
+
+type analyzeResult struct {
+	Root   string
+	Source string
+	Nodes  int
+	Codec  string
+	// SerializedSize and CompressedSize are summed over every block
+	SerializedSize int
+	CompressedSize int
+	Shape          shape
+}
+
+func runAnalyze(e *env, args []string) error {
+	fs := newFlags(e)
+	var d dagFlags
+	d.register(fs)
+	var src sourceFlags
+	src.register(fs)
+	codec := codecFlag(fs)
+	format := formatFlag(fs)
+	files, err := fs.parse(args, 0, 1)
+	if err != nil {
+		return err
+	}
+	if src.command != "" && len(files) > 0 {
+		return usagef("-source-cmd replaces the file argument")
+	}
+	c, err := compressor(*codec)
+	if err != nil {
+		return err
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+
+	var root *myipld.MyNode
+	var nodes []*myipld.MyNode
+	var from string
+	switch {
+	case len(files) == 1:
+		loaded, err := readCAR(files[0])
+		if err != nil {
+			return err
+		}
+		root, nodes, from = loaded.node(loaded.Root), loaded.Nodes, files[0]
+	case src.command != "":
+		_, _, loaded, err := loadDescription(src.run)
+		if err != nil {
+			return err
+		}
+		// reachable lists the first root first
+		root, nodes, from = loaded[0], loaded, src.command
+	default:
+		opts, err := d.options()
+		if err != nil {
+			return err
+		}
+		if root, nodes, err = bench.GenerateWithOptions(opts); err != nil {
+			return err
+		}
+		from = opts.Structure.String()
+	}
+
+	raw, compressed, err := bench.MeasureSerializedSize(nodes, c)
+	if err != nil {
+		return err
+	}
+	return output(e, *format, analyzeResult{
+		Root:           cidString(root.Cid),
+		Source:         from,
+		Nodes:          len(nodes),
+		Codec:          *codec,
+		SerializedSize: raw,
+		CompressedSize: compressed,
+		Shape:          shapeOf(bench.AnalyzeDAGStructure(root, nodes)),
+	})
+}
+
+func runExport(e *env, args []string) error {
+	fs := newFlags(e)
+	to := fs.String("to", "json", "what to write: json (the DAG description, ids are CIDs) or car")
+	rootFlag := fs.String("root", "", "export the DAG under this cid instead of the CAR root")
+	out := fs.String("o", "-", "file to write, - for stdout")
+	files, err := fs.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if err := choice("to", *to, []string{"json", "car"}); err != nil {
+		return err
+	}
+
+	d, err := readCAR(files[0])
+	if err != nil {
+		return err
+	}
+	root := d.Root
+	if *rootFlag != "" {
+		if root, err = car.ParseCid(*rootFlag); err != nil {
+			return &usageError{msg: err.Error()}
+		}
+	}
+	nodes, err := reachable(d, root)
+	if err != nil {
+		return err
+	}
+
+	if *to == "car" {
+		_, err := writeCAR(e, *out, []myipld.MyCID{root}, nodes)
+		return err
+	}
+
+	// signatures are not part of the description and get dropped
+	desc := source.Description{Nodes: []source.Node{}, Links: []source.Link{}}
+	for _, n := range nodes {
+		id := cidString(n.Cid)
+		desc.Nodes = append(desc.Nodes, source.Node{ID: id, Data: n.Data})
+		for _, l := range n.Links {
+			desc.Links = append(desc.Links, source.Link{Source: id, Target: cidString(l.Cid), Name: l.Name})
+		}
+	}
+	return writeJSON(e, *out, desc)
+}
+
+// reachable is every node under roots, depth first in link order
+func reachable(d *dag, roots ...myipld.MyCID) ([]*myipld.MyNode, error) {
+	var nodes []*myipld.MyNode
+	seen := make(map[myipld.MyCID]bool)
+	var walk func(c myipld.MyCID) error
+	walk = func(c myipld.MyCID) error {
+		if seen[c] {
+			return nil
+		}
+		seen[c] = true
+		node, err := myipld.GetNode(d.Store, c)
+		if errors.Is(err, myipld.ErrNotFound) {
+			return fmt.Errorf("%w: %s is not in the car", myipld.ErrNotFound, cidString(c))
+		}
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		for _, l := range node.Links {
+			if err := walk(l.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, root := range roots {
+		if err := walk(root); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+type importResult struct {
+	Root     string
+	Name     string
+	Size     int64
+	Segments int
+	Blocks   int
+	Bytes    int64
+	Output   string
+}
+
+// descriptionResult is an import of a JSON DAG description
+type descriptionResult struct {
+	Roots  []string
+	Nodes  int
+	Links  int
+	Bytes  int64
+	Output string
+}
+
+func runImport(e *env, args []string) error {
+	fs := newFlags(e)
+	from := fs.String("from", "media", "what the file is: media (imported as an asset) or json (a DAG description, - for stdin)")
+	name := fs.String("name", "", "asset name, the file name by default")
+	duration := fs.Duration("duration", time.Minute, "running time of the file")
+	segment := fs.Duration("segment", 6*time.Second, "segment duration")
+	chunk := fs.Int("chunk-size", media.DefaultChunkSize, "leaf block size")
+	hasher := hasherFlag(fs)
+	var src sourceFlags
+	src.register(fs)
+	out := fs.String("o", "-", "CAR file to write, - for stdout")
+	format := formatFlag(fs)
+	files, err := fs.parse(args, 0, 1)
+	if err != nil {
+		return err
+	}
+	if err := choice("from", *from, []string{"media", "json"}); err != nil {
+		return err
+	}
+	if src.command != "" && len(files) > 0 {
+		return usagef("-source-cmd replaces the file argument")
+	}
+	if src.command == "" && len(files) == 0 {
+		return usagef("expected a file or -source-cmd")
+	}
+	if err := choice("hasher", *hasher, hashers); err != nil {
+		return err
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+	if src.command != "" {
+		return importDescription(e, *out, *format, func(bs myipld.Blockstore) (*source.Result, error) {
+			return src.run(bs)
+		})
+	}
+	if *from == "json" {
+		return importDescription(e, *out, *format, func(bs myipld.Blockstore) (*source.Result, error) {
+			if files[0] == "-" {
+				return source.Load(os.Stdin, bs)
+			}
+			return source.LoadFile(files[0], bs)
+		})
+	}
+	if *duration <= 0 || *segment <= 0 || *chunk <= 0 {
+		return usagef("-duration, -segment and -chunk-size must be positive")
+	}
+
+	bs := myipld.NewMemBlockstore()
+	asset, err := media.ImportFile(files[0], media.ImportOptions{
+		Name:            *name,
+		Duration:        *duration,
+		SegmentDuration: *segment,
+		ChunkSize:       *chunk,
+	}, bs)
+	if err != nil {
+		return err
+	}
+	nodes, err := reachable(&dag{Store: bs}, asset.Manifest.Cid)
+	if err != nil {
+		return err
+	}
+	size, err := writeCAR(e, *out, []myipld.MyCID{asset.Manifest.Cid}, nodes)
+	if err != nil || *out == "-" {
+		return err
+	}
+	return output(e, *format, importResult{
+		Root:     cidString(asset.Manifest.Cid),
+		Name:     asset.Name,
+		Size:     asset.Size,
+		Segments: len(asset.Segments),
+		Blocks:   len(nodes),
+		Bytes:    size,
+		Output:   *out,
+	})
+}
+
+// importDescription writes the DAG load puts into a Blockstore as a CAR
+func importDescription(e *env, out, format string, load func(myipld.Blockstore) (*source.Result, error)) error {
+	res, roots, nodes, err := loadDescription(load)
+	if err != nil {
+		return err
+	}
+	if _, err := writeCAR(e, out, roots, nodes); err != nil || out == "-" {
+		return err
+	}
+
+	result := descriptionResult{Nodes: res.Nodes, Links: res.Links, Bytes: res.Bytes, Output: out}
+	for _, r := range res.Roots {
+		result.Roots = append(result.Roots, r.ID+"  "+cidString(r.Cid))
+	}
+	return output(e, format, result)
+}
+
+func loadDescription(load func(myipld.Blockstore) (*source.Result, error)) (*source.Result, []myipld.MyCID, []*myipld.MyNode, error) {
+	bs := myipld.NewMemBlockstore()
+	res, err := load(bs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	roots := make([]myipld.MyCID, len(res.Roots))
+	for i, r := range res.Roots {
+		roots[i] = r.Cid
+	}
+	nodes, err := reachable(&dag{Store: bs}, roots...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return res, roots, nodes, nil
+}
+
+type linkResult struct {
+	Name string
+	Cid  string
+}
+
+func (l linkResult) String() string {
+	return l.Name + "  " + l.Cid
+}
+
+type inspectResult struct {
+	Cid    string
+	Hex    string
+	Size   int
+	Signer string `json:",omitempty"`
+	Data   json.RawMessage
+	Links  []linkResult
+}
+
+func runInspect(e *env, args []string) error {
+	fs := newFlags(e)
+	format := formatFlag(fs)
+	pos, err := fs.parse(args, 1, 2)
+	if err != nil {
+		return err
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+
+	d, err := readCAR(pos[0])
+	if err != nil {
+		return err
+	}
+	c := d.Root
+	if len(pos) == 2 {
+		if c, err = car.ParseCid(pos[1]); err != nil {
+			return &usageError{msg: err.Error()}
+		}
+	}
+	data, err := d.Store.Get(c)
+	if err != nil {
+		return fmt.Errorf("%s : %w", cidString(c), err)
+	}
+	node := d.node(c)
+
+	res := inspectResult{Cid: cidString(c), Hex: c.Hex(), Size: len(data), Data: node.Data, Links: []linkResult{}}
+	if node.Signature != nil {
+		res.Signer = node.Signature.KeyID
+	}
+	for _, l := range node.Links {
+		res.Links = append(res.Links, linkResult{Name: l.Name, Cid: cidString(l.Cid)})
+	}
+	return output(e, *format, res)
+}
+
+type diffResult struct {
+	RootA    string
+	RootB    string
+	SameRoot bool
+	BlocksA  int
+	BlocksB  int
+	Shared   int
+	// OnlyInA and OnlyInB are CIDs in the order of their CAR
+	OnlyInA      []string
+	OnlyInB      []string
+	BytesOnlyInA int64
+	BytesOnlyInB int64
+}
+
+// runDiff compares block sets, a difference is a result and not an
+// error so it exits 0, SameRoot says whether the DAGs are the same
+func runDiff(e *env, args []string) error {
+	fs := newFlags(e)
+	format := formatFlag(fs)
+	files, err := fs.parse(args, 2, 2)
+	if err != nil {
+		return err
+	}
+	if err := choice("format", *format, formats); err != nil {
+		return err
+	}
+
+	a, err := readCAR(files[0])
+	if err != nil {
+		return err
+	}
+	b, err := readCAR(files[1])
+	if err != nil {
+		return err
+	}
+
+	res := diffResult{
+		RootA:    cidString(a.Root),
+		RootB:    cidString(b.Root),
+		SameRoot: a.Root == b.Root,
+		BlocksA:  len(a.Nodes),
+		BlocksB:  len(b.Nodes),
+	}
+	res.OnlyInA, res.BytesOnlyInA, res.Shared = missingFrom(a, b)
+	res.OnlyInB, res.BytesOnlyInB, _ = missingFrom(b, a)
+	return output(e, *format, res)
+}
+
+// missingFrom lists the blocks of a that b does not have
+func missingFrom(a, b *dag) ([]string, int64, int) {
+	missing := []string{}
+	var bytes int64
+	shared := 0
+	for _, n := range a.Nodes {
+		if ok, _ := b.Store.Has(n.Cid); ok {
+			shared++
+			continue
+		}
+		data, _ := a.Store.Get(n.Cid)
+		missing = append(missing, cidString(n.Cid))
+		bytes += int64(len(data))
+	}
+	return missing, bytes, shared
+}
@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/car"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/report"
+	"ipld-benchmark/source"
+)
+
+// hashers are the ones a MyCID can hold, it is a sha256 digest
+var hashers = []string{"sha256"}
+
+func hasherFlag(fs *flagSet) *string {
+	return fs.String("hasher", "sha256", "block hash: "+strings.Join(hashers, ", "))
+}
+
+// formats every command can print its result in
+var formats = report.Formats
+
+// colorMode is -color, the zero value is auto: color when stdout is a
+// terminal
+type colorMode string
+
+var colorModes = []string{"auto", "always", "never"}
+
+func (m colorMode) on(w io.Writer) bool {
+	switch m {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return report.Terminal(w)
+}
+
+// dagFlags describe a generated DAG, bench takes several structures
+// and sizes and runs every combination
+type dagFlags struct {
+	structure string
+	nodes     intList
+	maxLinks  int
+	seed      int64
+	hasher    *string
+}
+
+func (d *dagFlags) register(fs *flagSet) {
+	d.nodes = intList{1000}
+	fs.StringVar(&d.structure, "structure", "binary", "DAG shape: linear, binary, star or random")
+	fs.Var(&d.nodes, "nodes", "number of nodes")
+	fs.IntVar(&d.maxLinks, "max-links", 3, "most links per node of a random DAG")
+	fs.Int64Var(&d.seed, "seed", 0, "seed for a reproducible DAG, 0 for a different one every run")
+	d.hasher = hasherFlag(fs)
+}
+
+func (d *dagFlags) options() (bench.GenerateOptions, error) {
+	matrix, err := d.matrix()
+	if err != nil {
+		return bench.GenerateOptions{}, err
+	}
+	if len(matrix) != 1 {
+		return bench.GenerateOptions{}, usagef("-structure and -nodes take a single value here")
+	}
+	return matrix[0], nil
+}
+
+// matrix is every structure with every size, -structure is a comma
+// separated list or all
+func (d *dagFlags) matrix() ([]bench.GenerateOptions, error) {
+	var structures []bench.DAGStructure
+	if d.structure == "all" {
+		structures = bench.Structures()
+	} else {
+		for _, name := range strings.Split(d.structure, ",") {
+			structure, err := bench.ParseStructure(strings.TrimSpace(name))
+			if err != nil {
+				return nil, &usageError{msg: err.Error()}
+			}
+			structures = append(structures, structure)
+		}
+	}
+	for _, n := range d.nodes {
+		if n <= 0 {
+			return nil, usagef("-nodes must be positive, got %d", n)
+		}
+	}
+	if d.maxLinks <= 0 {
+		return nil, usagef("-max-links must be positive, got %d", d.maxLinks)
+	}
+	if err := choice("hasher", *d.hasher, hashers); err != nil {
+		return nil, err
+	}
+
+	var matrix []bench.GenerateOptions
+	for _, structure := range structures {
+		for _, n := range d.nodes {
+			matrix = append(matrix, bench.GenerateOptions{Structure: structure, Nodes: n, MaxLinks: d.maxLinks, Seed: d.seed})
+		}
+	}
+	return matrix, nil
+}
+
+// intList is a comma separated list of ints
+type intList []int
+
+func (l *intList) String() string {
+	if l == nil {
+		return ""
+	}
+	parts := make([]string, len(*l))
+	for i, n := range *l {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *intList) Set(s string) error {
+	var out intList
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("not a number: %q", part)
+		}
+		out = append(out, n)
+	}
+	*l = out
+	return nil
+}
+
+// runnerFlags say how bench samples every operation
+type runnerFlags struct {
+	bench.RunnerOptions
+}
+
+func (r *runnerFlags) register(fs *flagSet) {
+	fs.IntVar(&r.Warmup, "warmup", bench.DefaultRunnerOptions.Warmup, "runs of every operation before sampling")
+	fs.IntVar(&r.Samples, "samples", bench.DefaultRunnerOptions.Samples, "measured runs of every operation")
+	fs.Float64Var(&r.OutlierK, "outlier-k", 1.5, "drop samples this many IQRs outside the quartiles, negative keeps all")
+	fs.Float64Var(&r.MaxCV, "max-cv", 0.1, "stddev/mean above which an operation is too noisy to trust")
+}
+
+func (r *runnerFlags) options() (bench.RunnerOptions, error) {
+	if r.Samples <= 0 {
+		return bench.RunnerOptions{}, usagef("-samples must be positive, got %d", r.Samples)
+	}
+	if r.Warmup < 0 {
+		return bench.RunnerOptions{}, usagef("-warmup can't be negative, got %d", r.Warmup)
+	}
+	if r.MaxCV <= 0 {
+		return bench.RunnerOptions{}, usagef("-max-cv must be positive, got %g", r.MaxCV)
+	}
+	return r.RunnerOptions, nil
+}
+
+func codecFlag(fs *flagSet) *string {
+	return fs.String("codec", "gzip", "block compression to measure: "+strings.Join(codecs(), ", "))
+}
+
+func codecs() []string {
+	return append(myipld.CompressorNames(), "none")
+}
+
+// compressor is nil for none
+func compressor(name string) (myipld.Compressor, error) {
+	if err := choice("codec", name, codecs()); err != nil {
+		return nil, err
+	}
+	if name == "none" {
+		return nil, nil
+	}
+	return myipld.LookupCompressor(name)
+}
+
+// formatFlag registers -format and -color, the color mode goes straight
+// into the env since only output reads it
+func formatFlag(fs *flagSet) *string {
+	fs.Func("color", "highlight text and table output: "+strings.Join(colorModes, ", ")+" (default auto)", func(s string) error {
+		for _, m := range colorModes {
+			if s == m {
+				fs.e.color = colorMode(s)
+				return nil
+			}
+		}
+		return fmt.Errorf("want %s", strings.Join(colorModes, ", "))
+	})
+	return fs.String("format", "text", "output format: "+strings.Join(formats, ", "))
+}
+
+// sourceFlags run an external program that writes a DAG description
+type sourceFlags struct {
+	command string
+	timeout time.Duration
+}
+
+func (s *sourceFlags) register(fs *flagSet) {
+	fs.StringVar(&s.command, "source-cmd", "", "run this shell command and read the DAG description it writes to stdout")
+	fs.DurationVar(&s.timeout, "source-timeout", source.DefaultCommandTimeout, "how long -source-cmd may run")
+}
+
+func (s *sourceFlags) run(bs myipld.Blockstore) (*source.Result, error) {
+	return source.RunCommand(context.Background(), s.command, source.CommandOptions{Timeout: s.timeout}, bs)
+}
+
+// dag is a DAG read from a CAR, Nodes in the order of the file. Root is
+// the first of Roots, most CARs have only the one
+type dag struct {
+	Root  myipld.MyCID
+	Roots []myipld.MyCID
+	Nodes []*myipld.MyNode
+	Store *myipld.MemBlockstore
+	Bytes int64
+}
+
+func (d *dag) node(c myipld.MyCID) *myipld.MyNode {
+	for _, n := range d.Nodes {
+		if n.Cid == c {
+			return n
+		}
+	}
+	return nil
+}
+
+// readCAR loads a whole CAR, "-" is stdin. every block has to match its
+// CID and decode as a node
+func readCAR(path string) (*dag, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", path, err)
+	}
+	if len(cr.Roots) == 0 {
+		return nil, fmt.Errorf("%s : car has no root", path)
+	}
+
+	d := &dag{Root: cr.Roots[0], Roots: cr.Roots, Store: myipld.NewMemBlockstore()}
+	for {
+		c, data, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", path, err)
+		}
+		if got, _ := myipld.ComputeSHA256(data); got != c {
+			return nil, fmt.Errorf("%s : block %s does not match its cid", path, c)
+		}
+		if ok, _ := d.Store.Has(c); ok {
+			continue
+		}
+		node, err := myipld.FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s : block %s : %w", path, c, err)
+		}
+		d.Store.Put(c, data)
+		d.Nodes = append(d.Nodes, node)
+		d.Bytes += int64(len(data))
+	}
+	for _, root := range d.Roots {
+		if ok, _ := d.Store.Has(root); !ok {
+			return nil, fmt.Errorf("%s : root %s is not in the car", path, car.ToCid(root))
+		}
+	}
+	return d, nil
+}
+
+// writeCAR writes nodes under roots to path, "-" is stdout
+func writeCAR(e *env, path string, roots []myipld.MyCID, nodes []*myipld.MyNode) (int64, error) {
+	if path == "-" {
+		return writeNodes(e.stdout, roots, nodes)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	size, err := writeNodes(f, roots, nodes)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return size, err
+}
+
+func writeNodes(w io.Writer, roots []myipld.MyCID, nodes []*myipld.MyNode) (int64, error) {
+	cw, err := car.NewWriter(w, roots...)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, n := range nodes {
+		data, err := n.ToBytes()
+		if err != nil {
+			return 0, err
+		}
+		if err := cw.Put(n.Cid, data); err != nil {
+			return 0, err
+		}
+		size += int64(len(data))
+	}
+	return size, nil
+}
+
+// writeJSON writes v indented to path, "-" is stdout
+func writeJSON(e *env, path string, v interface{}) error {
+	if path == "-" {
+		return encodeJSON(e.stdout, v)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = encodeJSON(f, v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// output prints a result struct in format, see report.Write
+func output(e *env, format string, v ...interface{}) error {
+	if err := choice("format", format, formats); err != nil {
+		return err
+	}
+	return report.Write(e.stdout, format, report.Options{Color: e.color.on(e.stdout)}, v...)
+}
@@ -0,0 +1,58 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+)
+
+// KeyBits is the size of the keyspace, one k-bucket per bit
+const KeyBits = 256
+
+// Key is a point in the 256 bit keyspace peers and CIDs share
+type Key [32]byte
+
+// PeerKey hashes a peer id into the keyspace so ids spread evenly
+// whatever they look like
+func PeerKey(id exchange.PeerID) Key {
+	return Key(sha256.Sum256([]byte(id)))
+}
+
+// CIDKey is the CID hash itself, it is already uniform
+func CIDKey(c myipld.MyCID) Key {
+	return Key(c.Hash)
+}
+
+func (k Key) String() string {
+	return hex.EncodeToString(k[:8])
+}
+
+// Distance is the XOR metric of kademlia
+func Distance(a, b Key) Key {
+	var d Key
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// Closer reports whether a is closer to target than b
+func Closer(a, b, target Key) bool {
+	da, db := Distance(a, target), Distance(b, target)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// CommonPrefixLen is the number of leading bits a and b share, which is
+// the index of the bucket b goes into in a's routing table
+func CommonPrefixLen(a, b Key) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return KeyBits
+}
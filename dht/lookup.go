@@ -0,0 +1,288 @@
+package dht
+
+import (
+	"sort"
+	"time"
+
+	"ipld-benchmark/exchange"
+)
+
+type lookupKind int
+
+const (
+	findNodeLookup lookupKind = iota
+	findProvidersLookup
+	provideLookup
+)
+
+type LookupResult struct {
+	Target Key
+	// Closest are the K closest peers that answered, nearest first
+	Closest   []exchange.PeerID
+	Providers []exchange.PeerID
+	// Hops is how many steps away from our own table the answer was,
+	// 1 when a peer we already knew had it
+	Hops     int
+	Queries  int
+	Failures int
+	Duration time.Duration
+}
+
+type candidateState int
+
+const (
+	candidatePending candidateState = iota
+	candidateWaiting
+	candidateAnswered
+	candidateFailed
+)
+
+type candidate struct {
+	id    exchange.PeerID
+	key   Key
+	depth int
+	state candidateState
+}
+
+/* {comment}
+
+Lookup is one iterative kademlia lookup. it starts from the K closest
+peers in our table and keeps Alpha rpcs in flight to the closest peers
+not asked yet, every answer brings peers closer to the target. it ends
+once the K closest peers it knows of have all answered, or for provider
+lookups as soon as someone returns provider records
+
+a provide lookup is a find node for the key followed by ADD_PROVIDER to
+the K closest peers it found
+
+{/comment} */
+
+type Lookup struct {
+	node   *Node
+	target Key
+	kind   lookupKind
+
+	candidates []*candidate
+	seen       map[exchange.PeerID]bool
+	inflight   int
+	started    time.Time
+	result     LookupResult
+
+	done     chan struct{}
+	finished bool
+	err      error
+}
+
+func (n *Node) newLookup(target Key, kind lookupKind) *Lookup {
+	return &Lookup{
+		node:   n,
+		target: target,
+		kind:   kind,
+		seen:   map[exchange.PeerID]bool{n.id: true},
+		result: LookupResult{Target: target},
+		done:   make(chan struct{}),
+	}
+}
+
+// NewFindNode looks for the K peers closest to target
+func (n *Node) NewFindNode(target Key) *Lookup {
+	return n.newLookup(target, findNodeLookup)
+}
+
+// NewFindProviders looks for provider records of key
+func (n *Node) NewFindProviders(key Key) *Lookup {
+	return n.newLookup(key, findProvidersLookup)
+}
+
+// NewProvide announces this node as a provider of key
+func (n *Node) NewProvide(key Key) *Lookup {
+	return n.newLookup(key, provideLookup)
+}
+
+func (l *Lookup) Start() {
+	n := l.node
+	n.mu.Lock()
+	l.started = n.clock.Now()
+
+	if l.kind == findProvidersLookup {
+		if local := n.liveProviders(l.target); len(local) > 0 {
+			l.result.Providers = local
+			l.finish(nil)
+			n.mu.Unlock()
+			return
+		}
+	}
+
+	for _, id := range n.table.NearestPeers(l.target, n.cfg.K) {
+		l.add(id, 1)
+	}
+	out := l.step()
+	n.mu.Unlock()
+
+	n.sendAll(out)
+}
+
+func (l *Lookup) Cancel(err error) {
+	l.node.mu.Lock()
+	defer l.node.mu.Unlock()
+	l.finish(err)
+}
+
+// Done is closed once the lookup has its answer or failed
+func (l *Lookup) Done() <-chan struct{} {
+	return l.done
+}
+
+func (l *Lookup) Err() error {
+	l.node.mu.Lock()
+	defer l.node.mu.Unlock()
+	return l.err
+}
+
+func (l *Lookup) Result() *LookupResult {
+	l.node.mu.Lock()
+	defer l.node.mu.Unlock()
+	result := l.result
+	return &result
+}
+
+func (l *Lookup) add(id exchange.PeerID, depth int) {
+	if l.seen[id] {
+		return
+	}
+	l.seen[id] = true
+	l.candidates = append(l.candidates, &candidate{id: id, key: PeerKey(id), depth: depth})
+}
+
+// step asks the closest pending candidates and finishes the lookup once
+// the K closest live ones have answered
+func (l *Lookup) step() []outgoing {
+	if l.finished {
+		return nil
+	}
+	sort.Slice(l.candidates, func(i, j int) bool {
+		return Closer(l.candidates[i].key, l.candidates[j].key, l.target)
+	})
+
+	var out []outgoing
+	considered, converged := 0, true
+	for _, c := range l.candidates {
+		if considered == l.node.cfg.K {
+			break
+		}
+		if c.state == candidateFailed {
+			continue
+		}
+		considered++
+
+		switch c.state {
+		case candidateWaiting:
+			converged = false
+		case candidatePending:
+			converged = false
+			if l.inflight < l.node.cfg.Alpha {
+				out = append(out, l.query(c))
+			}
+		}
+	}
+
+	if converged {
+		return append(out, l.complete()...)
+	}
+	return out
+}
+
+func (l *Lookup) query(c *candidate) outgoing {
+	c.state = candidateWaiting
+	l.inflight++
+	l.result.Queries++
+
+	msgType := FindNode
+	if l.kind == findProvidersLookup {
+		msgType = GetProviders
+	}
+	return outgoing{
+		to:  c.id,
+		msg: Message{Type: msgType, Target: l.target},
+		cb: func(msg Message, err error) {
+			l.handle(c, msg, err)
+		},
+	}
+}
+
+func (l *Lookup) handle(c *candidate, msg Message, err error) {
+	n := l.node
+	n.mu.Lock()
+	if l.finished {
+		n.mu.Unlock()
+		return
+	}
+	l.inflight--
+
+	var out []outgoing
+	if err != nil {
+		c.state = candidateFailed
+		l.result.Failures++
+		out = l.step()
+	} else {
+		c.state = candidateAnswered
+		for _, id := range msg.Closer {
+			l.add(id, c.depth+1)
+		}
+		if l.kind == findProvidersLookup && len(msg.Providers) > 0 {
+			l.result.Providers = msg.Providers
+			l.result.Hops = c.depth
+			l.finish(nil)
+		} else {
+			out = l.step()
+		}
+	}
+	n.mu.Unlock()
+
+	n.sendAll(out)
+}
+
+// complete is reached once the lookup converged, it returns the
+// announcements of a provide lookup
+func (l *Lookup) complete() []outgoing {
+	for _, c := range l.candidates {
+		if len(l.result.Closest) == l.node.cfg.K {
+			break
+		}
+		if c.state != candidateAnswered {
+			continue
+		}
+		l.result.Closest = append(l.result.Closest, c.id)
+		if c.depth > l.result.Hops {
+			l.result.Hops = c.depth
+		}
+	}
+
+	switch {
+	case len(l.result.Closest) == 0:
+		l.finish(ErrNoPeers)
+		return nil
+	case l.kind == findProvidersLookup:
+		l.finish(ErrNoProviders)
+		return nil
+	}
+
+	var out []outgoing
+	if l.kind == provideLookup {
+		for _, id := range l.result.Closest {
+			out = append(out, outgoing{to: id, msg: Message{Type: AddProvider, Target: l.target}})
+		}
+	}
+	l.finish(nil)
+	return out
+}
+
+func (l *Lookup) finish(err error) {
+	if l.finished {
+		return
+	}
+	l.finished = true
+	l.err = err
+	l.result.Duration = l.node.clock.Now().Sub(l.started)
+	close(l.done)
+}
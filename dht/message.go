@@ -0,0 +1,56 @@
+package dht
+
+import (
+	"fmt"
+
+	"ipld-benchmark/exchange"
+)
+
+type MessageType byte
+
+const (
+	FindNode MessageType = iota + 1
+	FindNodeResponse
+	GetProviders
+	GetProvidersResponse
+	AddProvider
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case FindNode:
+		return "find-node"
+	case FindNodeResponse:
+		return "find-node-response"
+	case GetProviders:
+		return "get-providers"
+	case GetProvidersResponse:
+		return "get-providers-response"
+	case AddProvider:
+		return "add-provider"
+	default:
+		return fmt.Sprintf("unknown-message-%d", byte(t))
+	}
+}
+
+// Message is one DHT rpc, ID pairs a response with its request. the
+// sender of an AddProvider is the provider being announced
+type Message struct {
+	Type      MessageType
+	ID        uint64
+	Target    Key
+	Closer    []exchange.PeerID
+	Providers []exchange.PeerID
+}
+
+// Size approximates the encoded size, used by simulated links
+func (m Message) Size() int {
+	size := 1 + 8 + len(m.Target)
+	for _, id := range m.Closer {
+		size += 1 + len(id)
+	}
+	for _, id := range m.Providers {
+		size += 1 + len(id)
+	}
+	return size
+}
@@ -0,0 +1,108 @@
+package dht
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ipld-benchmark/exchange"
+)
+
+// Handler, Network and Transport mirror the exchange ones for DHT
+// messages, see netsim.DHTNetwork for the simulated version
+type Handler interface {
+	HandleMessage(from exchange.PeerID, msg Message)
+}
+
+type Network interface {
+	Attach(id exchange.PeerID, h Handler) (Transport, error)
+}
+
+type Transport interface {
+	LocalPeer() exchange.PeerID
+	Send(to exchange.PeerID, msg Message) error
+	Close() error
+}
+
+// ClockedNetwork is a network running on its own clock, nodes on any
+// other network use the wall clock
+type ClockedNetwork interface {
+	Network
+	Clock() exchange.Clock
+}
+
+func clockOf(nw Network) exchange.Clock {
+	if cn, ok := nw.(ClockedNetwork); ok {
+		return cn.Clock()
+	}
+	return exchange.RealClock()
+}
+
+// MemNetwork delivers every message on its own goroutine after Latency,
+// rpcs do not need ordering and Node does its own locking
+type MemNetwork struct {
+	Latency time.Duration
+
+	mu        sync.RWMutex
+	endpoints map[exchange.PeerID]*memEndpoint
+
+	messages atomic.Int64
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{endpoints: make(map[exchange.PeerID]*memEndpoint)}
+}
+
+type memEndpoint struct {
+	id      exchange.PeerID
+	net     *MemNetwork
+	handler Handler
+}
+
+func (n *MemNetwork) Attach(id exchange.PeerID, h Handler) (Transport, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, exists := n.endpoints[id]; exists {
+		return nil, fmt.Errorf("peer %s already attached", id)
+	}
+	ep := &memEndpoint{id: id, net: n, handler: h}
+	n.endpoints[id] = ep
+	return ep, nil
+}
+
+// Messages returns the number of messages sent so far
+func (n *MemNetwork) Messages() int64 {
+	return n.messages.Load()
+}
+
+func (ep *memEndpoint) LocalPeer() exchange.PeerID {
+	return ep.id
+}
+
+func (ep *memEndpoint) Send(to exchange.PeerID, msg Message) error {
+	ep.net.mu.RLock()
+	dest, ok := ep.net.endpoints[to]
+	ep.net.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", exchange.ErrUnknownPeer, to)
+	}
+	ep.net.messages.Add(1)
+
+	from, latency := ep.id, ep.net.Latency
+	go func() {
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		dest.handler.HandleMessage(from, msg)
+	}()
+	return nil
+}
+
+func (ep *memEndpoint) Close() error {
+	ep.net.mu.Lock()
+	delete(ep.net.endpoints, ep.id)
+	ep.net.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,341 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"ipld-benchmark/exchange"
+)
+
+var (
+	ErrTimeout     = errors.New("dht rpc timed out")
+	ErrNoPeers     = errors.New("no live peers to ask")
+	ErrNoProviders = errors.New("no providers found")
+	ErrNodeClosed  = errors.New("dht node closed")
+)
+
+type Config struct {
+	// K is the bucket size and the number of closest peers a lookup
+	// converges on
+	K int
+	// Alpha is how many rpcs one lookup keeps in flight
+	Alpha int
+	// RPCTimeout drops a peer that does not answer in time
+	RPCTimeout time.Duration
+	// ProviderTTL is how long a provider record lives, providers have to
+	// announce again before that
+	ProviderTTL time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		K:           20,
+		Alpha:       3,
+		RPCTimeout:  5 * time.Second,
+		ProviderTTL: 24 * time.Hour,
+	}
+}
+
+/* {comment}
+
+Node is one kademlia participant, it answers FIND_NODE, GET_PROVIDERS
+and ADD_PROVIDER and runs lookups of its own (lookup.go)
+
+everyone we hear from goes into the routing table, peers that time out
+come out of it. all state, lookups included, is guarded by mu
+
+{/comment} */
+
+type Node struct {
+	id    exchange.PeerID
+	key   Key
+	cfg   Config
+	tr    Transport
+	clock exchange.Clock
+
+	mu        sync.Mutex
+	table     *RoutingTable
+	providers map[Key]map[exchange.PeerID]time.Time
+	calls     map[uint64]*call
+	nextID    uint64
+	closed    bool
+}
+
+type call struct {
+	to   exchange.PeerID
+	cb   func(Message, error)
+	stop func() bool
+}
+
+type outgoing struct {
+	to  exchange.PeerID
+	msg Message
+	// cb is set for requests, responses and announcements have none
+	cb func(Message, error)
+}
+
+// NewNode attaches a node to nw, zero fields of cfg take the defaults
+func NewNode(id exchange.PeerID, nw Network, cfg Config) (*Node, error) {
+	def := DefaultConfig()
+	if cfg.K <= 0 {
+		cfg.K = def.K
+	}
+	if cfg.Alpha <= 0 {
+		cfg.Alpha = def.Alpha
+	}
+	if cfg.RPCTimeout <= 0 {
+		cfg.RPCTimeout = def.RPCTimeout
+	}
+	if cfg.ProviderTTL <= 0 {
+		cfg.ProviderTTL = def.ProviderTTL
+	}
+
+	n := &Node{
+		id:        id,
+		key:       PeerKey(id),
+		cfg:       cfg,
+		clock:     clockOf(nw),
+		table:     NewRoutingTable(PeerKey(id), cfg.K),
+		providers: make(map[Key]map[exchange.PeerID]time.Time),
+		calls:     make(map[uint64]*call),
+	}
+
+	tr, err := nw.Attach(id, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach dht node %s : %w", id, err)
+	}
+	n.tr = tr
+	return n, nil
+}
+
+func (n *Node) ID() exchange.PeerID {
+	return n.id
+}
+
+func (n *Node) Key() Key {
+	return n.key
+}
+
+// AddPeer puts a known peer in the routing table, this is how a node
+// gets its bootstrap peers before its first lookup
+func (n *Node) AddPeer(id exchange.PeerID) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.table.Update(id)
+}
+
+func (n *Node) TableSize() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.table.Size()
+}
+
+func (n *Node) NearestPeers(target Key, count int) []exchange.PeerID {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.table.NearestPeers(target, count)
+}
+
+// Providers returns the unexpired provider records this node stores for
+// key, sorted by peer id
+func (n *Node) Providers(key Key) []exchange.PeerID {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.liveProviders(key)
+}
+
+// Close detaches the node, rpcs still waiting fail with ErrNodeClosed
+func (n *Node) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	calls := n.calls
+	n.calls = make(map[uint64]*call)
+	n.mu.Unlock()
+
+	for _, c := range calls {
+		c.stop()
+		c.cb(Message{}, ErrNodeClosed)
+	}
+	return n.tr.Close()
+}
+
+func (n *Node) HandleMessage(from exchange.PeerID, msg Message) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.table.Update(from)
+
+	var out []outgoing
+	switch msg.Type {
+	case FindNode:
+		out = append(out, outgoing{to: from, msg: Message{
+			Type:   FindNodeResponse,
+			ID:     msg.ID,
+			Target: msg.Target,
+			Closer: n.closerPeers(msg.Target, from),
+		}})
+
+	case GetProviders:
+		out = append(out, outgoing{to: from, msg: Message{
+			Type:      GetProvidersResponse,
+			ID:        msg.ID,
+			Target:    msg.Target,
+			Closer:    n.closerPeers(msg.Target, from),
+			Providers: n.liveProviders(msg.Target),
+		}})
+
+	case AddProvider:
+		records, ok := n.providers[msg.Target]
+		if !ok {
+			records = make(map[exchange.PeerID]time.Time)
+			n.providers[msg.Target] = records
+		}
+		records[from] = n.clock.Now().Add(n.cfg.ProviderTTL)
+
+	case FindNodeResponse, GetProvidersResponse:
+		c, ok := n.calls[msg.ID]
+		if !ok || c.to != from {
+			// late answer to an rpc that already timed out
+			n.mu.Unlock()
+			return
+		}
+		delete(n.calls, msg.ID)
+		n.mu.Unlock()
+
+		c.stop()
+		c.cb(msg, nil)
+		return
+	}
+	n.mu.Unlock()
+
+	n.sendAll(out)
+}
+
+// closerPeers is what we answer a lookup with, the requester already
+// knows itself
+func (n *Node) closerPeers(target Key, requester exchange.PeerID) []exchange.PeerID {
+	peers := n.table.NearestPeers(target, n.cfg.K+1)
+	out := peers[:0]
+	for _, id := range peers {
+		if id != requester {
+			out = append(out, id)
+		}
+	}
+	if len(out) > n.cfg.K {
+		out = out[:n.cfg.K]
+	}
+	return out
+}
+
+func (n *Node) liveProviders(key Key) []exchange.PeerID {
+	records := n.providers[key]
+	now := n.clock.Now()
+
+	var live []exchange.PeerID
+	for id, expires := range records {
+		if now.Before(expires) {
+			live = append(live, id)
+		} else {
+			delete(records, id)
+		}
+	}
+	if len(records) == 0 {
+		delete(n.providers, key)
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i] < live[j] })
+	return live
+}
+
+// sendAll sends outside the lock, requests get registered first so the
+// answer can never beat its call
+func (n *Node) sendAll(out []outgoing) {
+	for _, o := range out {
+		if o.cb == nil {
+			_ = n.tr.Send(o.to, o.msg)
+			continue
+		}
+
+		id, err := n.register(o.to, o.cb)
+		if err != nil {
+			o.cb(Message{}, err)
+			continue
+		}
+		o.msg.ID = id
+		if err := n.tr.Send(o.to, o.msg); err != nil {
+			n.fail(id, err)
+		}
+	}
+}
+
+func (n *Node) register(to exchange.PeerID, cb func(Message, error)) (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return 0, ErrNodeClosed
+	}
+	n.nextID++
+	id := n.nextID
+	n.calls[id] = &call{
+		to: to,
+		cb: cb,
+		stop: n.clock.AfterFunc(n.cfg.RPCTimeout, func() {
+			n.fail(id, ErrTimeout)
+		}),
+	}
+	return id, nil
+}
+
+// fail ends a call that got no answer and forgets the peer
+func (n *Node) fail(id uint64, err error) {
+	n.mu.Lock()
+	c, ok := n.calls[id]
+	if ok {
+		delete(n.calls, id)
+		n.table.Remove(c.to)
+	}
+	n.mu.Unlock()
+
+	if ok {
+		c.stop()
+		c.cb(Message{}, err)
+	}
+}
+
+// Bootstrap adds the given peers and looks up our own key, which fills
+// the routing table with our neighbourhood and announces us to it
+func (n *Node) Bootstrap(ctx context.Context, peers ...exchange.PeerID) (*LookupResult, error) {
+	for _, id := range peers {
+		n.AddPeer(id)
+	}
+	return n.FindNode(ctx, n.key)
+}
+
+func (n *Node) FindNode(ctx context.Context, target Key) (*LookupResult, error) {
+	return run(ctx, n.NewFindNode(target))
+}
+
+func (n *Node) FindProviders(ctx context.Context, key Key) (*LookupResult, error) {
+	return run(ctx, n.NewFindProviders(key))
+}
+
+func (n *Node) Provide(ctx context.Context, key Key) (*LookupResult, error) {
+	return run(ctx, n.NewProvide(key))
+}
+
+func run(ctx context.Context, l *Lookup) (*LookupResult, error) {
+	l.Start()
+	select {
+	case <-l.Done():
+		return l.Result(), l.Err()
+	case <-ctx.Done():
+		l.Cancel(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"sort"
+
+	"ipld-benchmark/exchange"
+)
+
+/* {comment}
+
+RoutingTable keeps up to k peers per bucket, bucket i holds the peers
+sharing exactly i leading bits with us. inside a bucket peers are ordered
+least recently seen first
+
+a full bucket keeps its old peers and ignores the new one, long lived
+peers are the ones most likely to stay (real kademlia pings the oldest
+first, the lookups here drop peers that time out instead)
+
+the table is not safe for concurrent use, Node guards it
+
+{/comment} */
+
+type RoutingTable struct {
+	self    Key
+	k       int
+	buckets [KeyBits][]tableEntry
+	size    int
+}
+
+type tableEntry struct {
+	id  exchange.PeerID
+	key Key
+}
+
+func NewRoutingTable(self Key, k int) *RoutingTable {
+	return &RoutingTable{self: self, k: k}
+}
+
+// Update records that we heard from id, it reports whether id is in the
+// table afterwards
+func (t *RoutingTable) Update(id exchange.PeerID) bool {
+	key := PeerKey(id)
+	cpl := CommonPrefixLen(t.self, key)
+	if cpl == KeyBits {
+		return false
+	}
+
+	bucket := t.buckets[cpl]
+	for i, e := range bucket {
+		if e.id == id {
+			copy(bucket[i:], bucket[i+1:])
+			bucket[len(bucket)-1] = e
+			return true
+		}
+	}
+	if len(bucket) >= t.k {
+		return false
+	}
+	t.buckets[cpl] = append(bucket, tableEntry{id: id, key: key})
+	t.size++
+	return true
+}
+
+func (t *RoutingTable) Remove(id exchange.PeerID) {
+	key := PeerKey(id)
+	cpl := CommonPrefixLen(t.self, key)
+	if cpl == KeyBits {
+		return
+	}
+
+	bucket := t.buckets[cpl]
+	for i, e := range bucket {
+		if e.id == id {
+			t.buckets[cpl] = append(bucket[:i], bucket[i+1:]...)
+			t.size--
+			return
+		}
+	}
+}
+
+func (t *RoutingTable) Size() int {
+	return t.size
+}
+
+// NearestPeers returns up to count peers ordered by distance to target
+func (t *RoutingTable) NearestPeers(target Key, count int) []exchange.PeerID {
+	entries := make([]tableEntry, 0, t.size)
+	for _, bucket := range t.buckets {
+		entries = append(entries, bucket...)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return Closer(entries[i].key, entries[j].key, target)
+	})
+	if len(entries) > count {
+		entries = entries[:count]
+	}
+
+	ids := make([]exchange.PeerID, len(entries))
+	for i, e := range entries {
+		ids[i] = e.id
+	}
+	return ids
+}
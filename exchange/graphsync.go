@@ -0,0 +1,291 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+var ErrGraphsyncProtocol = errors.New("graphsync protocol violation")
+
+// Selector says which part of the DAG under a root a request wants
+type Selector struct {
+	// MaxDepth limits how many links below the root are followed, 0
+	// means the whole DAG
+	MaxDepth int
+	// LinkPrefix only follows links whose name starts with it
+	LinkPrefix string
+}
+
+func SelectAll() Selector {
+	return Selector{}
+}
+
+// Follow reports whether a link leading to depth should be traversed
+func (s Selector) Follow(depth int, link myipld.MyLink) bool {
+	if s.MaxDepth > 0 && depth > s.MaxDepth {
+		return false
+	}
+	return strings.HasPrefix(link.Name, s.LinkPrefix)
+}
+
+type GraphsyncStatus byte
+
+const (
+	GraphsyncCompleted GraphsyncStatus = iota
+	// GraphsyncPartial means some selected blocks were missing on the
+	// responder, they were reported with Present false
+	GraphsyncPartial
+)
+
+// BlockMeta travels with every streamed block
+type BlockMeta struct {
+	Depth   int
+	Index   int
+	Present bool
+	Status  GraphsyncStatus
+}
+
+/* {comment}
+
+graphsync-style exchange, the client sends one request with the root and
+a selector, the responder walks the DAG depth first and streams every
+selected block in that order with its depth and position, then a final
+complete message. the whole DAG costs one round trip however deep it is
+
+the client verifies while blocks stream in, a block is only accepted if
+it hashes to its CID and that CID was linked from a block already
+verified (or is the root), so a responder cannot push unrelated data
+
+{/comment} */
+
+func (p *Peer) serveGraphsync(from PeerID, req Message) {
+	visited := make(map[myipld.MyCID]bool)
+	status := GraphsyncCompleted
+	index := 0
+
+	type frame struct {
+		cid   myipld.MyCID
+		depth int
+	}
+	stack := []frame{{cid: req.Cid, depth: 0}}
+
+	for len(stack) > 0 {
+		curr := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[curr.cid] {
+			continue
+		}
+		visited[curr.cid] = true
+
+		resp := Message{
+			Type:      GraphsyncResponse,
+			Cid:       curr.cid,
+			RequestID: req.RequestID,
+			Meta:      BlockMeta{Depth: curr.depth, Index: index},
+		}
+		index++
+
+		data, err := p.bs.Get(curr.cid)
+		if err != nil {
+			status = GraphsyncPartial
+			p.sendAll([]outgoing{{to: from, msg: resp}})
+			continue
+		}
+		resp.Meta.Present = true
+		resp.Data = data
+		p.mu.Lock()
+		p.sent(from, len(data))
+		p.mu.Unlock()
+		p.sendAll([]outgoing{{to: from, msg: resp}})
+
+		node, err := myipld.FromBytes(data)
+		if err != nil {
+			continue
+		}
+		// push in reverse so links are sent in the order they appear
+		for i := len(node.Links) - 1; i >= 0; i-- {
+			link := node.Links[i]
+			if !visited[link.Cid] && req.Selector.Follow(curr.depth+1, link) {
+				stack = append(stack, frame{cid: link.Cid, depth: curr.depth + 1})
+			}
+		}
+	}
+
+	p.sendAll([]outgoing{{to: from, msg: Message{
+		Type:      GraphsyncComplete,
+		Cid:       req.Cid,
+		RequestID: req.RequestID,
+		Meta:      BlockMeta{Status: status},
+	}}})
+}
+
+type GraphsyncSession struct {
+	peer     *Peer
+	id       uint64
+	root     myipld.MyCID
+	provider PeerID
+	selector Selector
+
+	// selected blocks we know about and have not received yet, by depth
+	expected map[myipld.MyCID]int
+	received map[myipld.MyCID]bool
+	missing  []myipld.MyCID
+	started  time.Time
+	stats    FetchStats
+
+	done     chan struct{}
+	finished bool
+	err      error
+}
+
+func (p *Peer) NewGraphsyncSession(root myipld.MyCID, provider PeerID, selector Selector) *GraphsyncSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextRequestID++
+	return &GraphsyncSession{
+		peer:     p,
+		id:       p.nextRequestID,
+		root:     root,
+		provider: provider,
+		selector: selector,
+		expected: map[myipld.MyCID]int{root: 0},
+		received: make(map[myipld.MyCID]bool),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *GraphsyncSession) Start() {
+	s.peer.mu.Lock()
+	s.peer.gsSessions[s.id] = s
+	s.started = s.peer.clock.Now()
+	s.stats.MessagesSent++
+	s.stats.RoundTrips = 1
+	s.peer.mu.Unlock()
+
+	s.peer.sendAll([]outgoing{{to: s.provider, msg: Message{
+		Type:      GraphsyncRequest,
+		Cid:       s.root,
+		RequestID: s.id,
+		Selector:  s.selector,
+	}}})
+}
+
+func (s *GraphsyncSession) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *GraphsyncSession) Err() error {
+	s.peer.mu.Lock()
+	defer s.peer.mu.Unlock()
+	return s.err
+}
+
+func (s *GraphsyncSession) Stats() *FetchStats {
+	s.peer.mu.Lock()
+	defer s.peer.mu.Unlock()
+	stats := s.stats
+	return &stats
+}
+
+func (s *GraphsyncSession) Cancel(err error) {
+	s.peer.mu.Lock()
+	defer s.peer.mu.Unlock()
+	s.finish(err)
+}
+
+// finish is called with the peer mutex held
+func (s *GraphsyncSession) finish(err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.err = err
+	s.stats.Duration = s.peer.clock.Now().Sub(s.started)
+	delete(s.peer.gsSessions, s.id)
+	close(s.done)
+}
+
+func (s *GraphsyncSession) handle(msg Message) {
+	if s.finished {
+		return
+	}
+
+	switch msg.Type {
+	case GraphsyncResponse:
+		depth, ok := s.expected[msg.Cid]
+		if !ok {
+			if s.received[msg.Cid] {
+				s.stats.Duplicates++
+				return
+			}
+			s.finish(fmt.Errorf("%w: unexpected block %s", ErrGraphsyncProtocol, msg.Cid))
+			return
+		}
+		delete(s.expected, msg.Cid)
+		s.received[msg.Cid] = true
+
+		if !msg.Meta.Present {
+			s.missing = append(s.missing, msg.Cid)
+			return
+		}
+
+		got, err := myipld.ComputeSHA256(msg.Data)
+		if err != nil || got != msg.Cid {
+			s.stats.Invalid++
+			s.finish(fmt.Errorf("%w: block %s failed verification", ErrGraphsyncProtocol, msg.Cid))
+			return
+		}
+		if err := s.peer.bs.Put(msg.Cid, msg.Data); err != nil {
+			s.finish(fmt.Errorf("failed to store block %s : %w", msg.Cid, err))
+			return
+		}
+		s.stats.Blocks++
+		s.stats.Bytes += len(msg.Data)
+
+		node, err := myipld.FromBytes(msg.Data)
+		if err != nil {
+			return
+		}
+		for _, link := range node.Links {
+			if s.received[link.Cid] || !s.selector.Follow(depth+1, link) {
+				continue
+			}
+			// the responder's stack pops a shared block at the depth of
+			// the last parent that pushed it, track it the same way
+			s.expected[link.Cid] = depth + 1
+		}
+
+	case GraphsyncComplete:
+		switch {
+		case len(s.missing) > 0:
+			s.finish(fmt.Errorf("%w: %d blocks missing on %s, first %s", ErrBlockUnavailable, len(s.missing), s.provider, s.missing[0]))
+		case len(s.expected) > 0:
+			s.finish(fmt.Errorf("%w: responder completed with %d selected blocks unsent", ErrGraphsyncProtocol, len(s.expected)))
+		case msg.Meta.Status != GraphsyncCompleted:
+			s.finish(fmt.Errorf("%w: responder reported status %d", ErrGraphsyncProtocol, msg.Meta.Status))
+		default:
+			s.finish(nil)
+		}
+	}
+}
+
+// GraphsyncFetch pulls the selected part of the DAG under root from one
+// provider in a single request
+func (p *Peer) GraphsyncFetch(ctx context.Context, root myipld.MyCID, provider PeerID, selector Selector) (*FetchStats, error) {
+	s := p.NewGraphsyncSession(root, provider, selector)
+	s.Start()
+
+	select {
+	case <-s.Done():
+		return s.Stats(), s.Err()
+	case <-ctx.Done():
+		s.Cancel(ctx.Err())
+		return s.Stats(), ctx.Err()
+	}
+}
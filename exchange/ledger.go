@@ -0,0 +1,372 @@
+package exchange
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+// Ledger is what a peer remembers about one other peer, counted in block
+// payload bytes
+type Ledger struct {
+	Peer           PeerID
+	BytesSent      int64
+	BytesReceived  int64
+	BlocksSent     int
+	BlocksReceived int
+	// Deferred counts want-blocks the serve policy held back, Pending
+	// the ones still waiting
+	Deferred int
+	Pending  int
+	// LastExchange is the last time a block went either way
+	LastExchange time.Time
+
+	lastWant time.Time
+}
+
+// DebtRatio is bitswap's r = bytes sent / (bytes received + 1), how much
+// the peer owes us
+func (l Ledger) DebtRatio() float64 {
+	return float64(l.BytesSent) / float64(l.BytesReceived+1)
+}
+
+/* {comment}
+
+ServePolicy decides who a peer uploads to. without one every want-block
+is answered right away, with one a want-block that is not allowed waits
+in the peer and is asked about again every Interval, after Rechoke had a
+chance to change its mind. a block the peer does not have is always
+answered dont-have at once
+
+policies are only called with the peer mutex held
+
+{/comment} */
+
+type ServePolicy interface {
+	Name() string
+	Allow(l *Ledger, now time.Time) bool
+	Rechoke(ledgers []*Ledger, now time.Time)
+	Interval() time.Duration
+}
+
+type ChokerConfig struct {
+	// UploadSlots is how many peers are unchoked for their download rate
+	// to us, one more slot goes to the optimistic unchoke
+	UploadSlots     int
+	RechokeInterval time.Duration
+	// the optimistic unchoke moves to another random peer every
+	// OptimisticEvery rechokes
+	OptimisticEvery int
+}
+
+func DefaultChokerConfig() ChokerConfig {
+	return ChokerConfig{
+		UploadSlots:     4,
+		RechokeInterval: 10 * time.Second,
+		OptimisticEvery: 3,
+	}
+}
+
+/* {comment}
+
+Choker is the BitTorrent tit-for-tat: every rechoke the interested peers
+that sent us the most since the last one get the upload slots, everyone
+else is choked apart from one optimistic unchoke picked at random, which
+is how newcomers (and free-riders) get anything at all. free slots are
+handed out right away rather than waiting for the next rechoke
+
+{/comment} */
+
+type Choker struct {
+	cfg ChokerConfig
+	rng *rand.Rand
+
+	unchoked   map[PeerID]bool
+	optimistic PeerID
+	rounds     int
+	// BytesReceived of every peer at the last rechoke
+	received map[PeerID]int64
+}
+
+func NewChoker(cfg ChokerConfig, seed int64) *Choker {
+	def := DefaultChokerConfig()
+	if cfg.UploadSlots <= 0 {
+		cfg.UploadSlots = def.UploadSlots
+	}
+	if cfg.RechokeInterval <= 0 {
+		cfg.RechokeInterval = def.RechokeInterval
+	}
+	if cfg.OptimisticEvery <= 0 {
+		cfg.OptimisticEvery = def.OptimisticEvery
+	}
+	return &Choker{
+		cfg:      cfg,
+		rng:      rand.New(rand.NewSource(seed)),
+		unchoked: make(map[PeerID]bool),
+		received: make(map[PeerID]int64),
+	}
+}
+
+func (c *Choker) Name() string { return "choke" }
+
+func (c *Choker) Interval() time.Duration { return c.cfg.RechokeInterval }
+
+func (c *Choker) Allow(l *Ledger, _ time.Time) bool {
+	if c.unchoked[l.Peer] {
+		return true
+	}
+	if len(c.unchoked) < c.cfg.UploadSlots+1 {
+		c.unchoked[l.Peer] = true
+		return true
+	}
+	return false
+}
+
+// Unchoked lists the peers currently allowed to download
+func (c *Choker) Unchoked() []PeerID {
+	ids := make([]PeerID, 0, len(c.unchoked))
+	for id := range c.unchoked {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (c *Choker) Rechoke(ledgers []*Ledger, now time.Time) {
+	rates := make(map[PeerID]int64, len(ledgers))
+	var interested []PeerID
+	for _, l := range ledgers {
+		rates[l.Peer] = l.BytesReceived - c.received[l.Peer]
+		c.received[l.Peer] = l.BytesReceived
+		if l.Pending > 0 || now.Sub(l.lastWant) < c.cfg.RechokeInterval {
+			interested = append(interested, l.Peer)
+		}
+	}
+	// shuffled first so peers that sent us nothing take turns
+	c.rng.Shuffle(len(interested), func(i, j int) { interested[i], interested[j] = interested[j], interested[i] })
+	sort.SliceStable(interested, func(i, j int) bool { return rates[interested[i]] > rates[interested[j]] })
+
+	c.unchoked = make(map[PeerID]bool)
+	regular := interested
+	if len(regular) > c.cfg.UploadSlots {
+		regular = regular[:c.cfg.UploadSlots]
+	}
+	for _, id := range regular {
+		c.unchoked[id] = true
+	}
+
+	c.rounds++
+	choked := interested[len(regular):]
+	stale := c.optimistic == "" || c.unchoked[c.optimistic] || !contains(choked, c.optimistic)
+	if len(choked) > 0 && (stale || c.rounds%c.cfg.OptimisticEvery == 0) {
+		c.optimistic = choked[c.rng.Intn(len(choked))]
+	}
+	if contains(choked, c.optimistic) {
+		c.unchoked[c.optimistic] = true
+	}
+}
+
+/* {comment}
+
+DebtRatio is the policy from the bitswap paper: a want-block is served
+with probability 1 - 1/(1 + exp(6 - 3r)) where r is the debt ratio, so
+peers that give back about as much as they get are nearly always served
+and the chance drops off quickly past r = 2. Grace bytes go to every
+peer unconditionally so a newcomer with nothing to trade can start
+
+{/comment} */
+
+type DebtRatio struct {
+	Grace int64
+	Retry time.Duration
+	rng   *rand.Rand
+}
+
+// NewDebtRatio retries held back requests every second by default
+func NewDebtRatio(grace int64, seed int64) *DebtRatio {
+	return &DebtRatio{Grace: grace, Retry: time.Second, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (d *DebtRatio) Name() string { return "debt-ratio" }
+
+func (d *DebtRatio) Interval() time.Duration { return d.Retry }
+
+func (d *DebtRatio) Rechoke([]*Ledger, time.Time) {}
+
+func (d *DebtRatio) Allow(l *Ledger, _ time.Time) bool {
+	if l.BytesSent < d.Grace {
+		return true
+	}
+	return d.rng.Float64() < SendProbability(l.DebtRatio())
+}
+
+// SendProbability is the bitswap paper's sigmoid for debt ratio r
+func SendProbability(r float64) float64 {
+	return 1 - 1/(1+math.Exp(6-3*r))
+}
+
+func contains(ids []PeerID, id PeerID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+type deferredWant struct {
+	from PeerID
+	cid  myipld.MyCID
+}
+
+// SetServePolicy puts policy in charge of who gets blocks from p, nil
+// serves everyone again
+func (p *Peer) SetServePolicy(policy ServePolicy) {
+	p.mu.Lock()
+	p.policy = policy
+	var out []outgoing
+	if policy == nil {
+		// nobody is held back any more
+		out = p.serveDeferred()
+	}
+	p.mu.Unlock()
+	p.sendAll(out)
+}
+
+// Ledger returns what p knows about id
+func (p *Peer) Ledger(id PeerID) Ledger {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.ledgers[id]; ok {
+		return *l
+	}
+	return Ledger{Peer: id}
+}
+
+// Ledgers returns every ledger of p ordered by peer
+func (p *Peer) Ledgers() []Ledger {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]Ledger, 0, len(p.ledgers))
+	for _, l := range p.sortedLedgers() {
+		out = append(out, *l)
+	}
+	return out
+}
+
+// ledger is called with the peer mutex held
+func (p *Peer) ledger(id PeerID) *Ledger {
+	l, ok := p.ledgers[id]
+	if !ok {
+		l = &Ledger{Peer: id}
+		p.ledgers[id] = l
+	}
+	return l
+}
+
+func (p *Peer) sortedLedgers() []*Ledger {
+	ledgers := make([]*Ledger, 0, len(p.ledgers))
+	for _, l := range p.ledgers {
+		ledgers = append(ledgers, l)
+	}
+	sort.Slice(ledgers, func(i, j int) bool { return ledgers[i].Peer < ledgers[j].Peer })
+	return ledgers
+}
+
+// received books a block from a peer, called with the peer mutex held
+func (p *Peer) received(from PeerID, size int) {
+	l := p.ledger(from)
+	l.BytesReceived += int64(size)
+	l.BlocksReceived++
+	l.LastExchange = p.clock.Now()
+	p.ledgerActive = true
+	p.armPolicy()
+}
+
+func (p *Peer) sent(to PeerID, size int) {
+	l := p.ledger(to)
+	l.BytesSent += int64(size)
+	l.BlocksSent++
+	l.LastExchange = p.clock.Now()
+	p.ledgerActive = true
+}
+
+// serveWantBlock answers a want-block under the serve policy, called
+// with the peer mutex held. nil means it was held back
+func (p *Peer) serveWantBlock(from PeerID, cid myipld.MyCID) []outgoing {
+	data, err := p.bs.Get(cid)
+	if err != nil {
+		return []outgoing{{to: from, msg: Message{Type: DontHave, Cid: cid}}}
+	}
+
+	l := p.ledger(from)
+	l.lastWant = p.clock.Now()
+	if p.policy != nil && !p.policy.Allow(l, p.clock.Now()) {
+		for _, d := range p.deferred {
+			if d.from == from && d.cid == cid {
+				// a retry of a request already waiting
+				return nil
+			}
+		}
+		l.Deferred++
+		l.Pending++
+		p.deferred = append(p.deferred, deferredWant{from: from, cid: cid})
+		p.armPolicy()
+		return nil
+	}
+
+	p.sent(from, len(data))
+	return []outgoing{{to: from, msg: Message{Type: Block, Cid: cid, Data: data}}}
+}
+
+// serveDeferred hands out whatever the policy allows now of the held
+// back want-blocks, in the order they came in
+func (p *Peer) serveDeferred() []outgoing {
+	var out []outgoing
+	now := p.clock.Now()
+	kept := p.deferred[:0]
+	for _, d := range p.deferred {
+		l := p.ledger(d.from)
+		if p.policy != nil && !p.policy.Allow(l, now) {
+			kept = append(kept, d)
+			continue
+		}
+		l.Pending--
+		data, err := p.bs.Get(d.cid)
+		if err != nil {
+			out = append(out, outgoing{to: d.from, msg: Message{Type: DontHave, Cid: d.cid}})
+			continue
+		}
+		p.sent(d.from, len(data))
+		out = append(out, outgoing{to: d.from, msg: Message{Type: Block, Cid: d.cid, Data: data}})
+	}
+	p.deferred = kept
+	return out
+}
+
+// armPolicy starts the policy timer if it is not running. it only keeps
+// running while something happens so a simulation can still run dry
+func (p *Peer) armPolicy() {
+	if p.policy == nil || p.stopPolicy != nil || p.policy.Interval() <= 0 {
+		return
+	}
+	p.stopPolicy = p.clock.AfterFunc(p.policy.Interval(), func() {
+		p.mu.Lock()
+		p.stopPolicy = nil
+		if p.policy == nil {
+			p.mu.Unlock()
+			return
+		}
+		p.policy.Rechoke(p.sortedLedgers(), p.clock.Now())
+		out := p.serveDeferred()
+		if len(p.deferred) > 0 || p.ledgerActive {
+			p.ledgerActive = false
+			p.armPolicy()
+		}
+		p.mu.Unlock()
+		p.sendAll(out)
+	})
+}
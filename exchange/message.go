@@ -0,0 +1,78 @@
+package exchange
+
+import (
+	"fmt"
+
+	"ipld-benchmark/myipld"
+)
+
+type PeerID string
+
+type MessageType byte
+
+const (
+	WantHave MessageType = iota + 1
+	WantBlock
+	Have
+	DontHave
+	Block
+
+	// graphsync-style request-by-selector, see graphsync.go
+	GraphsyncRequest
+	GraphsyncResponse
+	GraphsyncComplete
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case WantHave:
+		return "want-have"
+	case WantBlock:
+		return "want-block"
+	case Have:
+		return "have"
+	case DontHave:
+		return "dont-have"
+	case Block:
+		return "block"
+	case GraphsyncRequest:
+		return "graphsync-request"
+	case GraphsyncResponse:
+		return "graphsync-response"
+	case GraphsyncComplete:
+		return "graphsync-complete"
+	default:
+		return fmt.Sprintf("unknown-message-%d", byte(t))
+	}
+}
+
+/* {comment}
+
+Message is one entry of the bitswap-style protocol, real bitswap batches
+wantlists but one cid per message keeps the simulated peers simple
+Data is only set on Block messages and graphsync responses, the other
+fields are only used by the graphsync messages
+
+{/comment} */
+
+type Message struct {
+	Type MessageType
+	Cid  myipld.MyCID
+	Data []byte
+
+	RequestID uint64
+	Selector  Selector
+	Meta      BlockMeta
+}
+
+func (t MessageType) isGraphsync() bool {
+	return t == GraphsyncRequest || t == GraphsyncResponse || t == GraphsyncComplete
+}
+
+// Size is the number of bytes the message takes on the wire
+func (m Message) Size() int {
+	if m.Type.isGraphsync() {
+		return len(MarshalMessage(m))
+	}
+	return 1 + myipld.HashSize + len(m.Data)
+}
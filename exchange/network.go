@@ -0,0 +1,206 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrUnknownPeer     = errors.New("unknown peer")
+	ErrTransportClosed = errors.New("transport closed")
+)
+
+// Handler receives every message addressed to a peer, calls for one
+// peer never overlap
+type Handler interface {
+	HandleMessage(from PeerID, msg Message)
+}
+
+// Network connects peers, each attached peer gets its own Transport
+type Network interface {
+	Attach(id PeerID, h Handler) (Transport, error)
+}
+
+type Transport interface {
+	LocalPeer() PeerID
+	Send(to PeerID, msg Message) error
+	Close() error
+}
+
+/* {comment}
+
+MemNetwork is the in-process network, messages are queued per receiver
+and handed to its Handler from one goroutine so delivery is ordered and
+async like a real network. Latency is added to every message when set
+
+{/comment} */
+
+type MemNetwork struct {
+	Latency time.Duration
+
+	mu        sync.RWMutex
+	endpoints map[PeerID]*memEndpoint
+
+	messages atomic.Int64
+	bytes    atomic.Int64
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{endpoints: make(map[PeerID]*memEndpoint)}
+}
+
+type memEndpoint struct {
+	id  PeerID
+	net *MemNetwork
+	box *mailbox
+}
+
+func (n *MemNetwork) Attach(id PeerID, h Handler) (Transport, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, exists := n.endpoints[id]; exists {
+		return nil, fmt.Errorf("peer %s already attached", id)
+	}
+
+	ep := &memEndpoint{id: id, net: n, box: newMailbox(h)}
+	n.endpoints[id] = ep
+	return ep, nil
+}
+
+// Stats returns the number of messages and bytes sent so far
+func (n *MemNetwork) Stats() (int64, int64) {
+	return n.messages.Load(), n.bytes.Load()
+}
+
+func (ep *memEndpoint) LocalPeer() PeerID {
+	return ep.id
+}
+
+func (ep *memEndpoint) Send(to PeerID, msg Message) error {
+	ep.net.mu.RLock()
+	dest, ok := ep.net.endpoints[to]
+	ep.net.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, to)
+	}
+
+	ep.net.messages.Add(1)
+	ep.net.bytes.Add(int64(msg.Size()))
+
+	if !dest.box.push(ep.id, msg, time.Now().Add(ep.net.Latency)) {
+		return fmt.Errorf("%w: %s", ErrTransportClosed, to)
+	}
+	return nil
+}
+
+func (ep *memEndpoint) Close() error {
+	ep.net.mu.Lock()
+	delete(ep.net.endpoints, ep.id)
+	ep.net.mu.Unlock()
+
+	ep.box.close()
+	return nil
+}
+
+type delivery struct {
+	from      PeerID
+	msg       Message
+	deliverAt time.Time
+}
+
+// mailbox queues incoming messages and hands them to the handler one at
+// a time from its own goroutine, no earlier than deliverAt
+type mailbox struct {
+	handler Handler
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []delivery
+	closed bool
+}
+
+func newMailbox(h Handler) *mailbox {
+	box := &mailbox{handler: h}
+	box.cond = sync.NewCond(&box.mu)
+	go box.run()
+	return box
+}
+
+func (box *mailbox) push(from PeerID, msg Message, deliverAt time.Time) bool {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+
+	if box.closed {
+		return false
+	}
+	box.queue = append(box.queue, delivery{from: from, msg: msg, deliverAt: deliverAt})
+	box.cond.Signal()
+	return true
+}
+
+func (box *mailbox) close() {
+	box.mu.Lock()
+	box.closed = true
+	box.cond.Signal()
+	box.mu.Unlock()
+}
+
+func (box *mailbox) run() {
+	for {
+		box.mu.Lock()
+		for len(box.queue) == 0 && !box.closed {
+			box.cond.Wait()
+		}
+		if box.closed {
+			box.mu.Unlock()
+			return
+		}
+		next := box.queue[0]
+		box.queue = box.queue[1:]
+		box.mu.Unlock()
+
+		if wait := time.Until(next.deliverAt); wait > 0 {
+			time.Sleep(wait)
+		}
+		box.handler.HandleMessage(next.from, next.msg)
+	}
+}
+
+// Clock is where peers get time and timers from, networks running on
+// virtual time (see netsim) hand out their own through ClockedNetwork
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type ClockedNetwork interface {
+	Network
+	Clock() Clock
+}
+
+// RealClock is the wall clock peers use when their network has no clock
+// of its own
+func RealClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+func clockOf(nw Network) Clock {
+	if cn, ok := nw.(ClockedNetwork); ok {
+		return cn.Clock()
+	}
+	return RealClock()
+}
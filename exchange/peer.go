@@ -0,0 +1,183 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+/* {comment}
+
+Peer is one participant of the swarm, it serves blocks out of its
+Blockstore to anyone asking and runs fetch sessions of its own
+
+{/comment} */
+
+type Peer struct {
+	id    PeerID
+	bs    myipld.Blockstore
+	tr    Transport
+	clock Clock
+
+	mu sync.Mutex
+	// a slice rather than a set so message handling order never depends
+	// on map iteration, simulated runs have to be reproducible
+	sessions      []*Session
+	retryTimeout  time.Duration
+	maxRetries    int
+	gsSessions    map[uint64]*GraphsyncSession
+	nextRequestID uint64
+	nextSession   uint64
+
+	// block scheduling across sessions, see schedule.go
+	strategy    Strategy
+	maxInflight int
+	queue       []*want
+	inflight    []*want
+
+	// who we owe and who owes us, see ledger.go
+	ledgers      map[PeerID]*Ledger
+	policy       ServePolicy
+	deferred     []deferredWant
+	stopPolicy   func() bool
+	ledgerActive bool
+}
+
+type outgoing struct {
+	to  PeerID
+	msg Message
+}
+
+func NewPeer(id PeerID, bs myipld.Blockstore, nw Network) (*Peer, error) {
+	p := &Peer{
+		id:         id,
+		bs:         bs,
+		clock:      clockOf(nw),
+		gsSessions: make(map[uint64]*GraphsyncSession),
+		ledgers:    make(map[PeerID]*Ledger),
+		maxRetries: 10,
+	}
+
+	tr, err := nw.Attach(id, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach peer %s : %w", id, err)
+	}
+	p.tr = tr
+	return p, nil
+}
+
+func (p *Peer) ID() PeerID {
+	return p.id
+}
+
+func (p *Peer) Blockstore() myipld.Blockstore {
+	return p.bs
+}
+
+// SetRetryTimeout makes sessions re-send wants that got no answer after
+// d, up to maxRetries times. 0 (the default) never retries, which is fine
+// on lossless networks but needed on lossy simulated links
+func (p *Peer) SetRetryTimeout(d time.Duration, maxRetries int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retryTimeout = d
+	p.maxRetries = maxRetries
+}
+
+func (p *Peer) Close() error {
+	p.mu.Lock()
+	if p.stopPolicy != nil {
+		p.stopPolicy()
+		p.stopPolicy = nil
+	}
+	p.policy = nil
+	p.mu.Unlock()
+	return p.tr.Close()
+}
+
+func (p *Peer) HandleMessage(from PeerID, msg Message) {
+	var out []outgoing
+
+	switch msg.Type {
+	case WantHave:
+		out = append(out, p.answerWantHave(from, msg.Cid))
+	case WantBlock:
+		p.mu.Lock()
+		out = p.serveWantBlock(from, msg.Cid)
+		p.mu.Unlock()
+	case Have, DontHave, Block:
+		p.mu.Lock()
+		if msg.Type == Block {
+			p.received(from, len(msg.Data))
+		}
+		// handle may finish and remove the session, iterate a copy
+		for _, s := range append([]*Session(nil), p.sessions...) {
+			out = append(out, s.handle(from, msg)...)
+		}
+		out = append(out, p.dispatch()...)
+		p.mu.Unlock()
+	case GraphsyncRequest:
+		p.serveGraphsync(from, msg)
+	case GraphsyncResponse, GraphsyncComplete:
+		p.mu.Lock()
+		if msg.Type == GraphsyncResponse && msg.Meta.Present {
+			p.received(from, len(msg.Data))
+		}
+		if s, ok := p.gsSessions[msg.RequestID]; ok && s.provider == from {
+			s.handle(msg)
+		}
+		p.mu.Unlock()
+	}
+
+	p.sendAll(out)
+}
+
+func (p *Peer) answerWantHave(from PeerID, cid myipld.MyCID) outgoing {
+	if has, err := p.bs.Has(cid); err == nil && has {
+		return outgoing{to: from, msg: Message{Type: Have, Cid: cid}}
+	}
+	return outgoing{to: from, msg: Message{Type: DontHave, Cid: cid}}
+}
+
+func (p *Peer) sendAll(out []outgoing) {
+	for _, o := range out {
+		if err := p.tr.Send(o.to, o.msg); err != nil {
+			log.Printf("peer %s: failed to send %s to %s: %v", p.id, o.msg.Type, o.to, err)
+		}
+	}
+}
+
+// Fetch pulls the whole DAG under root from the providers into the
+// local Blockstore and blocks until it is complete
+func (p *Peer) Fetch(ctx context.Context, root myipld.MyCID, providers []PeerID) (*FetchStats, error) {
+	s := p.NewSession(root, providers)
+	s.Start()
+
+	select {
+	case <-s.Done():
+		return s.Stats(), s.Err()
+	case <-ctx.Done():
+		s.Cancel(ctx.Err())
+		return s.Stats(), ctx.Err()
+	}
+}
+
+func (p *Peer) addSession(s *Session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessions = append(p.sessions, s)
+}
+
+// removeSession is called with p.mu held
+func (p *Peer) removeSession(s *Session) {
+	for i, other := range p.sessions {
+		if other == s {
+			p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package exchange
+
+import (
+	"fmt"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+// Request is what a Strategy sees of a block waiting for a want-block
+// slot
+type Request struct {
+	Cid myipld.MyCID
+	// Session numbers sessions in the order the peer created them, Seq
+	// numbers the blocks of one session in the order they were found
+	Session uint64
+	Seq     uint64
+	// Deadline comes from Session.SetDeadline, zero when there is none
+	Deadline time.Time
+	// Providers is how many peers said they have the block
+	Providers int
+}
+
+// Strategy picks which queued request gets the next free slot, it
+// returns an index into queue (never empty)
+type Strategy interface {
+	Name() string
+	Pick(queue []Request, now time.Time) int
+}
+
+/* {comment}
+
+block scheduling, only active once Peer.SetScheduler gives the peer a
+limit on want-blocks in flight. without one every block is requested the
+moment a provider says have, which is what a generic DAG fetcher does.
+with one the blocks that are known to be available queue up and the
+strategy decides the order they are requested in, across all sessions of
+the peer
+
+{/comment} */
+
+// Sequential fetches in session order and within a session in the
+// order blocks were discovered
+type Sequential struct{}
+
+func (Sequential) Name() string { return "sequential" }
+
+func (Sequential) Pick(queue []Request, _ time.Time) int {
+	best := 0
+	for i := range queue {
+		if earlier(queue[i], queue[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// RarestFirst fetches the block the fewest peers have first, falling
+// back to sequential order between equally rare blocks
+type RarestFirst struct{}
+
+func (RarestFirst) Name() string { return "rarest-first" }
+
+func (RarestFirst) Pick(queue []Request, _ time.Time) int {
+	best := 0
+	for i := range queue {
+		if rarer(queue[i], queue[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// DeadlinePriority serves anything due within Urgency earliest deadline
+// first, otherwise the rarest block due within the Window look-ahead
+type DeadlinePriority struct {
+	Urgency time.Duration
+	Window  time.Duration
+}
+
+func (DeadlinePriority) Name() string { return "deadline" }
+
+func (d DeadlinePriority) Pick(queue []Request, now time.Time) int {
+	urgency, window := d.Urgency, d.Window
+	if urgency <= 0 {
+		urgency = 2 * time.Second
+	}
+	if window <= 0 {
+		window = 10 * time.Second
+	}
+
+	urgent, ahead := -1, -1
+	for i, r := range queue {
+		if r.Deadline.IsZero() {
+			continue
+		}
+		if r.Deadline.Before(now.Add(urgency)) {
+			if urgent < 0 || dueFirst(r, queue[urgent]) {
+				urgent = i
+			}
+		} else if r.Deadline.Before(now.Add(window)) {
+			if ahead < 0 || rarer(r, queue[ahead]) {
+				ahead = i
+			}
+		}
+	}
+
+	switch {
+	case urgent >= 0:
+		return urgent
+	case ahead >= 0:
+		return ahead
+	}
+	// nothing due soon, the earliest deadline is still the best bet
+	best := 0
+	for i := range queue {
+		if dueFirst(queue[i], queue[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Endgame orders requests like Base (Sequential when nil) and, once
+// nothing is queued and at most Threshold blocks are in flight, asks a
+// second provider for each of them so one slow peer cannot hold up the
+// end of a download. the copy that loses arrives as a duplicate
+type Endgame struct {
+	Base      Strategy
+	Threshold int
+}
+
+func (Endgame) Name() string { return "endgame" }
+
+func (e Endgame) Pick(queue []Request, now time.Time) int {
+	if e.Base == nil {
+		return Sequential{}.Pick(queue, now)
+	}
+	return e.Base.Pick(queue, now)
+}
+
+func (e Endgame) inEndgame(queued, inflight int) bool {
+	threshold := e.Threshold
+	if threshold <= 0 {
+		threshold = 4
+	}
+	return queued == 0 && inflight > 0 && inflight <= threshold
+}
+
+// StrategyByName returns one of the built-in strategies with its
+// defaults
+func StrategyByName(name string) (Strategy, error) {
+	switch name {
+	case "sequential":
+		return Sequential{}, nil
+	case "rarest-first":
+		return RarestFirst{}, nil
+	case "deadline":
+		return DeadlinePriority{}, nil
+	case "endgame":
+		return Endgame{}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+}
+
+func earlier(a, b Request) bool {
+	if a.Session != b.Session {
+		return a.Session < b.Session
+	}
+	return a.Seq < b.Seq
+}
+
+func rarer(a, b Request) bool {
+	if a.Providers != b.Providers {
+		return a.Providers < b.Providers
+	}
+	return earlier(a, b)
+}
+
+func dueFirst(a, b Request) bool {
+	switch {
+	case a.Deadline.IsZero() != b.Deadline.IsZero():
+		return !a.Deadline.IsZero()
+	case !a.Deadline.Equal(b.Deadline):
+		return a.Deadline.Before(b.Deadline)
+	}
+	return earlier(a, b)
+}
+
+// SetScheduler limits the peer to maxInflight want-blocks at a time and
+// lets strategy order the rest, 0 turns scheduling off again
+func (p *Peer) SetScheduler(strategy Strategy, maxInflight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if strategy == nil {
+		strategy = Sequential{}
+	}
+	p.strategy = strategy
+	p.maxInflight = maxInflight
+}
+
+func (p *Peer) scheduling() bool {
+	return p.maxInflight > 0
+}
+
+// enqueue is called with the peer mutex held when from has a block we
+// want, the block waits for a slot in dispatch
+func (s *Session) enqueue(w *want, from PeerID) {
+	w.haves = append(w.haves, from)
+	if w.queued || w.slot {
+		return
+	}
+	w.queued = true
+	s.peer.queue = append(s.peer.queue, w)
+}
+
+// release gives back whatever a finished want held in the scheduler
+func (p *Peer) release(w *want) {
+	if w.queued {
+		w.queued = false
+		p.queue = removeWant(p.queue, w)
+	}
+	if w.slot {
+		w.slot = false
+		p.inflight = removeWant(p.inflight, w)
+	}
+}
+
+func removeWant(list []*want, w *want) []*want {
+	for i, other := range list {
+		if other == w {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// dispatch hands free slots to queued wants in strategy order, it is
+// called with the peer mutex held at the end of everything that can
+// queue a want or free a slot
+func (p *Peer) dispatch() []outgoing {
+	if !p.scheduling() {
+		return nil
+	}
+
+	var out []outgoing
+	now := p.clock.Now()
+	for len(p.inflight) < p.maxInflight && len(p.queue) > 0 {
+		requests := make([]Request, len(p.queue))
+		for i, w := range p.queue {
+			requests[i] = w.request()
+		}
+		i := p.strategy.Pick(requests, now)
+		if i < 0 || i >= len(p.queue) {
+			i = 0
+		}
+
+		w := p.queue[i]
+		p.queue = append(p.queue[:i], p.queue[i+1:]...)
+		w.queued = false
+		w.slot = true
+		p.inflight = append(p.inflight, w)
+
+		if from, ok := w.nextHave(); ok {
+			out = w.session.requestBlock(out, w, from)
+		} else {
+			out = w.session.broadcast(out, w)
+		}
+	}
+
+	if eg, ok := p.strategy.(Endgame); ok && eg.inEndgame(len(p.queue), len(p.inflight)) {
+		for _, w := range p.inflight {
+			if w.endgame {
+				continue
+			}
+			if from, ok := w.nextHave(); ok {
+				w.endgame = true
+				out = w.session.send(out, from, Message{Type: WantBlock, Cid: w.cid})
+			}
+		}
+	}
+	return out
+}
+
+func (w *want) request() Request {
+	return Request{
+		Cid:       w.cid,
+		Session:   w.session.number,
+		Seq:       w.seq,
+		Deadline:  w.session.deadline,
+		Providers: len(w.havers),
+	}
+}
+
+// nextHave pops the next provider that said have and did not refuse
+func (w *want) nextHave() (PeerID, bool) {
+	for len(w.haves) > 0 {
+		next := w.haves[0]
+		w.haves = w.haves[1:]
+		if !w.refused[next] && next != w.blockFrom {
+			return next, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,380 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+var ErrBlockUnavailable = errors.New("no provider has the block")
+
+type FetchStats struct {
+	Blocks       int
+	Bytes        int
+	LocalBlocks  int
+	Duplicates   int
+	Invalid      int
+	MessagesSent int
+	// RoundTrips is the longest chain of request -> response -> request
+	// the fetch needed, one per DAG level for this protocol
+	RoundTrips int
+	Duration   time.Duration
+}
+
+type want struct {
+	cid   myipld.MyCID
+	round int
+	// peer a want-block is outstanding with, empty while only asking
+	// who has the block
+	blockFrom PeerID
+	asked     map[PeerID]bool
+	refused   map[PeerID]bool
+	// peers that answered have while a want-block was already out
+	haves []PeerID
+
+	retries   int
+	stopRetry func() bool
+
+	// scheduler state, see schedule.go
+	session *Session
+	seq     uint64
+	havers  map[PeerID]bool
+	queued  bool
+	slot    bool
+	endgame bool
+}
+
+/* {comment}
+
+Session fetches one DAG, it asks providers want-have for a block and
+sends want-block to the first one that answers have. children are asked
+straight from the peer that had the parent (like bitswap sessions do)
+and fall back to a broadcast when that peer does not have them
+
+all session state is guarded by the owning peer's mutex
+
+{/comment} */
+
+type Session struct {
+	peer      *Peer
+	root      myipld.MyCID
+	providers []PeerID
+
+	wants   map[myipld.MyCID]*want
+	seen    map[myipld.MyCID]bool
+	started time.Time
+	stats   FetchStats
+
+	number   uint64
+	nextSeq  uint64
+	deadline time.Time
+
+	done     chan struct{}
+	finished bool
+	err      error
+}
+
+func (p *Peer) NewSession(root myipld.MyCID, providers []PeerID) *Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextSession++
+	return &Session{
+		peer:      p,
+		number:    p.nextSession,
+		root:      root,
+		providers: append([]PeerID(nil), providers...),
+		wants:     make(map[myipld.MyCID]*want),
+		seen:      make(map[myipld.MyCID]bool),
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *Session) Start() {
+	s.peer.addSession(s)
+
+	s.peer.mu.Lock()
+	s.started = s.peer.clock.Now()
+	s.seen[s.root] = true
+	out := s.want(s.root, 1, "")
+	out = append(out, s.peer.dispatch()...)
+	s.peer.mu.Unlock()
+
+	s.peer.sendAll(out)
+}
+
+// SetDeadline tells a scheduling strategy when the blocks of this
+// session are needed, call it before Start
+func (s *Session) SetDeadline(t time.Time) {
+	s.peer.mu.Lock()
+	defer s.peer.mu.Unlock()
+	s.deadline = t
+}
+
+func (s *Session) Cancel(err error) {
+	s.peer.mu.Lock()
+	s.finish(err)
+	// the slots this session held go to the others
+	out := s.peer.dispatch()
+	s.peer.mu.Unlock()
+
+	s.peer.sendAll(out)
+}
+
+// Done is closed once the DAG is complete or the session failed
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) Err() error {
+	s.peer.mu.Lock()
+	defer s.peer.mu.Unlock()
+	return s.err
+}
+
+func (s *Session) Stats() *FetchStats {
+	s.peer.mu.Lock()
+	defer s.peer.mu.Unlock()
+	stats := s.stats
+	return &stats
+}
+
+func (s *Session) finish(err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.err = err
+	s.stats.Duration = s.peer.clock.Now().Sub(s.started)
+	for _, w := range s.wants {
+		w.cancelRetry()
+		s.peer.release(w)
+	}
+	s.peer.removeSession(s)
+	close(s.done)
+}
+
+func (s *Session) send(out []outgoing, to PeerID, msg Message) []outgoing {
+	s.stats.MessagesSent++
+	return append(out, outgoing{to: to, msg: msg})
+}
+
+// want starts fetching cid, blocks already in the local store are
+// walked without touching the network
+func (s *Session) want(cid myipld.MyCID, round int, hint PeerID) []outgoing {
+	if data, err := s.peer.bs.Get(cid); err == nil {
+		s.stats.LocalBlocks++
+		return s.walk(cid, data, round, hint)
+	}
+
+	s.nextSeq++
+	w := &want{
+		cid:     cid,
+		round:   round,
+		asked:   make(map[PeerID]bool),
+		refused: make(map[PeerID]bool),
+		session: s,
+		seq:     s.nextSeq,
+		havers:  make(map[PeerID]bool),
+	}
+	s.wants[cid] = w
+	if round > s.stats.RoundTrips {
+		s.stats.RoundTrips = round
+	}
+	s.armRetry(w)
+
+	if hint != "" && s.peer.scheduling() {
+		// the parent came from hint, it most likely has the child too.
+		// the others are asked as well so the strategy knows how rare
+		// the block is
+		w.asked[hint] = true
+		w.havers[hint] = true
+		s.enqueue(w, hint)
+		return s.broadcast(nil, w)
+	}
+	if hint != "" {
+		w.blockFrom = hint
+		w.asked[hint] = true
+		return s.send(nil, hint, Message{Type: WantBlock, Cid: cid})
+	}
+
+	out := s.broadcast(nil, w)
+	if len(w.asked) == 0 {
+		s.finish(fmt.Errorf("%w: %s (no providers)", ErrBlockUnavailable, cid))
+		return nil
+	}
+	return out
+}
+
+func (s *Session) broadcast(out []outgoing, w *want) []outgoing {
+	for _, p := range s.providers {
+		if p == s.peer.id || w.asked[p] {
+			continue
+		}
+		w.asked[p] = true
+		out = s.send(out, p, Message{Type: WantHave, Cid: w.cid})
+	}
+	return out
+}
+
+func (s *Session) handle(from PeerID, msg Message) []outgoing {
+	if s.finished {
+		return nil
+	}
+
+	w, ok := s.wants[msg.Cid]
+	if !ok {
+		// other sessions of the peer see the block too, it is only a
+		// duplicate for the one that already has it
+		if msg.Type == Block && s.seen[msg.Cid] {
+			s.stats.Duplicates++
+		}
+		return nil
+	}
+
+	switch msg.Type {
+	case Have:
+		w.havers[from] = true
+		if w.blockFrom != "" {
+			w.haves = append(w.haves, from)
+			return nil
+		}
+		if s.peer.scheduling() && !w.slot {
+			s.enqueue(w, from)
+			return nil
+		}
+		return s.requestBlock(nil, w, from)
+
+	case DontHave:
+		return s.refuse(w, from)
+
+	case Block:
+		got, err := myipld.ComputeSHA256(msg.Data)
+		if err != nil || got != msg.Cid {
+			s.stats.Invalid++
+			return s.refuse(w, from)
+		}
+		if err := s.peer.bs.Put(msg.Cid, msg.Data); err != nil {
+			s.finish(fmt.Errorf("failed to store block %s : %w", msg.Cid, err))
+			return nil
+		}
+		delete(s.wants, msg.Cid)
+		w.cancelRetry()
+		s.peer.release(w)
+		s.stats.Blocks++
+		s.stats.Bytes += len(msg.Data)
+
+		out := s.walk(msg.Cid, msg.Data, w.round, from)
+		if len(s.wants) == 0 {
+			s.finish(nil)
+		}
+		return out
+	}
+	return nil
+}
+
+func (s *Session) requestBlock(out []outgoing, w *want, from PeerID) []outgoing {
+	w.blockFrom = from
+	w.round++
+	if w.round > s.stats.RoundTrips {
+		s.stats.RoundTrips = w.round
+	}
+	return s.send(out, from, Message{Type: WantBlock, Cid: w.cid})
+}
+
+func (s *Session) refuse(w *want, from PeerID) []outgoing {
+	w.refused[from] = true
+
+	var out []outgoing
+	if w.blockFrom == from {
+		w.blockFrom = ""
+		for len(w.haves) > 0 && w.blockFrom == "" {
+			next := w.haves[0]
+			w.haves = w.haves[1:]
+			if !w.refused[next] {
+				out = s.requestBlock(out, w, next)
+			}
+		}
+		if w.blockFrom == "" {
+			out = s.broadcast(out, w)
+		}
+	}
+
+	if w.blockFrom == "" && len(w.refused) >= len(w.asked) {
+		s.finish(fmt.Errorf("%w: %s", ErrBlockUnavailable, w.cid))
+		return nil
+	}
+	return out
+}
+
+// walk queues the children of a block we now have
+func (s *Session) walk(cid myipld.MyCID, data []byte, round int, hint PeerID) []outgoing {
+	node, err := myipld.FromBytes(data)
+	if err != nil {
+		// not a node (encrypted or raw leaf), nothing to follow
+		return nil
+	}
+
+	var out []outgoing
+	for _, link := range node.Links {
+		if s.seen[link.Cid] {
+			continue
+		}
+		s.seen[link.Cid] = true
+		out = append(out, s.want(link.Cid, round+1, hint)...)
+	}
+	if cid == s.root && len(s.wants) == 0 {
+		s.finish(nil)
+	}
+	return out
+}
+
+func (w *want) cancelRetry() {
+	if w.stopRetry != nil {
+		w.stopRetry()
+		w.stopRetry = nil
+	}
+}
+
+// armRetry is called with the peer mutex held
+func (s *Session) armRetry(w *want) {
+	if s.peer.retryTimeout <= 0 {
+		return
+	}
+	w.stopRetry = s.peer.clock.AfterFunc(s.peer.retryTimeout, func() {
+		s.peer.mu.Lock()
+		out := s.retry(w)
+		out = append(out, s.peer.dispatch()...)
+		s.peer.mu.Unlock()
+		s.peer.sendAll(out)
+	})
+}
+
+// retry re-sends whatever a want is waiting on, the request or its
+// answer may have been lost on the way
+func (s *Session) retry(w *want) []outgoing {
+	if s.finished || s.wants[w.cid] != w {
+		return nil
+	}
+
+	w.retries++
+	if w.retries > s.peer.maxRetries {
+		s.finish(fmt.Errorf("%w: %s (no answer after %d retries)", ErrBlockUnavailable, w.cid, s.peer.maxRetries))
+		return nil
+	}
+
+	var out []outgoing
+	if w.blockFrom != "" {
+		out = s.send(out, w.blockFrom, Message{Type: WantBlock, Cid: w.cid})
+	} else {
+		for _, p := range s.providers {
+			if w.asked[p] && !w.refused[p] {
+				out = s.send(out, p, Message{Type: WantHave, Cid: w.cid})
+			}
+		}
+	}
+	s.armRetry(w)
+	return out
+}
@@ -0,0 +1,302 @@
+package exchange
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+/* {comment}
+
+TCPNetwork runs the same exchange protocol over real sockets
+
+every frame is a uvarint length followed by the payload, the first frame
+on a connection is the dialer's PeerID and every frame after that is a
+MarshalMessage encoded Message. a connection is used in both directions
+and kept around for the next Send to that peer
+
+half-open connections are caught two ways, a read that sees nothing for
+IdleTimeout closes the connection, and a failed write drops it and the
+message is retried once on a fresh dial. a write can fail halfway
+through a frame, so the connection is closed before anyone else gets to
+write to it, another frame after the partial one would desync the
+receiver
+
+peers only know each other through the address book, so two processes
+each with their own TCPNetwork can exchange blocks after AddPeer
+
+{/comment} */
+
+type TCPNetwork struct {
+	ListenAddr   string
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	mu    sync.RWMutex
+	addrs map[PeerID]string
+}
+
+func NewTCPNetwork() *TCPNetwork {
+	return &TCPNetwork{
+		ListenAddr:   "127.0.0.1:0",
+		DialTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  2 * time.Minute,
+		addrs:        make(map[PeerID]string),
+	}
+}
+
+// AddPeer records where a peer is listening
+func (n *TCPNetwork) AddPeer(id PeerID, addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addrs[id] = addr
+}
+
+// Lookup returns the address a peer was registered with
+func (n *TCPNetwork) Lookup(id PeerID) (string, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	addr, ok := n.addrs[id]
+	return addr, ok
+}
+
+func (n *TCPNetwork) Attach(id PeerID, h Handler) (Transport, error) {
+	ln, err := net.Listen("tcp", n.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s : %w", n.ListenAddr, err)
+	}
+
+	t := &TCPTransport{
+		id:    id,
+		net:   n,
+		ln:    ln,
+		box:   newMailbox(h),
+		conns: make(map[PeerID]*tcpConn),
+	}
+	n.AddPeer(id, ln.Addr().String())
+
+	go t.acceptLoop()
+	return t, nil
+}
+
+type TCPTransport struct {
+	id  PeerID
+	net *TCPNetwork
+	ln  net.Listener
+	box *mailbox
+
+	mu     sync.Mutex
+	conns  map[PeerID]*tcpConn
+	closed bool
+}
+
+type tcpConn struct {
+	remote PeerID
+	conn   net.Conn
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+	// broken is set under writeMu once a write failed
+	broken bool
+}
+
+func (t *TCPTransport) LocalPeer() PeerID {
+	return t.id
+}
+
+// Addr is the address the transport listens on, for other address books
+func (t *TCPTransport) Addr() string {
+	return t.ln.Addr().String()
+}
+
+func (t *TCPTransport) Send(to PeerID, msg Message) error {
+	payload := MarshalMessage(msg)
+
+	c, err := t.getConn(to)
+	if err != nil {
+		return err
+	}
+	if err := t.write(c, payload); err == nil {
+		return nil
+	}
+
+	// the connection may have been half open, write dropped it, dial
+	// again once
+	c, err = t.getConn(to)
+	if err != nil {
+		return err
+	}
+	if err := t.write(c, payload); err != nil {
+		return fmt.Errorf("failed to send %s to %s : %w", msg.Type, to, err)
+	}
+	return nil
+}
+
+// write sends a frame on c, any error closes and drops c while the
+// frame may still be half written
+func (t *TCPTransport) write(c *tcpConn, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.broken {
+		return errBrokenConn
+	}
+	if t.net.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(t.net.WriteTimeout))
+	}
+	err := WriteFrame(c.w, payload)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		c.broken = true
+		t.dropConn(c)
+	}
+	return err
+}
+
+var errBrokenConn = errors.New("connection broke during an earlier write")
+
+func (t *TCPTransport) getConn(to PeerID) (*tcpConn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrTransportClosed
+	}
+	if c, ok := t.conns[to]; ok {
+		t.mu.Unlock()
+		return c, nil
+	}
+	t.mu.Unlock()
+
+	addr, ok := t.net.Lookup(to)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPeer, to)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, t.net.DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s at %s : %w", to, addr, err)
+	}
+	c := newTCPConn(to, conn)
+	if err := t.write(c, []byte(t.id)); err != nil {
+		return nil, fmt.Errorf("failed to handshake with %s : %w", to, err)
+	}
+
+	t.mu.Lock()
+	if existing, ok := t.conns[to]; ok {
+		// someone else won the race, keep theirs
+		t.mu.Unlock()
+		conn.Close()
+		return existing, nil
+	}
+	t.conns[to] = c
+	t.mu.Unlock()
+
+	go t.readLoop(c, bufio.NewReader(conn))
+	return c, nil
+}
+
+func newTCPConn(remote PeerID, conn net.Conn) *tcpConn {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(30 * time.Second)
+	}
+	return &tcpConn{remote: remote, conn: conn, w: bufio.NewWriter(conn)}
+}
+
+func (t *TCPTransport) dropConn(c *tcpConn) {
+	t.mu.Lock()
+	if t.conns[c.remote] == c {
+		delete(t.conns, c.remote)
+	}
+	t.mu.Unlock()
+	c.conn.Close()
+}
+
+func (t *TCPTransport) acceptLoop() {
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("tcp transport %s: accept failed: %v", t.id, err)
+			}
+			return
+		}
+		go t.handleIncoming(conn)
+	}
+}
+
+func (t *TCPTransport) handleIncoming(conn net.Conn) {
+	r := bufio.NewReader(conn)
+
+	if t.net.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(t.net.IdleTimeout))
+	}
+	hello, err := ReadFrame(r)
+	if err != nil || len(hello) == 0 {
+		conn.Close()
+		return
+	}
+
+	c := newTCPConn(PeerID(hello), conn)
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		conn.Close()
+		return
+	}
+	// reuse the inbound connection for replies unless we already dialed
+	if _, ok := t.conns[c.remote]; !ok {
+		t.conns[c.remote] = c
+	}
+	t.mu.Unlock()
+
+	t.readLoop(c, r)
+}
+
+func (t *TCPTransport) readLoop(c *tcpConn, r *bufio.Reader) {
+	defer t.dropConn(c)
+
+	for {
+		if t.net.IdleTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(t.net.IdleTimeout))
+		}
+		payload, err := ReadFrame(r)
+		if err != nil {
+			return
+		}
+
+		msg, err := UnmarshalMessage(payload)
+		if err != nil {
+			log.Printf("tcp transport %s: bad message from %s: %v", t.id, c.remote, err)
+			return
+		}
+		t.box.push(c.remote, msg, time.Time{})
+	}
+}
+
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	conns := t.conns
+	t.conns = make(map[PeerID]*tcpConn)
+	t.mu.Unlock()
+
+	err := t.ln.Close()
+	for _, c := range conns {
+		c.conn.Close()
+	}
+	t.box.close()
+	return err
+}
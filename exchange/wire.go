@@ -0,0 +1,149 @@
+package exchange
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"ipld-benchmark/myipld"
+)
+
+// MaxFrameSize bounds a single frame so a broken or hostile peer cannot
+// make us allocate arbitrary amounts of memory
+const MaxFrameSize = 8 << 20
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// MarshalMessage encodes a message as type | cid | data, graphsync
+// messages put their request id and fields (as uvarints) before the data
+//
+//	request  : request id | max depth | prefix length | prefix
+//	response : request id | depth | index | present
+//	complete : request id | status
+func MarshalMessage(msg Message) []byte {
+	buf := make([]byte, 0, 1+myipld.HashSize+len(msg.Data)+8)
+	buf = append(buf, byte(msg.Type))
+	buf = append(buf, msg.Cid.Hash[:]...)
+
+	switch msg.Type {
+	case GraphsyncRequest:
+		buf = binary.AppendUvarint(buf, msg.RequestID)
+		buf = binary.AppendUvarint(buf, uint64(msg.Selector.MaxDepth))
+		buf = binary.AppendUvarint(buf, uint64(len(msg.Selector.LinkPrefix)))
+		buf = append(buf, msg.Selector.LinkPrefix...)
+	case GraphsyncResponse:
+		buf = binary.AppendUvarint(buf, msg.RequestID)
+		buf = binary.AppendUvarint(buf, uint64(msg.Meta.Depth))
+		buf = binary.AppendUvarint(buf, uint64(msg.Meta.Index))
+		buf = append(buf, boolByte(msg.Meta.Present))
+	case GraphsyncComplete:
+		buf = binary.AppendUvarint(buf, msg.RequestID)
+		buf = append(buf, byte(msg.Meta.Status))
+	}
+	return append(buf, msg.Data...)
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func UnmarshalMessage(buf []byte) (Message, error) {
+	if len(buf) < 1+myipld.HashSize {
+		return Message{}, fmt.Errorf("message too short: %d bytes", len(buf))
+	}
+
+	msg := Message{Type: MessageType(buf[0])}
+	copy(msg.Cid.Hash[:], buf[1:1+myipld.HashSize])
+	rest := buf[1+myipld.HashSize:]
+
+	var err error
+	switch msg.Type {
+	case GraphsyncRequest:
+		var maxDepth, prefixLen uint64
+		if msg.RequestID, rest, err = readUvarint(rest); err != nil {
+			return Message{}, err
+		}
+		if maxDepth, rest, err = readUvarint(rest); err != nil {
+			return Message{}, err
+		}
+		if prefixLen, rest, err = readUvarint(rest); err != nil {
+			return Message{}, err
+		}
+		if uint64(len(rest)) < prefixLen {
+			return Message{}, fmt.Errorf("graphsync selector truncated")
+		}
+		msg.Selector = Selector{MaxDepth: int(maxDepth), LinkPrefix: string(rest[:prefixLen])}
+		rest = rest[prefixLen:]
+	case GraphsyncResponse:
+		var depth, index uint64
+		if msg.RequestID, rest, err = readUvarint(rest); err != nil {
+			return Message{}, err
+		}
+		if depth, rest, err = readUvarint(rest); err != nil {
+			return Message{}, err
+		}
+		if index, rest, err = readUvarint(rest); err != nil {
+			return Message{}, err
+		}
+		if len(rest) < 1 {
+			return Message{}, fmt.Errorf("graphsync response truncated")
+		}
+		msg.Meta = BlockMeta{Depth: int(depth), Index: int(index), Present: rest[0] == 1}
+		rest = rest[1:]
+	case GraphsyncComplete:
+		if msg.RequestID, rest, err = readUvarint(rest); err != nil {
+			return Message{}, err
+		}
+		if len(rest) < 1 {
+			return Message{}, fmt.Errorf("graphsync complete truncated")
+		}
+		msg.Meta.Status = GraphsyncStatus(rest[0])
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 {
+		msg.Data = append([]byte(nil), rest...)
+	}
+	return msg, nil
+}
+
+func readUvarint(buf []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("bad uvarint in message")
+	}
+	return v, buf[n:], nil
+}
+
+// WriteFrame writes payload prefixed with its length as an unsigned varint
+func WriteFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(payload))
+	}
+
+	frame := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(payload)), uint64(len(payload)))
+	frame = append(frame, payload...)
+	_, err := w.Write(frame)
+	return err
+}
+
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,335 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ipld-benchmark/car"
+	"ipld-benchmark/myipld"
+)
+
+var (
+	ErrAllGatewaysFailed = errors.New("all gateways failed")
+	ErrBlockMismatch     = errors.New("block does not match its cid")
+	ErrUnexpectedBlock   = errors.New("block was not asked for")
+	ErrIncompleteCAR     = errors.New("car is missing blocks")
+)
+
+type ClientConfig struct {
+	// Gateways are base URLs, tried in order of how well they did so far
+	Gateways   []string
+	HTTPClient *http.Client
+	// Timeout bounds one attempt at one gateway, a slow gateway is
+	// treated like a failing one. 10s by default
+	Timeout time.Duration
+	// Race > 1 asks that many gateways at once and takes the first
+	// answer that verifies, the others are cancelled
+	Race int
+}
+
+// GatewayStats is how one gateway did so far
+type GatewayStats struct {
+	URL      string
+	Requests int
+	Failures int
+	// Wins counts the requests this gateway answered first
+	Wins  int
+	Bytes int64
+}
+
+/* {comment}
+
+Client fetches from trustless gateways and believes none of them: a raw
+block has to hash to the CID it was asked for, and a CAR may only contain
+the root and blocks linked from blocks already verified, in any order the
+gateway likes. an attempt that fails verification, errors, or takes
+longer than Timeout moves on to the next gateway (or loses the race)
+
+Get makes it a myipld.BlockGetter, so anything that walks a DAG from a
+Blockstore can walk one behind a gateway instead
+
+{/comment} */
+
+type Client struct {
+	cfg   ClientConfig
+	http  *http.Client
+	mu    sync.Mutex
+	stats []*GatewayStats
+}
+
+func NewClient(cfg ClientConfig) (*Client, error) {
+	if len(cfg.Gateways) == 0 {
+		return nil, errors.New("no gateways")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Race < 1 {
+		cfg.Race = 1
+	}
+
+	c := &Client{cfg: cfg, http: cfg.HTTPClient}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	for _, gw := range cfg.Gateways {
+		if _, err := url.Parse(gw); err != nil {
+			return nil, fmt.Errorf("gateway %q : %w", gw, err)
+		}
+		c.stats = append(c.stats, &GatewayStats{URL: strings.TrimRight(gw, "/")})
+	}
+	return c, nil
+}
+
+// Stats returns a copy of the per gateway counters, in config order
+func (c *Client) Stats() []GatewayStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]GatewayStats, len(c.stats))
+	for i, s := range c.stats {
+		out[i] = *s
+	}
+	return out
+}
+
+func (c *Client) Get(cid myipld.MyCID) ([]byte, error) {
+	return c.GetContext(context.Background(), cid)
+}
+
+// GetContext fetches one raw block
+func (c *Client) GetContext(ctx context.Context, cid myipld.MyCID) ([]byte, error) {
+	var data []byte
+	err := c.do(ctx, cid, func(ctx context.Context, gw *GatewayStats) (int64, func(), error) {
+		body, err := c.request(ctx, gw, cid, ContentTypeRaw, "format=raw")
+		if err != nil {
+			return 0, nil, err
+		}
+		defer body.Close()
+
+		raw, err := io.ReadAll(io.LimitReader(body, car.MaxSectionSize+1))
+		if err != nil {
+			return 0, nil, err
+		}
+		if got, _ := myipld.ComputeSHA256(raw); got != cid {
+			return int64(len(raw)), nil, fmt.Errorf("%w: %s", ErrBlockMismatch, cid)
+		}
+		return int64(len(raw)), func() { data = raw }, nil
+	})
+	return data, err
+}
+
+// CAROptions narrow a CAR request, see Server
+type CAROptions struct {
+	Scope Scope
+	// Depth limits the links followed below the root, -1 for all
+	Depth int
+}
+
+// FetchCAR pulls the DAG under root as a CAR and puts every verified
+// block into bs, it returns how many blocks that was. with ScopeAll and
+// no depth limit the CAR also has to be complete
+func (c *Client) FetchCAR(ctx context.Context, root myipld.MyCID, opts CAROptions, bs myipld.Blockstore) (int, error) {
+	if opts.Scope == "" {
+		opts.Scope = ScopeAll
+	}
+	query := "format=car&dag-scope=" + string(opts.Scope)
+	if opts.Depth >= 0 {
+		query += "&depth=" + strconv.Itoa(opts.Depth)
+	}
+	complete := opts.Scope == ScopeAll && opts.Depth < 0
+
+	var blocks []block
+	err := c.do(ctx, root, func(ctx context.Context, gw *GatewayStats) (int64, func(), error) {
+		body, err := c.request(ctx, gw, root, ContentTypeCAR, query)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer body.Close()
+
+		got, size, err := verifyCAR(body, root, complete)
+		if err != nil {
+			return size, nil, err
+		}
+		return size, func() { blocks = got }, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, b := range blocks {
+		if err := bs.Put(b.cid, b.data); err != nil {
+			return 0, err
+		}
+	}
+	return len(blocks), nil
+}
+
+// verifyCAR reads a whole CAR and only accepts blocks it was led to
+func verifyCAR(r io.Reader, root myipld.MyCID, complete bool) ([]block, int64, error) {
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(cr.Roots) != 1 || cr.Roots[0] != root {
+		return nil, 0, fmt.Errorf("%w: car roots %v, asked for %s", ErrUnexpectedBlock, cr.Roots, root)
+	}
+
+	expected := map[myipld.MyCID]bool{root: true}
+	received := make(map[myipld.MyCID]bool)
+	var blocks []block
+	var size int64
+	for {
+		cid, data, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, size, err
+		}
+		size += int64(len(data))
+
+		if got, _ := myipld.ComputeSHA256(data); got != cid {
+			return nil, size, fmt.Errorf("%w: %s", ErrBlockMismatch, cid)
+		}
+		if !expected[cid] {
+			return nil, size, fmt.Errorf("%w: %s", ErrUnexpectedBlock, cid)
+		}
+		if received[cid] {
+			continue
+		}
+		received[cid] = true
+		blocks = append(blocks, block{cid: cid, data: data})
+
+		if node, err := myipld.FromBytes(data); err == nil {
+			for _, link := range node.Links {
+				expected[link.Cid] = true
+			}
+		}
+	}
+
+	if complete && len(received) != len(expected) {
+		return nil, size, fmt.Errorf("%w: got %d of %d", ErrIncompleteCAR, len(received), len(expected))
+	}
+	return blocks, size, nil
+}
+
+func (c *Client) request(ctx context.Context, gw *GatewayStats, cid myipld.MyCID, accept, query string) (io.ReadCloser, error) {
+	u := gw.URL + "/ipfs/" + car.ToCid(cid).String() + "?" + query
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s at %s", myipld.ErrNotFound, cid, gw.URL)
+		}
+		return nil, fmt.Errorf("%s : %s", gw.URL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// attempt fetches and verifies from one gateway, on success it returns
+// a commit func that publishes the result, only the winner's runs
+type attempt func(ctx context.Context, gw *GatewayStats) (int64, func(), error)
+
+type outcome struct {
+	gw     *GatewayStats
+	commit func()
+	err    error
+}
+
+// do runs fn against the gateways, Race at a time, until one succeeds
+func (c *Client) do(ctx context.Context, cid myipld.MyCID, fn attempt) error {
+	order := c.order()
+	results := make(chan outcome, len(order))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := func(gw *GatewayStats) {
+		go func() {
+			actx, acancel := context.WithTimeout(ctx, c.cfg.Timeout)
+			defer acancel()
+			size, commit, err := fn(actx, gw)
+			if err != nil && actx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+				err = fmt.Errorf("%s : too slow : %w", gw.URL, err)
+			}
+			c.record(gw, size, err)
+			results <- outcome{gw: gw, commit: commit, err: err}
+		}()
+	}
+
+	next, running := 0, 0
+	for ; next < len(order) && running < c.cfg.Race; next++ {
+		start(order[next])
+		running++
+	}
+
+	var errs []error
+	for running > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case res := <-results:
+			running--
+			if res.err == nil {
+				c.mu.Lock()
+				res.gw.Wins++
+				c.mu.Unlock()
+				res.commit()
+				return nil
+			}
+			errs = append(errs, res.err)
+			if next < len(order) {
+				start(order[next])
+				next++
+				running++
+			}
+		}
+	}
+
+	notFound := true
+	for _, err := range errs {
+		notFound = notFound && errors.Is(err, myipld.ErrNotFound)
+	}
+	if notFound {
+		return fmt.Errorf("%w: %s on every gateway", myipld.ErrNotFound, cid)
+	}
+	return fmt.Errorf("%w: %s : %w", ErrAllGatewaysFailed, cid, errors.Join(errs...))
+}
+
+// order puts the gateways with the fewest failures first, config order
+// breaks ties
+func (c *Client) order() []*GatewayStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	order := append([]*GatewayStats(nil), c.stats...)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].Failures < order[j].Failures })
+	return order
+}
+
+func (c *Client) record(gw *GatewayStats, size int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gw.Requests++
+	gw.Bytes += size
+	// losing a race is not the gateway's fault
+	if err != nil && !errors.Is(err, context.Canceled) {
+		gw.Failures++
+	}
+}
@@ -0,0 +1,378 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"ipld-benchmark/car"
+	"ipld-benchmark/myipld"
+)
+
+const (
+	ContentTypeRaw = "application/vnd.ipld.raw"
+	ContentTypeCAR = "application/vnd.ipld.car"
+
+	// what every response carries, the content behind a CID never changes
+	ImmutableCacheControl = "public, max-age=29030400, immutable"
+)
+
+// Scope is the dag-scope parameter of a CAR request
+type Scope string
+
+const (
+	// ScopeBlock is only the block the path ends at
+	ScopeBlock Scope = "block"
+	// ScopeEntity adds its direct children, a segment and its chunks
+	ScopeEntity Scope = "entity"
+	// ScopeAll is the whole DAG under it, limited by depth
+	ScopeAll Scope = "all"
+)
+
+/* {comment}
+
+Server is a trustless gateway, it only hands out data the client can
+check against the CID it asked for:
+
+	GET /ipfs/<cid>                       one block, application/vnd.ipld.raw
+	GET /ipfs/<cid>[/<link>...]           a CAR, application/vnd.ipld.car
+
+the format comes from ?format=raw|car or the Accept header. CAR requests
+take dag-scope=block|entity|all and depth=<n>|all, the blocks along a
+path are always included so the client can verify how it got to the
+end of it. blocks are written depth first in link order without
+duplicates. <cid> is a CIDv1 (or v0) with a sha2-256 hash or a hex MyCID
+
+raw responses honour Range, both honour If-None-Match against a strong
+ETag, with * and lists of weak or strong tags like net/http, and
+everything is cached as immutable
+
+{/comment} */
+
+type Server struct {
+	bs myipld.BlockGetter
+}
+
+func NewServer(bs myipld.BlockGetter) *Server {
+	return &Server{bs: bs}
+}
+
+type request struct {
+	root   myipld.MyCID
+	path   []string
+	format string
+	scope  Scope
+	// depth below the end of the path, -1 for no limit
+	depth int
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, status, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Vary", "Accept")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	switch req.format {
+	case "raw":
+		s.serveRaw(w, r, req)
+	case "car":
+		s.serveCAR(w, r, req)
+	}
+}
+
+func parseRequest(r *http.Request) (*request, int, error) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/ipfs/")
+	if !ok || rest == "" {
+		return nil, http.StatusNotFound, errors.New("expected /ipfs/<cid>")
+	}
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	root, err := car.ParseCid(parts[0])
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	req := &request{root: root, path: parts[1:], scope: ScopeAll, depth: -1}
+
+	q := r.URL.Query()
+	if req.format, err = negotiate(q.Get("format"), r.Header.Get("Accept")); err != nil {
+		return nil, http.StatusNotAcceptable, err
+	}
+	if req.format == "raw" && len(req.path) > 0 {
+		return nil, http.StatusBadRequest, errors.New("a raw block cannot be resolved by path, ask for a car")
+	}
+
+	if scope := q.Get("dag-scope"); scope != "" {
+		switch Scope(scope) {
+		case ScopeBlock, ScopeEntity, ScopeAll:
+			req.scope = Scope(scope)
+		default:
+			return nil, http.StatusBadRequest, fmt.Errorf("unknown dag-scope %q", scope)
+		}
+	}
+	if depth := q.Get("depth"); depth != "" && depth != "all" {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid depth %q", depth)
+		}
+		req.depth = n
+	}
+	switch {
+	case req.scope == ScopeBlock:
+		req.depth = 0
+	case req.scope == ScopeEntity && (req.depth < 0 || req.depth > 1):
+		req.depth = 1
+	}
+	return req, 0, nil
+}
+
+// negotiate picks raw or car from the format parameter, falling back to
+// the first acceptable type in the Accept header
+func negotiate(format, accept string) (string, error) {
+	switch format {
+	case "raw", "car":
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case ContentTypeRaw:
+			return "raw", nil
+		case ContentTypeCAR:
+			if v, ok := params["version"]; ok && v != "1" {
+				continue
+			}
+			return "car", nil
+		}
+	}
+	return "", fmt.Errorf("trustless gateway, accept %s or %s", ContentTypeRaw, ContentTypeCAR)
+}
+
+func (s *Server) serveRaw(w http.ResponseWriter, r *http.Request, req *request) {
+	data, err := s.bs.Get(req.root)
+	if err != nil {
+		writeGetError(w, req.root, err)
+		return
+	}
+
+	c := car.ToCid(req.root).String()
+	h := w.Header()
+	h.Set("Content-Type", ContentTypeRaw)
+	h.Set("Cache-Control", ImmutableCacheControl)
+	h.Set("ETag", `"`+c+`.raw"`)
+	h.Set("X-Ipfs-Path", "/ipfs/"+c)
+	h.Set("X-Ipfs-Roots", c)
+	h.Set("Content-Disposition", `attachment; filename="`+c+`.bin"`)
+	// Range, If-None-Match and HEAD
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *Server) serveCAR(w http.ResponseWriter, r *http.Request, req *request) {
+	// resolve the path first so a bad one is still a proper error
+	pathBlocks, end, err := s.resolve(req)
+	if err != nil {
+		if errors.Is(err, myipld.ErrNotFound) {
+			writeGetError(w, req.root, err)
+		} else {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}
+		return
+	}
+
+	c := car.ToCid(req.root).String()
+	etag := fmt.Sprintf(`"%s.car.%s"`, c, req.hash())
+	roots := make([]string, 0, len(pathBlocks))
+	for _, b := range pathBlocks {
+		roots = append(roots, car.ToCid(b.cid).String())
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ContentTypeCAR+"; version=1; order=dfs; dups=n")
+	h.Set("Cache-Control", ImmutableCacheControl)
+	h.Set("ETag", etag)
+	h.Set("Accept-Ranges", "none")
+	h.Set("X-Ipfs-Path", "/ipfs/"+strings.Join(append([]string{c}, req.path...), "/"))
+	h.Set("X-Ipfs-Roots", strings.Join(roots, ","))
+	h.Set("Content-Disposition", `attachment; filename="`+c+`.car"`)
+
+	if noneMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	cw, err := car.NewWriter(w, req.root)
+	if err != nil {
+		return
+	}
+	seen := make(map[myipld.MyCID]bool)
+	for _, b := range pathBlocks[:len(pathBlocks)-1] {
+		seen[b.cid] = true
+		if err := cw.Put(b.cid, b.data); err != nil {
+			return
+		}
+	}
+	if err := s.writeDAG(cw, end.cid, end.data, req.depth, seen); err != nil {
+		// the status line is gone, cutting the connection is the only way
+		// left to tell the client the CAR is incomplete
+		log.Printf("gateway: car for %s cut short: %v", c, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+type block struct {
+	cid  myipld.MyCID
+	data []byte
+}
+
+// resolve walks the path by link name and returns every block on the
+// way, the last one is where the DAG is served from
+func (s *Server) resolve(req *request) ([]block, block, error) {
+	data, err := s.bs.Get(req.root)
+	if err != nil {
+		return nil, block{}, err
+	}
+	blocks := []block{{cid: req.root, data: data}}
+
+	for _, name := range req.path {
+		node, err := myipld.FromBytes(blocks[len(blocks)-1].data)
+		if err != nil {
+			return nil, block{}, fmt.Errorf("cannot resolve %q, not a node : %v", name, err)
+		}
+		var next *myipld.MyLink
+		for i := range node.Links {
+			if node.Links[i].Name == name {
+				next = &node.Links[i]
+				break
+			}
+		}
+		if next == nil {
+			return nil, block{}, fmt.Errorf("no link named %q", name)
+		}
+		data, err := s.bs.Get(next.Cid)
+		if err != nil {
+			return nil, block{}, err
+		}
+		blocks = append(blocks, block{cid: next.Cid, data: data})
+	}
+	return blocks, blocks[len(blocks)-1], nil
+}
+
+func (s *Server) writeDAG(cw *car.Writer, c myipld.MyCID, data []byte, depth int, seen map[myipld.MyCID]bool) error {
+	if seen[c] {
+		return nil
+	}
+	seen[c] = true
+	if err := cw.Put(c, data); err != nil {
+		return err
+	}
+	if depth == 0 {
+		return nil
+	}
+
+	node, err := myipld.FromBytes(data)
+	if err != nil {
+		// a leaf that is not a node, e.g. raw bytes
+		return nil
+	}
+	for _, link := range node.Links {
+		if seen[link.Cid] {
+			continue
+		}
+		child, err := s.bs.Get(link.Cid)
+		if err != nil {
+			return fmt.Errorf("block %s : %w", link.Cid, err)
+		}
+		if err := s.writeDAG(cw, link.Cid, child, depth-1, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hash covers every parameter that changes the bytes of a CAR response
+func (req *request) hash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", strings.Join(req.path, "/"), req.scope, req.depth)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// noneMatch is whether an If-None-Match header, * or a list of ETags
+// weak or strong, matches etag the way net/http compares them
+func noneMatch(header, etag string) bool {
+	for header = textproto.TrimString(header); header != ""; header = textproto.TrimString(header) {
+		if header[0] == ',' {
+			header = header[1:]
+			continue
+		}
+		if header[0] == '*' {
+			return true
+		}
+		tag, rest, ok := scanETag(header)
+		if !ok {
+			return false
+		}
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+		header = rest
+	}
+	return false
+}
+
+// scanETag splits the ETag header starts with off the rest of it
+func scanETag(s string) (tag, rest string, ok bool) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s[start:]) < 2 || s[start] != '"' {
+		return "", "", false
+	}
+	// an etagc is %x21 / %x23-7E / obs-text
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			return s[:i+1], s[i+1:], true
+		}
+		if c != 0x21 && (c < 0x23 || c > 0x7e) && c < 0x80 {
+			return "", "", false
+		}
+	}
+	return "", "", false
+}
+
+func writeGetError(w http.ResponseWriter, c myipld.MyCID, err error) {
+	if errors.Is(err, myipld.ErrNotFound) {
+		http.Error(w, fmt.Sprintf("block %s not found", car.ToCid(c)), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,381 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+var (
+	ErrInvalidCuts     = errors.New("invalid segment cuts")
+	ErrInvalidManifest = errors.New("invalid manifest")
+)
+
+// DefaultChunkSize is the leaf block size inside a segment
+const DefaultChunkSize = 256 << 10
+
+// Cut is where a segment starts, in presentation time and in the file
+type Cut struct {
+	Time   time.Duration
+	Offset int64
+}
+
+type ImportOptions struct {
+	Name string
+	// Duration is the running time of the whole file
+	Duration time.Duration
+	// SegmentDuration splits by byte ranges, the file is taken as
+	// constant bitrate so every segment gets the same share of bytes
+	SegmentDuration time.Duration
+	// Cuts are supplied segment starts (from a keyframe index, say) and
+	// win over SegmentDuration. the first one has to be at 0
+	Cuts []Cut
+	// ChunkSize defaults to DefaultChunkSize
+	ChunkSize int
+}
+
+type Segment struct {
+	Index    int
+	Start    time.Duration
+	Duration time.Duration
+	Offset   int64
+	Length   int64
+	// Cid is the root of the segment subtree
+	Cid myipld.MyCID
+}
+
+/* {comment}
+
+Asset is one imported media file
+
+the manifest node lists every segment with its duration and CID and
+links to the segment nodes, which link to the raw chunks of the segment
+
+manifest -> segment-00000 -> chunk-0, chunk-1 ...
+         -> segment-00001 -> ...
+
+{/comment} */
+
+type Asset struct {
+	Name           string
+	Duration       time.Duration
+	TargetDuration time.Duration
+	Size           int64
+	Manifest       *myipld.MyNode
+	Segments       []Segment
+}
+
+type chunkData struct {
+	Type  string `json:"type"`
+	Bytes []byte `json:"bytes"`
+}
+
+type segmentData struct {
+	Type     string `json:"type"`
+	Index    int    `json:"index"`
+	StartMs  int64  `json:"start_ms"`
+	Duration int64  `json:"duration_ms"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+}
+
+type manifestEntry struct {
+	Index    int    `json:"index"`
+	StartMs  int64  `json:"start_ms"`
+	Duration int64  `json:"duration_ms"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Cid      string `json:"cid"`
+}
+
+type manifestData struct {
+	Type           string          `json:"type"`
+	Name           string          `json:"name"`
+	Duration       int64           `json:"duration_ms"`
+	TargetDuration int64           `json:"target_duration_ms"`
+	Size           int64           `json:"size"`
+	Segments       []manifestEntry `json:"segments"`
+}
+
+// SegmentName is the link name of segment i in the manifest
+func SegmentName(i int) string {
+	return fmt.Sprintf("segment-%05d", i)
+}
+
+// FixedCuts spreads size bytes evenly over segments of segDur, the last
+// segment takes whatever time is left
+func FixedCuts(size int64, duration, segDur time.Duration) ([]Cut, error) {
+	if segDur <= 0 {
+		return nil, fmt.Errorf("%w: segment duration has to be positive", ErrInvalidCuts)
+	}
+
+	var times []time.Duration
+	for start := time.Duration(0); start < duration; start += segDur {
+		times = append(times, start)
+	}
+	return CutsAt(times, size, duration)
+}
+
+// CutsAt places cuts at the given times with the bytes spread evenly
+// over duration, like a constant bitrate file
+func CutsAt(times []time.Duration, size int64, duration time.Duration) ([]Cut, error) {
+	if size <= 0 || duration <= 0 || len(times) == 0 {
+		return nil, fmt.Errorf("%w: size, duration and cut times are required", ErrInvalidCuts)
+	}
+
+	cuts := make([]Cut, 0, len(times))
+	for _, start := range times {
+		offset := int64(float64(size) * float64(start) / float64(duration))
+		if len(cuts) > 0 && offset <= cuts[len(cuts)-1].Offset {
+			return nil, fmt.Errorf("%w: %d bytes is too small to cut at %s", ErrInvalidCuts, size, start)
+		}
+		cuts = append(cuts, Cut{Time: start, Offset: offset})
+	}
+	return cuts, nil
+}
+
+// ImportFile imports the file at path, see Import
+func ImportFile(path string, opts ImportOptions, bs myipld.Blockstore) (*Asset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Name == "" {
+		opts.Name = info.Name()
+	}
+	return Import(f, info.Size(), opts, bs)
+}
+
+// Import reads size bytes of media from r one segment at a time and
+// writes the segment subtrees and the manifest to bs
+func Import(r io.Reader, size int64, opts ImportOptions, bs myipld.Blockstore) (*Asset, error) {
+	cuts := opts.Cuts
+	if len(cuts) == 0 {
+		var err error
+		if cuts, err = FixedCuts(size, opts.Duration, opts.SegmentDuration); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateCuts(cuts, size, opts.Duration); err != nil {
+		return nil, err
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	asset := &Asset{Name: opts.Name, Duration: opts.Duration, Size: size}
+	buf := make([]byte, chunkSize)
+
+	for i, cut := range cuts {
+		seg := Segment{Index: i, Start: cut.Time, Offset: cut.Offset}
+		if i+1 < len(cuts) {
+			seg.Duration = cuts[i+1].Time - cut.Time
+			seg.Length = cuts[i+1].Offset - cut.Offset
+		} else {
+			seg.Duration = opts.Duration - cut.Time
+			seg.Length = size - cut.Offset
+		}
+
+		cid, err := importSegment(io.LimitReader(r, seg.Length), seg, buf, bs)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d : %w", i, err)
+		}
+		seg.Cid = cid
+
+		if seg.Duration > asset.TargetDuration {
+			asset.TargetDuration = seg.Duration
+		}
+		asset.Segments = append(asset.Segments, seg)
+	}
+
+	manifest, err := buildManifest(asset)
+	if err != nil {
+		return nil, err
+	}
+	if err := myipld.PutNode(bs, manifest); err != nil {
+		return nil, err
+	}
+	asset.Manifest = manifest
+	return asset, nil
+}
+
+func validateCuts(cuts []Cut, size int64, duration time.Duration) error {
+	if cuts[0].Time != 0 || cuts[0].Offset != 0 {
+		return fmt.Errorf("%w: the first segment has to start at 0", ErrInvalidCuts)
+	}
+	for i := 1; i < len(cuts); i++ {
+		if cuts[i].Time <= cuts[i-1].Time || cuts[i].Offset <= cuts[i-1].Offset {
+			return fmt.Errorf("%w: cut %d does not come after cut %d", ErrInvalidCuts, i, i-1)
+		}
+	}
+	last := cuts[len(cuts)-1]
+	if last.Time >= duration || last.Offset >= size {
+		return fmt.Errorf("%w: the last cut is past the end of the file", ErrInvalidCuts)
+	}
+	return nil
+}
+
+func importSegment(r io.Reader, seg Segment, buf []byte, bs myipld.Blockstore) (myipld.MyCID, error) {
+	var links []myipld.MyLink
+	var read int64
+
+	for read < seg.Length {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk, err := myipld.NewMyNode(chunkData{Type: "chunk", Bytes: buf[:n]})
+			if err != nil {
+				return myipld.MyCID{}, err
+			}
+			if err := myipld.PutNode(bs, chunk); err != nil {
+				return myipld.MyCID{}, err
+			}
+			links = append(links, myipld.MyLink{Name: fmt.Sprintf("chunk-%d", len(links)), Cid: chunk.Cid})
+			read += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return myipld.MyCID{}, err
+		}
+	}
+	if read != seg.Length {
+		return myipld.MyCID{}, fmt.Errorf("expected %d bytes, file ended after %d", seg.Length, read)
+	}
+
+	node, err := myipld.NewMyNodeWithLinks(segmentData{
+		Type:     "segment",
+		Index:    seg.Index,
+		StartMs:  seg.Start.Milliseconds(),
+		Duration: seg.Duration.Milliseconds(),
+		Offset:   seg.Offset,
+		Length:   seg.Length,
+	}, links)
+	if err != nil {
+		return myipld.MyCID{}, err
+	}
+	if err := myipld.PutNode(bs, node); err != nil {
+		return myipld.MyCID{}, err
+	}
+	return node.Cid, nil
+}
+
+func buildManifest(asset *Asset) (*myipld.MyNode, error) {
+	data := manifestData{
+		Type:           "manifest",
+		Name:           asset.Name,
+		Duration:       asset.Duration.Milliseconds(),
+		TargetDuration: asset.TargetDuration.Milliseconds(),
+		Size:           asset.Size,
+	}
+	links := make([]myipld.MyLink, 0, len(asset.Segments))
+	for _, seg := range asset.Segments {
+		data.Segments = append(data.Segments, manifestEntry{
+			Index:    seg.Index,
+			StartMs:  seg.Start.Milliseconds(),
+			Duration: seg.Duration.Milliseconds(),
+			Offset:   seg.Offset,
+			Length:   seg.Length,
+			Cid:      seg.Cid.Hex(),
+		})
+		links = append(links, myipld.MyLink{Name: SegmentName(seg.Index), Cid: seg.Cid})
+	}
+	return myipld.NewMyNodeWithLinks(data, links)
+}
+
+// LoadAsset reads an asset back from its manifest CID
+func LoadAsset(bs myipld.BlockGetter, manifestCid myipld.MyCID) (*Asset, error) {
+	node, err := myipld.GetNode(bs, manifestCid)
+	if err != nil {
+		return nil, err
+	}
+	return decodeManifest(node)
+}
+
+func decodeManifest(node *myipld.MyNode) (*Asset, error) {
+	var data manifestData
+	if err := json.Unmarshal(node.Data, &data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	if data.Type != "manifest" {
+		return nil, fmt.Errorf("%w: node is a %q", ErrInvalidManifest, data.Type)
+	}
+	if len(data.Segments) != len(node.Links) {
+		return nil, fmt.Errorf("%w: %d segments but %d links", ErrInvalidManifest, len(data.Segments), len(node.Links))
+	}
+
+	asset := &Asset{
+		Name:           data.Name,
+		Duration:       time.Duration(data.Duration) * time.Millisecond,
+		TargetDuration: time.Duration(data.TargetDuration) * time.Millisecond,
+		Size:           data.Size,
+		Manifest:       node,
+	}
+	for i, entry := range data.Segments {
+		cid, err := myipld.ParseMyCID(entry.Cid)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+		}
+		// the listed CID has to be the one the manifest links to, or a
+		// player could be sent somewhere the DAG does not cover
+		if node.Links[i].Cid != cid {
+			return nil, fmt.Errorf("%w: segment %d lists %s but links %s", ErrInvalidManifest, i, cid, node.Links[i].Cid)
+		}
+		asset.Segments = append(asset.Segments, Segment{
+			Index:    entry.Index,
+			Start:    time.Duration(entry.StartMs) * time.Millisecond,
+			Duration: time.Duration(entry.Duration) * time.Millisecond,
+			Offset:   entry.Offset,
+			Length:   entry.Length,
+			Cid:      cid,
+		})
+	}
+	return asset, nil
+}
+
+// SegmentAt returns the segment playing at t
+func (a *Asset) SegmentAt(t time.Duration) (Segment, bool) {
+	i := sort.Search(len(a.Segments), func(i int) bool {
+		return a.Segments[i].Start > t
+	})
+	if i == 0 || t >= a.Duration {
+		return Segment{}, false
+	}
+	return a.Segments[i-1], true
+}
+
+// ReadSegment reassembles the media bytes of one segment
+func ReadSegment(bs myipld.BlockGetter, segmentCid myipld.MyCID) ([]byte, error) {
+	node, err := myipld.GetNode(bs, segmentCid)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	for _, link := range node.Links {
+		chunk, err := myipld.GetNode(bs, link.Cid)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %s : %w", link.Name, err)
+		}
+		var data chunkData
+		if err := json.Unmarshal(chunk.Data, &data); err != nil {
+			return nil, fmt.Errorf("chunk %s : %w", link.Name, err)
+		}
+		out = append(out, data.Bytes...)
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package media
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type mpd struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	Xmlns                     string   `xml:"xmlns,attr"`
+	Type                      string   `xml:"type,attr"`
+	Profiles                  string   `xml:"profiles,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	Period                    mpdPeriod
+}
+
+type mpdPeriod struct {
+	XMLName       xml.Name `xml:"Period"`
+	ID            string   `xml:"id,attr"`
+	Duration      string   `xml:"duration,attr"`
+	AdaptationSet mpdAdaptationSet
+}
+
+type mpdAdaptationSet struct {
+	XMLName          xml.Name `xml:"AdaptationSet"`
+	MimeType         string   `xml:"mimeType,attr"`
+	SegmentAlignment bool     `xml:"segmentAlignment,attr"`
+	StartWithSAP     int      `xml:"startWithSAP,attr"`
+	Representations  []mpdRepresentation
+}
+
+type mpdRepresentation struct {
+	XMLName     xml.Name `xml:"Representation"`
+	ID          string   `xml:"id,attr"`
+	Bandwidth   int      `xml:"bandwidth,attr"`
+	Width       int      `xml:"width,attr,omitempty"`
+	Height      int      `xml:"height,attr,omitempty"`
+	Codecs      string   `xml:"codecs,attr,omitempty"`
+	SegmentList mpdSegmentList
+}
+
+// segment durations are listed one by one in a timeline because supplied
+// cuts do not have to be evenly spaced
+type mpdSegmentList struct {
+	XMLName     xml.Name        `xml:"SegmentList"`
+	Timescale   int             `xml:"timescale,attr"`
+	Timeline    []mpdTimelineS  `xml:"SegmentTimeline>S"`
+	SegmentURLs []mpdSegmentURL `xml:"SegmentURL"`
+}
+
+type mpdTimelineS struct {
+	T int64 `xml:"t,attr"`
+	D int64 `xml:"d,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// mpdDuration formats an ISO 8601 duration the way MPDs use them
+func mpdDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
+
+// WriteDASH writes the title as a static MPD with one representation per
+// rendition, segment URLs are uriPrefix followed by the segment CID
+func WriteDASH(w io.Writer, title *Title, uriPrefix string) error {
+	if uriPrefix == "" {
+		uriPrefix = DefaultURIPrefix
+	}
+
+	var target time.Duration
+	set := mpdAdaptationSet{MimeType: "video/mp4", SegmentAlignment: true, StartWithSAP: 1}
+	for _, r := range title.Renditions {
+		rep := mpdRepresentation{
+			ID:          r.Name,
+			Bandwidth:   r.Bandwidth,
+			Width:       r.Width,
+			Height:      r.Height,
+			Codecs:      r.Codecs,
+			SegmentList: mpdSegmentList{Timescale: 1000},
+		}
+		for _, seg := range r.Asset.Segments {
+			rep.SegmentList.Timeline = append(rep.SegmentList.Timeline, mpdTimelineS{
+				T: seg.Start.Milliseconds(),
+				D: seg.Duration.Milliseconds(),
+			})
+			rep.SegmentList.SegmentURLs = append(rep.SegmentList.SegmentURLs, mpdSegmentURL{Media: uriPrefix + seg.Cid.Hex()})
+		}
+		if r.Asset.TargetDuration > target {
+			target = r.Asset.TargetDuration
+		}
+		set.Representations = append(set.Representations, rep)
+	}
+
+	doc := mpd{
+		Xmlns:                     "urn:mpeg:dash:schema:mpd:2011",
+		Type:                      "static",
+		Profiles:                  "urn:mpeg:dash:profile:isoff-on-demand:2011",
+		MediaPresentationDuration: mpdDuration(title.Duration),
+		MinBufferTime:             mpdDuration(target),
+		Period: mpdPeriod{
+			ID:            "0",
+			Duration:      mpdDuration(title.Duration),
+			AdaptationSet: set,
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
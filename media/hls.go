@@ -0,0 +1,50 @@
+package media
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+// DefaultURIPrefix makes segment URIs gateway paths, /ipfs/<cid>
+const DefaultURIPrefix = "/ipfs/"
+
+// WriteHLS writes the asset as a VOD media playlist, every segment URI
+// is uriPrefix followed by the segment CID in hex
+func WriteHLS(w io.Writer, asset *Asset, uriPrefix string) error {
+	if uriPrefix == "" {
+		uriPrefix = DefaultURIPrefix
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	fmt.Fprintln(bw, "#EXT-X-VERSION:3")
+	// the target duration is a whole number of seconds no segment exceeds
+	fmt.Fprintf(bw, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(asset.TargetDuration.Seconds())))
+	fmt.Fprintln(bw, "#EXT-X-MEDIA-SEQUENCE:0")
+	fmt.Fprintln(bw, "#EXT-X-PLAYLIST-TYPE:VOD")
+	for _, seg := range asset.Segments {
+		fmt.Fprintf(bw, "#EXTINF:%.3f,\n", seg.Duration.Seconds())
+		fmt.Fprintf(bw, "%s%s\n", uriPrefix, seg.Cid.Hex())
+	}
+	fmt.Fprintln(bw, "#EXT-X-ENDLIST")
+	return bw.Flush()
+}
+
+// WriteHLSMaster writes the master playlist of a title, the variant
+// playlists are expected next to it as <rendition>.m3u8 (see WriteHLS)
+func WriteHLSMaster(w io.Writer, title *Title) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	fmt.Fprintln(bw, "#EXT-X-VERSION:3")
+	fmt.Fprintln(bw, "#EXT-X-INDEPENDENT-SEGMENTS")
+	for _, r := range title.Renditions {
+		fmt.Fprintf(bw, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d", r.Bandwidth, r.Width, r.Height)
+		if r.Codecs != "" {
+			fmt.Fprintf(bw, ",CODECS=%q", r.Codecs)
+		}
+		fmt.Fprintf(bw, "\n%s.m3u8\n", r.Name)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,284 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+var ErrUnknownRendition = errors.New("unknown rendition")
+
+// Rendition describes one encoding of a title, Bandwidth is in bits
+// per second like HLS and DASH want it
+type Rendition struct {
+	Name      string `json:"name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Bandwidth int    `json:"bandwidth"`
+	Codecs    string `json:"codecs,omitempty"`
+}
+
+// DefaultLadder is a typical bitrate ladder from 240p to 4K
+var DefaultLadder = []Rendition{
+	{Name: "240p", Width: 426, Height: 240, Bandwidth: 400_000, Codecs: "avc1.4d4015"},
+	{Name: "360p", Width: 640, Height: 360, Bandwidth: 800_000, Codecs: "avc1.4d401e"},
+	{Name: "480p", Width: 854, Height: 480, Bandwidth: 1_400_000, Codecs: "avc1.4d401f"},
+	{Name: "720p", Width: 1280, Height: 720, Bandwidth: 2_800_000, Codecs: "avc1.4d401f"},
+	{Name: "1080p", Width: 1920, Height: 1080, Bandwidth: 5_000_000, Codecs: "avc1.640028"},
+	{Name: "2160p", Width: 3840, Height: 2160, Bandwidth: 16_000_000, Codecs: "hvc1.2.4.L150"},
+}
+
+// RenditionSource is the encoded file of one rendition
+type RenditionSource struct {
+	Rendition Rendition
+	Reader    io.Reader
+	Size      int64
+	// Offsets are where the title's cut times fall in this file, when
+	// empty the bytes are spread evenly (see CutsAt)
+	Offsets []int64
+}
+
+type TitleOptions struct {
+	Name     string
+	Duration time.Duration
+	// CutTimes are the segment starts every rendition shares, when empty
+	// the title is cut every SegmentDuration
+	CutTimes        []time.Duration
+	SegmentDuration time.Duration
+	ChunkSize       int
+}
+
+type TitleRendition struct {
+	Rendition
+	Asset *Asset
+}
+
+/* {comment}
+
+Title is the top of an adaptive bitrate DAG, its node lists and links
+every rendition manifest
+
+title -> rendition-240p  -> segment-00000 ...
+      -> rendition-1080p -> segment-00000 ...
+
+all renditions are cut at the same times, so segment i covers the same
+stretch of the title in each of them and a player can switch between any
+two segments without fetching anything of the old rendition
+
+{/comment} */
+
+type Title struct {
+	Name       string
+	Duration   time.Duration
+	Node       *myipld.MyNode
+	Renditions []TitleRendition
+}
+
+type titleEntry struct {
+	Rendition
+	Manifest string `json:"manifest"`
+}
+
+type titleData struct {
+	Type       string       `json:"type"`
+	Name       string       `json:"name"`
+	Duration   int64        `json:"duration_ms"`
+	Segments   int          `json:"segments"`
+	Renditions []titleEntry `json:"renditions"`
+}
+
+// RenditionName is the link name of a rendition in the title node
+func RenditionName(name string) string {
+	return "rendition-" + name
+}
+
+// ImportTitle imports every rendition with the same cut times and links
+// them under one title node, renditions end up sorted by bandwidth
+func ImportTitle(opts TitleOptions, sources []RenditionSource, bs myipld.Blockstore) (*Title, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("a title needs at least one rendition")
+	}
+
+	times := opts.CutTimes
+	if len(times) == 0 {
+		if opts.SegmentDuration <= 0 {
+			return nil, fmt.Errorf("%w: need cut times or a segment duration", ErrInvalidCuts)
+		}
+		for start := time.Duration(0); start < opts.Duration; start += opts.SegmentDuration {
+			times = append(times, start)
+		}
+	}
+
+	sorted := append([]RenditionSource(nil), sources...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Rendition.Bandwidth < sorted[j].Rendition.Bandwidth
+	})
+
+	title := &Title{Name: opts.Name, Duration: opts.Duration}
+	seen := make(map[string]bool)
+	for _, src := range sorted {
+		name := src.Rendition.Name
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("rendition names have to be unique and set, got %q twice", name)
+		}
+		seen[name] = true
+
+		cuts, err := renditionCuts(times, src, opts.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("rendition %s : %w", name, err)
+		}
+		asset, err := Import(src.Reader, src.Size, ImportOptions{
+			Name:      opts.Name + "/" + name,
+			Duration:  opts.Duration,
+			Cuts:      cuts,
+			ChunkSize: opts.ChunkSize,
+		}, bs)
+		if err != nil {
+			return nil, fmt.Errorf("rendition %s : %w", name, err)
+		}
+		title.Renditions = append(title.Renditions, TitleRendition{Rendition: src.Rendition, Asset: asset})
+	}
+
+	node, err := buildTitleNode(title)
+	if err != nil {
+		return nil, err
+	}
+	if err := myipld.PutNode(bs, node); err != nil {
+		return nil, err
+	}
+	title.Node = node
+	return title, nil
+}
+
+func renditionCuts(times []time.Duration, src RenditionSource, duration time.Duration) ([]Cut, error) {
+	if len(src.Offsets) == 0 {
+		return CutsAt(times, src.Size, duration)
+	}
+	if len(src.Offsets) != len(times) {
+		return nil, fmt.Errorf("%w: %d offsets for %d cut times", ErrInvalidCuts, len(src.Offsets), len(times))
+	}
+	cuts := make([]Cut, len(times))
+	for i := range times {
+		cuts[i] = Cut{Time: times[i], Offset: src.Offsets[i]}
+	}
+	return cuts, nil
+}
+
+func buildTitleNode(title *Title) (*myipld.MyNode, error) {
+	data := titleData{
+		Type:     "title",
+		Name:     title.Name,
+		Duration: title.Duration.Milliseconds(),
+		Segments: len(title.Renditions[0].Asset.Segments),
+	}
+	links := make([]myipld.MyLink, 0, len(title.Renditions))
+	for _, r := range title.Renditions {
+		data.Renditions = append(data.Renditions, titleEntry{Rendition: r.Rendition, Manifest: r.Asset.Manifest.Cid.Hex()})
+		links = append(links, myipld.MyLink{Name: RenditionName(r.Name), Cid: r.Asset.Manifest.Cid})
+	}
+	return myipld.NewMyNodeWithLinks(data, links)
+}
+
+// LoadTitle reads a title and all its rendition manifests back and
+// checks the renditions are still aligned
+func LoadTitle(bs myipld.BlockGetter, titleCid myipld.MyCID) (*Title, error) {
+	node, err := myipld.GetNode(bs, titleCid)
+	if err != nil {
+		return nil, err
+	}
+
+	var data titleData
+	if err := json.Unmarshal(node.Data, &data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	if data.Type != "title" || len(data.Renditions) != len(node.Links) || len(data.Renditions) == 0 {
+		return nil, fmt.Errorf("%w: not a title node", ErrInvalidManifest)
+	}
+
+	title := &Title{
+		Name:     data.Name,
+		Duration: time.Duration(data.Duration) * time.Millisecond,
+		Node:     node,
+	}
+	for i, entry := range data.Renditions {
+		if node.Links[i].Cid.Hex() != entry.Manifest {
+			return nil, fmt.Errorf("%w: rendition %s lists %s but links %s", ErrInvalidManifest, entry.Name, entry.Manifest, node.Links[i].Cid)
+		}
+		asset, err := LoadAsset(bs, node.Links[i].Cid)
+		if err != nil {
+			return nil, fmt.Errorf("rendition %s : %w", entry.Name, err)
+		}
+		title.Renditions = append(title.Renditions, TitleRendition{Rendition: entry.Rendition, Asset: asset})
+	}
+
+	if err := title.checkAligned(); err != nil {
+		return nil, err
+	}
+	return title, nil
+}
+
+func (t *Title) checkAligned() error {
+	first := t.Renditions[0].Asset.Segments
+	for _, r := range t.Renditions[1:] {
+		segs := r.Asset.Segments
+		if len(segs) != len(first) {
+			return fmt.Errorf("%w: rendition %s has %d segments, %s has %d", ErrInvalidManifest, r.Name, len(segs), t.Renditions[0].Name, len(first))
+		}
+		for i := range segs {
+			if segs[i].Start != first[i].Start || segs[i].Duration != first[i].Duration {
+				return fmt.Errorf("%w: segment %d of rendition %s is not aligned", ErrInvalidManifest, i, r.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Title) Rendition(name string) (*TitleRendition, error) {
+	for i := range t.Renditions {
+		if t.Renditions[i].Name == name {
+			return &t.Renditions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownRendition, name)
+}
+
+// SegmentCount is the same for every rendition
+func (t *Title) SegmentCount() int {
+	return len(t.Renditions[0].Asset.Segments)
+}
+
+// Switch moves playback to Rendition from segment At on
+type Switch struct {
+	At        int
+	Rendition string
+}
+
+// PlaybackSegments lists the segment a player fetches for every index,
+// starting on initial and following the switches. nothing of a rendition
+// is needed before the player switches to it
+func (t *Title) PlaybackSegments(initial string, switches ...Switch) ([]Segment, error) {
+	current, err := t.Rendition(initial)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := append([]Switch(nil), switches...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].At < sorted[j].At })
+
+	out := make([]Segment, 0, t.SegmentCount())
+	for i := 0; i < t.SegmentCount(); i++ {
+		for len(sorted) > 0 && sorted[0].At <= i {
+			if current, err = t.Rendition(sorted[0].Rendition); err != nil {
+				return nil, err
+			}
+			sorted = sorted[1:]
+		}
+		out = append(out, current.Asset.Segments[i])
+	}
+	return out, nil
+}
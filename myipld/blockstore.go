@@ -0,0 +1,118 @@
+package myipld
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrNotFound = errors.New("block not found")
+
+// BlockGetter is the read side of a Blockstore, enough for traversal
+type BlockGetter interface {
+	Get(cid MyCID) ([]byte, error)
+}
+
+/* {comment}
+
+Blockstore holds raw blocks keyed by their CID
+the CID is always the hash of the bytes handed to Put
+
+{/comment} */
+
+type Blockstore interface {
+	BlockGetter
+	Put(cid MyCID, data []byte) error
+	Has(cid MyCID) (bool, error)
+	Delete(cid MyCID) error
+	Keys() ([]MyCID, error)
+}
+
+type MemBlockstore struct {
+	mu     sync.RWMutex
+	blocks map[MyCID][]byte
+}
+
+func NewMemBlockstore() *MemBlockstore {
+	return &MemBlockstore{blocks: make(map[MyCID][]byte)}
+}
+
+func (bs *MemBlockstore) Get(cid MyCID) ([]byte, error) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	data, ok := bs.blocks[cid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, cid)
+	}
+	return data, nil
+}
+
+func (bs *MemBlockstore) Put(cid MyCID, data []byte) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	// blocks are immutable, keep our own copy
+	bs.blocks[cid] = append([]byte(nil), data...)
+	return nil
+}
+
+func (bs *MemBlockstore) Has(cid MyCID) (bool, error) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	_, ok := bs.blocks[cid]
+	return ok, nil
+}
+
+func (bs *MemBlockstore) Delete(cid MyCID) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	delete(bs.blocks, cid)
+	return nil
+}
+
+func (bs *MemBlockstore) Keys() ([]MyCID, error) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	keys := make([]MyCID, 0, len(bs.blocks))
+	for cid := range bs.blocks {
+		keys = append(keys, cid)
+	}
+	return keys, nil
+}
+
+func (bs *MemBlockstore) Len() int {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return len(bs.blocks)
+}
+
+// PutNode stores the serialized node under its CID
+func PutNode(bs Blockstore, node *MyNode) error {
+	data, err := node.ToBytes()
+	if err != nil {
+		return fmt.Errorf("failed to serialize node %s : %w", node.Cid, err)
+	}
+	return bs.Put(node.Cid, data)
+}
+
+// GetNode loads and decodes a node, failing if the block does not hash
+// to the requested CID
+func GetNode(bs BlockGetter, cid MyCID) (*MyNode, error) {
+	data, err := bs.Get(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if node.Cid != cid {
+		return nil, fmt.Errorf("block %s decoded to %s", cid, node.Cid)
+	}
+	return node, nil
+}
@@ -0,0 +1,227 @@
+package myipld
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Compressor is the pluggable part of block compression, anything that
+// can round trip a byte slice can be registered
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+type GzipCompressor struct {
+	Level int
+}
+
+func (GzipCompressor) Name() string { return "gzip" }
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, compressionLevel(c.Level))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip writer : %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to gzip block : %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to gzip block : %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip block : %w", err)
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to gunzip block : %w", err)
+	}
+	return out, nil
+}
+
+type DeflateCompressor struct {
+	Level int
+}
+
+func (DeflateCompressor) Name() string { return "deflate" }
+
+func (c DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, compressionLevel(c.Level))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deflate writer : %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to deflate block : %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to deflate block : %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (DeflateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate block : %w", err)
+	}
+	return out, nil
+}
+
+// zero value means default, so the compressors work without setting Level
+func compressionLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		"gzip":    GzipCompressor{},
+		"deflate": DeflateCompressor{},
+	}
+)
+
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+func LookupCompressor(name string) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	c, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compressor %q", name)
+	}
+	return c, nil
+}
+
+func CompressorNames() []string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+const (
+	blockStoredRaw        byte = 0
+	blockStoredCompressed byte = 1
+)
+
+/* {comment}
+
+CompressedBlockstore compresses blocks on the way into the inner store
+and decompresses them on the way out
+
+CIDs are ALWAYS the hash of the uncompressed bytes. the compression is a
+storage detail, so the same node has the same CID whatever compressor a
+peer uses, and callers verify blocks after Get exactly like with a plain
+store. each stored block gets a one byte tag, blocks that would not get
+smaller are kept raw
+
+{/comment} */
+
+type CompressedBlockstore struct {
+	inner Blockstore
+	c     Compressor
+}
+
+func NewCompressedBlockstore(inner Blockstore, c Compressor) *CompressedBlockstore {
+	return &CompressedBlockstore{inner: inner, c: c}
+}
+
+func (bs *CompressedBlockstore) Get(cid MyCID) ([]byte, error) {
+	stored, err := bs.inner.Get(cid)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("stored block %s is missing its compression tag", cid)
+	}
+
+	switch stored[0] {
+	case blockStoredRaw:
+		return stored[1:], nil
+	case blockStoredCompressed:
+		return bs.c.Decompress(stored[1:])
+	default:
+		return nil, fmt.Errorf("stored block %s has unknown compression tag %d", cid, stored[0])
+	}
+}
+
+func (bs *CompressedBlockstore) Put(cid MyCID, data []byte) error {
+	compressed, err := bs.c.Compress(data)
+	if err != nil {
+		return err
+	}
+
+	var stored []byte
+	if len(compressed) < len(data) {
+		stored = append([]byte{blockStoredCompressed}, compressed...)
+	} else {
+		stored = append([]byte{blockStoredRaw}, data...)
+	}
+	return bs.inner.Put(cid, stored)
+}
+
+func (bs *CompressedBlockstore) Has(cid MyCID) (bool, error) {
+	return bs.inner.Has(cid)
+}
+
+func (bs *CompressedBlockstore) Delete(cid MyCID) error {
+	return bs.inner.Delete(cid)
+}
+
+func (bs *CompressedBlockstore) Keys() ([]MyCID, error) {
+	return bs.inner.Keys()
+}
+
+// StoredSize is the number of bytes the block takes in the inner store
+func (bs *CompressedBlockstore) StoredSize(cid MyCID) (int, error) {
+	stored, err := bs.inner.Get(cid)
+	if err != nil {
+		return 0, err
+	}
+	return len(stored), nil
+}
+
+// CompressedSize is the size of the serialized node after compression,
+// without storing anything
+func CompressedSize(node *MyNode, c Compressor) (int, error) {
+	raw, err := node.ToBytes()
+	if err != nil {
+		return 0, err
+	}
+	compressed, err := c.Compress(raw)
+	if err != nil {
+		return 0, err
+	}
+	return len(compressed), nil
+}
@@ -0,0 +1,337 @@
+package myipld
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrDecrypt = errors.New("failed to decrypt block")
+
+type EncryptionMode byte
+
+const (
+	// RandomNonceEncryption seals every block with the shared key and a
+	// fresh nonce, identical content never produces identical blocks
+	RandomNonceEncryption EncryptionMode = iota
+
+	// ConvergentEncryption derives the block key from the plaintext hash
+	// so identical content seals to identical blocks and still dedups
+	ConvergentEncryption
+)
+
+func (m EncryptionMode) String() string {
+	switch m {
+	case RandomNonceEncryption:
+		return "random-nonce"
+	case ConvergentEncryption:
+		return "convergent"
+	default:
+		return "unknown-encryption"
+	}
+}
+
+const (
+	encryptionVersion = 1
+	encryptionKeySize = 32
+	gcmNonceSize      = 12
+	gcmTagSize        = 16
+)
+
+/* {comment}
+
+Encryptor wraps block payloads in AES-256-GCM before they get hashed,
+so the CID of an encrypted block is the hash of the ciphertext and
+untrusted peers can still verify what they store and forward
+
+block layout
+	random-nonce : version | mode | nonce | gcm(key, payload)
+	convergent   : version | mode | wrap nonce | gcm(key, block key) | gcm(block key, payload)
+
+in convergent mode the block key is sha256 over the plaintext hash, the
+per block key is wrapped with the shared key under a nonce derived from
+it, so the whole block is deterministic for one shared key. peers that
+do not hold the shared key cannot unwrap the block key
+
+{/comment} */
+
+type Encryptor struct {
+	mode EncryptionMode
+	key  []byte
+	aead cipher.AEAD
+}
+
+func NewEncryptor(key []byte, mode EncryptionMode) (*Encryptor, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", encryptionKeySize, len(key))
+	}
+	if mode != RandomNonceEncryption && mode != ConvergentEncryption {
+		return nil, fmt.Errorf("unknown encryption mode %d", mode)
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encryptor{
+		mode: mode,
+		key:  append([]byte(nil), key...),
+		aead: aead,
+	}, nil
+}
+
+func NewEncryptionKey() ([]byte, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key : %w", err)
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aes cipher : %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm : %w", err)
+	}
+	return aead, nil
+}
+
+func (e *Encryptor) Mode() EncryptionMode {
+	return e.mode
+}
+
+func (e *Encryptor) Seal(plain []byte) ([]byte, error) {
+	header := []byte{encryptionVersion, byte(e.mode)}
+
+	if e.mode == RandomNonceEncryption {
+		nonce := make([]byte, gcmNonceSize)
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce : %w", err)
+		}
+		out := append(header, nonce...)
+		return e.aead.Seal(out, nonce, plain, header), nil
+	}
+
+	plainHash := sha256.Sum256(plain)
+	blockKey := sha256.Sum256(plainHash[:])
+
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write(blockKey[:])
+	wrapNonce := mac.Sum(nil)[:gcmNonceSize]
+
+	out := append(header, wrapNonce...)
+	out = e.aead.Seal(out, wrapNonce, blockKey[:], header)
+
+	blockAEAD, err := newGCM(blockKey[:])
+	if err != nil {
+		return nil, err
+	}
+	// every block key seals exactly one plaintext, a zero nonce is fine
+	zeroNonce := make([]byte, gcmNonceSize)
+	return blockAEAD.Seal(out, zeroNonce, plain, header), nil
+}
+
+func (e *Encryptor) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < 2 {
+		return nil, fmt.Errorf("%w: block too short", ErrDecrypt)
+	}
+	header, body := sealed[:2], sealed[2:]
+	if header[0] != encryptionVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrDecrypt, header[0])
+	}
+
+	switch EncryptionMode(header[1]) {
+	case RandomNonceEncryption:
+		if len(body) < gcmNonceSize+gcmTagSize {
+			return nil, fmt.Errorf("%w: block too short", ErrDecrypt)
+		}
+		plain, err := e.aead.Open(nil, body[:gcmNonceSize], body[gcmNonceSize:], header)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+		}
+		return plain, nil
+
+	case ConvergentEncryption:
+		wrappedEnd := gcmNonceSize + encryptionKeySize + gcmTagSize
+		if len(body) < wrappedEnd+gcmTagSize {
+			return nil, fmt.Errorf("%w: block too short", ErrDecrypt)
+		}
+		blockKey, err := e.aead.Open(nil, body[:gcmNonceSize], body[gcmNonceSize:wrappedEnd], header)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+		}
+		blockAEAD, err := newGCM(blockKey)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := blockAEAD.Open(nil, make([]byte, gcmNonceSize), body[wrappedEnd:], header)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+		}
+		return plain, nil
+
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %d", ErrDecrypt, header[1])
+	}
+}
+
+// SealNode encrypts the serialized node and returns the CID of the
+// ciphertext along with the block
+func (e *Encryptor) SealNode(node *MyNode) (MyCID, []byte, error) {
+	plain, err := node.ToBytes()
+	if err != nil {
+		return MyCID{}, nil, err
+	}
+
+	sealed, err := e.Seal(plain)
+	if err != nil {
+		return MyCID{}, nil, err
+	}
+
+	cid, err := ComputeSHA256(sealed)
+	if err != nil {
+		return MyCID{}, nil, err
+	}
+	return cid, sealed, nil
+}
+
+func (e *Encryptor) OpenNode(cid MyCID, sealed []byte) (*MyNode, error) {
+	got, err := ComputeSHA256(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if got != cid {
+		return nil, fmt.Errorf("encrypted block %s hashes to %s", cid, got)
+	}
+
+	plain, err := e.Open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(plain)
+}
+
+// EncryptDAG seals every node reachable from root into bs. Links are
+// rewritten to point at the ciphertext CIDs of the children, so the
+// encrypted DAG can be walked from the returned root by key holders. a
+// signature is sealed along as it is, it covers the plaintext links
+func EncryptDAG(root *MyNode, allNodes []*MyNode, enc *Encryptor, bs Blockstore) (MyCID, error) {
+	if root == nil {
+		return MyCID{}, fmt.Errorf("root node is nil")
+	}
+
+	nodeMap := make(map[MyCID]*MyNode, len(allNodes))
+	for _, node := range allNodes {
+		nodeMap[node.Cid] = node
+	}
+	nodeMap[root.Cid] = root
+
+	sealedCIDs := make(map[MyCID]MyCID, len(allNodes))
+
+	var seal func(node *MyNode) (MyCID, error)
+	seal = func(node *MyNode) (MyCID, error) {
+		if cid, ok := sealedCIDs[node.Cid]; ok {
+			return cid, nil
+		}
+
+		relinked := &MyNode{Data: node.Data, Signature: node.Signature}
+		for _, link := range node.Links {
+			child, ok := nodeMap[link.Cid]
+			if !ok {
+				return MyCID{}, fmt.Errorf("node %s links to %s which is not in the DAG", node.Cid, link.Cid)
+			}
+			childCID, err := seal(child)
+			if err != nil {
+				return MyCID{}, err
+			}
+			relinked.Links = append(relinked.Links, MyLink{Name: link.Name, Cid: childCID})
+		}
+		if err := relinked.recomputeCID(); err != nil {
+			return MyCID{}, err
+		}
+
+		cid, sealed, err := enc.SealNode(relinked)
+		if err != nil {
+			return MyCID{}, fmt.Errorf("failed to seal node %s : %w", node.Cid, err)
+		}
+		if err := bs.Put(cid, sealed); err != nil {
+			return MyCID{}, err
+		}
+
+		sealedCIDs[node.Cid] = cid
+		return cid, nil
+	}
+
+	return seal(root)
+}
+
+// DecryptDAG walks an encrypted DAG from its root and returns the
+// decrypted nodes, root first. links point back at the plaintext
+// children, so the nodes come back with the CIDs and signatures they
+// were encrypted with
+func DecryptDAG(root MyCID, bs BlockGetter, enc *Encryptor) (*MyNode, []*MyNode, error) {
+	var nodes []*MyNode
+	opened := make(map[MyCID]*MyNode)
+	visited := map[MyCID]bool{root: true}
+	queue := []MyCID{root}
+
+	for len(queue) > 0 {
+		cid := queue[0]
+		queue = queue[1:]
+
+		sealed, err := bs.Get(cid)
+		if err != nil {
+			return nil, nil, err
+		}
+		node, err := enc.OpenNode(cid, sealed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open block %s : %w", cid, err)
+		}
+		nodes = append(nodes, node)
+		opened[cid] = node
+
+		for _, link := range node.Links {
+			if !visited[link.Cid] {
+				visited[link.Cid] = true
+				queue = append(queue, link.Cid)
+			}
+		}
+	}
+
+	// children first, a parent's links need their plaintext CIDs
+	restored := make(map[MyCID]bool, len(opened))
+	var restore func(cid MyCID) (MyCID, error)
+	restore = func(cid MyCID) (MyCID, error) {
+		node := opened[cid]
+		if restored[cid] {
+			return node.Cid, nil
+		}
+		for i, link := range node.Links {
+			child, err := restore(link.Cid)
+			if err != nil {
+				return MyCID{}, err
+			}
+			node.Links[i].Cid = child
+		}
+		if err := node.recomputeCID(); err != nil {
+			return MyCID{}, err
+		}
+		restored[cid] = true
+		return node.Cid, nil
+	}
+	if _, err := restore(root); err != nil {
+		return nil, nil, err
+	}
+
+	return nodes[0], nodes, nil
+}
@@ -2,6 +2,7 @@ package myipld
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 )
 
@@ -22,4 +23,22 @@ func (c MyCID) String() string {
 func ComputeSHA256(data []byte)(MyCID, error){
 	hash := sha256.Sum256(data)
 	return MyCID{Hash : hash}, nil
-}
\ No newline at end of file
+}
+
+// Hex is the full hash in hex, String is only meant for logs
+func (c MyCID) Hex() string {
+	return hex.EncodeToString(c.Hash[:])
+}
+
+func ParseMyCID(s string) (MyCID, error) {
+	var c MyCID
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cid %q : %w", s, err)
+	}
+	if len(raw) != HashSize {
+		return c, fmt.Errorf("invalid cid %q : expected %d bytes, got %d", s, HashSize, len(raw))
+	}
+	copy(c.Hash[:], raw)
+	return c, nil
+}
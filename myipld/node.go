@@ -19,10 +19,11 @@ it holds arbitrary data and a list of links to other nodes
 {/comment}*/
 
 type MyNode struct {
-	Data    json.RawMessage
-	Links   []MyLink
-	Cid     MyCID
-	rawData []byte
+	Data      json.RawMessage
+	Links     []MyLink
+	Cid       MyCID
+	Signature *Signature
+	rawData   []byte
 }
 
 // func NewMyNode(data interface{}) (*MyNode, error) {
@@ -73,8 +74,28 @@ func NewMyNode(data interface{}) (*MyNode, error) {
 	return node, nil
 }
 
+// NewMyNodeWithLinks builds a node with all its links at once, AddLink
+// hashes the whole node again on every call which adds up for wide nodes
+func NewMyNodeWithLinks(data interface{}, links []MyLink) (*MyNode, error) {
+	node, err := NewMyNode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return node, nil
+	}
+
+	node.Links = append([]MyLink(nil), links...)
+	if err := node.recomputeCID(); err != nil {
+		return nil, fmt.Errorf("failed to compute CID for new node: %w", err)
+	}
+	return node, nil
+}
+
 func (n *MyNode) AddLink(name string, targetCID MyCID) error {
 	n.Links = append(n.Links, MyLink{Name: name, Cid: targetCID})
+	// the old signature covered the old content, it has to be signed again
+	n.Signature = nil
 	err := n.recomputeCID()
 
 	if err != nil {
@@ -85,11 +106,13 @@ func (n *MyNode) AddLink(name string, targetCID MyCID) error {
 
 func (n *MyNode) recomputeCID() error {
 	serializableNode := struct {
-		Data  json.RawMessage `json:"data"`
-		Links []MyLink        `json:"links"`
+		Data      json.RawMessage `json:"data"`
+		Links     []MyLink        `json:"links"`
+		Signature *Signature      `json:"sig,omitempty"`
 	}{
-		Data:  n.Data,
-		Links: n.Links,
+		Data:      n.Data,
+		Links:     n.Links,
+		Signature: n.Signature,
 	}
 
 	rawBytes, err := json.Marshal(serializableNode)
@@ -127,8 +150,9 @@ func (n *MyNode) ToBytes() ([]byte, error) {
 
 func FromBytes(data []byte) (*MyNode, error) {
 	var serializableNode struct {
-		Data  json.RawMessage `json:"data"`
-		Links []MyLink        `json:"links"`
+		Data      json.RawMessage `json:"data"`
+		Links     []MyLink        `json:"links"`
+		Signature *Signature      `json:"sig,omitempty"`
 	}
 
 	if err := json.Unmarshal(data, &serializableNode); err != nil {
@@ -136,8 +160,9 @@ func FromBytes(data []byte) (*MyNode, error) {
 	}
 
 	node := &MyNode{
-		Data:  serializableNode.Data,
-		Links: serializableNode.Links,
+		Data:      serializableNode.Data,
+		Links:     serializableNode.Links,
+		Signature: serializableNode.Signature,
 	}
 
 	if err := node.recomputeCID(); err != nil {
@@ -0,0 +1,169 @@
+package myipld
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrNotSigned        = errors.New("node is not signed")
+	ErrInvalidSignature = errors.New("invalid node signature")
+	ErrUntrustedKey     = errors.New("node signed by untrusted key")
+)
+
+/* {comment}
+
+Signature is the optional envelope attributing a node to a publisher.
+It signs the content CID, i.e. the hash of the node serialized without
+the envelope, so the node's own Cid still covers data, links and signature
+
+{/comment} */
+
+type Signature struct {
+	KeyID     string            `json:"kid"`
+	PublicKey ed25519.PublicKey `json:"pub"`
+	Sig       []byte            `json:"sig"`
+}
+
+// KeyID is a short identifier for a publisher key, the first 8 bytes
+// of sha256(pub) in hex
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ContentCID is the CID of the node without its signature envelope,
+// which is what gets signed
+func (n *MyNode) ContentCID() (MyCID, error) {
+	unsigned := struct {
+		Data  json.RawMessage `json:"data"`
+		Links []MyLink        `json:"links"`
+	}{
+		Data:  n.Data,
+		Links: n.Links,
+	}
+
+	rawBytes, err := json.Marshal(unsigned)
+	if err != nil {
+		return MyCID{}, fmt.Errorf("failed to marshal node for content CID : %w", err)
+	}
+
+	return ComputeSHA256(rawBytes)
+}
+
+func (n *MyNode) Sign(priv ed25519.PrivateKey) error {
+	if len(priv) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid ed25519 private key size %d", len(priv))
+	}
+
+	contentCID, err := n.ContentCID()
+	if err != nil {
+		return err
+	}
+
+	pub := priv.Public().(ed25519.PublicKey)
+	n.Signature = &Signature{
+		KeyID:     KeyID(pub),
+		PublicKey: pub,
+		Sig:       ed25519.Sign(priv, contentCID.Hash[:]),
+	}
+
+	if err := n.recomputeCID(); err != nil {
+		return fmt.Errorf("failed to recompute CID after signing : %w", err)
+	}
+	return nil
+}
+
+// Verify checks the signature against the public key carried in the
+// node itself, it says nothing about whether that key is trusted
+func (n *MyNode) Verify() error {
+	if n.Signature == nil {
+		return ErrNotSigned
+	}
+
+	sig := n.Signature
+	if len(sig.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: bad public key size %d", ErrInvalidSignature, len(sig.PublicKey))
+	}
+	if sig.KeyID != KeyID(sig.PublicKey) {
+		return fmt.Errorf("%w: key id %s does not match public key", ErrInvalidSignature, sig.KeyID)
+	}
+
+	contentCID, err := n.ContentCID()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(sig.PublicKey, contentCID.Hash[:], sig.Sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// KeyRing is the set of publisher keys a decoder is willing to accept
+type KeyRing struct {
+	mu   sync.RWMutex
+	keys map[string]ed25519.PublicKey
+}
+
+func NewKeyRing(keys ...ed25519.PublicKey) *KeyRing {
+	ring := &KeyRing{keys: make(map[string]ed25519.PublicKey, len(keys))}
+	for _, k := range keys {
+		ring.Add(k)
+	}
+	return ring
+}
+
+func (r *KeyRing) Add(pub ed25519.PublicKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[KeyID(pub)] = pub
+}
+
+func (r *KeyRing) Remove(keyID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, keyID)
+}
+
+func (r *KeyRing) Lookup(keyID string) (ed25519.PublicKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	pub, ok := r.keys[keyID]
+	return pub, ok
+}
+
+// VerifyTrusted checks that the node carries a valid signature made by
+// one of the keys in the ring
+func (r *KeyRing) VerifyTrusted(n *MyNode) error {
+	if err := n.Verify(); err != nil {
+		return err
+	}
+
+	trusted, ok := r.Lookup(n.Signature.KeyID)
+	if !ok || !trusted.Equal(n.Signature.PublicKey) {
+		return fmt.Errorf("%w: %s", ErrUntrustedKey, n.Signature.KeyID)
+	}
+	return nil
+}
+
+// FromBytesVerified decodes a node and rejects it unless it was signed
+// by a key in the ring
+func FromBytesVerified(data []byte, ring *KeyRing) (*MyNode, error) {
+	node, err := FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if ring == nil {
+		return nil, fmt.Errorf("%w: no trusted keys configured", ErrUntrustedKey)
+	}
+	if err := ring.VerifyTrusted(node); err != nil {
+		return nil, fmt.Errorf("failed to verify node %s : %w", node.Cid, err)
+	}
+	return node, nil
+}
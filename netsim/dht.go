@@ -0,0 +1,67 @@
+package netsim
+
+import (
+	"fmt"
+
+	"ipld-benchmark/dht"
+	"ipld-benchmark/exchange"
+)
+
+// DHTNetwork runs dht nodes on a Sim, like ExchangeNetwork does for the
+// block exchange
+type DHTNetwork struct {
+	sim      *Sim
+	handlers map[exchange.PeerID]dht.Handler
+}
+
+func NewDHTNetwork(sim *Sim) *DHTNetwork {
+	return &DHTNetwork{
+		sim:      sim,
+		handlers: make(map[exchange.PeerID]dht.Handler),
+	}
+}
+
+func (n *DHTNetwork) Attach(id exchange.PeerID, h dht.Handler) (dht.Transport, error) {
+	if _, exists := n.handlers[id]; exists {
+		return nil, fmt.Errorf("peer %s already attached", id)
+	}
+	n.handlers[id] = h
+	return &dhtTransport{id: id, net: n}, nil
+}
+
+func (n *DHTNetwork) Clock() exchange.Clock {
+	return simClock{n.sim}
+}
+
+type dhtTransport struct {
+	id     exchange.PeerID
+	net    *DHTNetwork
+	closed bool
+}
+
+func (t *dhtTransport) LocalPeer() exchange.PeerID {
+	return t.id
+}
+
+func (t *dhtTransport) Send(to exchange.PeerID, msg dht.Message) error {
+	if t.closed {
+		return exchange.ErrTransportClosed
+	}
+	if _, ok := t.net.handlers[to]; !ok {
+		return fmt.Errorf("%w: %s", exchange.ErrUnknownPeer, to)
+	}
+
+	from := t.id
+	t.net.sim.Send(string(from), string(to), msg.Size(), func() {
+		if h, ok := t.net.handlers[to]; ok {
+			h.HandleMessage(from, msg)
+		}
+	})
+	return nil
+}
+
+func (t *dhtTransport) Close() error {
+	t.closed = true
+	delete(t.net.handlers, t.id)
+	return nil
+}
@@ -0,0 +1,80 @@
+package netsim
+
+import (
+	"fmt"
+	"time"
+
+	"ipld-benchmark/exchange"
+)
+
+// ExchangeNetwork runs the block exchange protocols on a Sim, every Send
+// goes through the link model and handlers run inside Sim.Run
+type ExchangeNetwork struct {
+	sim      *Sim
+	handlers map[exchange.PeerID]exchange.Handler
+}
+
+func NewExchangeNetwork(sim *Sim) *ExchangeNetwork {
+	return &ExchangeNetwork{
+		sim:      sim,
+		handlers: make(map[exchange.PeerID]exchange.Handler),
+	}
+}
+
+func (n *ExchangeNetwork) Attach(id exchange.PeerID, h exchange.Handler) (exchange.Transport, error) {
+	if _, exists := n.handlers[id]; exists {
+		return nil, fmt.Errorf("peer %s already attached", id)
+	}
+	n.handlers[id] = h
+	return &simTransport{id: id, net: n}, nil
+}
+
+func (n *ExchangeNetwork) Clock() exchange.Clock {
+	return simClock{n.sim}
+}
+
+type simClock struct {
+	sim *Sim
+}
+
+func (c simClock) Now() time.Time {
+	return c.sim.Now()
+}
+
+func (c simClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return c.sim.AfterFunc(d, f)
+}
+
+type simTransport struct {
+	id     exchange.PeerID
+	net    *ExchangeNetwork
+	closed bool
+}
+
+func (t *simTransport) LocalPeer() exchange.PeerID {
+	return t.id
+}
+
+func (t *simTransport) Send(to exchange.PeerID, msg exchange.Message) error {
+	if t.closed {
+		return exchange.ErrTransportClosed
+	}
+	if _, ok := t.net.handlers[to]; !ok {
+		return fmt.Errorf("%w: %s", exchange.ErrUnknownPeer, to)
+	}
+
+	from := t.id
+	t.net.sim.Send(string(from), string(to), msg.Size(), func() {
+		// the receiver may have left while the message was in flight
+		if h, ok := t.net.handlers[to]; ok {
+			h.HandleMessage(from, msg)
+		}
+	})
+	return nil
+}
+
+func (t *simTransport) Close() error {
+	t.closed = true
+	delete(t.net.handlers, t.id)
+	return nil
+}
@@ -0,0 +1,54 @@
+package netsim
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// LatencyDist is the one-way delay of a link, sampled per message from
+// the simulation's seeded rand
+type LatencyDist interface {
+	Sample(r *rand.Rand) time.Duration
+}
+
+type Constant time.Duration
+
+func (c Constant) Sample(*rand.Rand) time.Duration {
+	return time.Duration(c)
+}
+
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+func (u Uniform) Sample(r *rand.Rand) time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + time.Duration(r.Int63n(int64(u.Max-u.Min)))
+}
+
+// Normal is cut off at zero, a link cannot deliver before it sends
+type Normal struct {
+	Mean, StdDev time.Duration
+}
+
+func (n Normal) Sample(r *rand.Rand) time.Duration {
+	d := time.Duration(r.NormFloat64()*float64(n.StdDev)) + n.Mean
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// LogNormal has the long tail real internet latencies tend to have,
+// Median is exp(mu) and Sigma the spread of the underlying normal
+type LogNormal struct {
+	Median time.Duration
+	Sigma  float64
+}
+
+func (l LogNormal) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(float64(l.Median) * math.Exp(r.NormFloat64()*l.Sigma))
+}
@@ -0,0 +1,330 @@
+package netsim
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+/* {comment}
+
+Sim is a discrete-event network simulator with a virtual clock
+
+nothing runs on real time, every message and timer is an event on a
+heap ordered by virtual time (ties broken by scheduling order) and Run
+executes them one by one on the calling goroutine. all randomness comes
+from the seeded rand, so the same seed and the same inputs give the same
+timings every run
+
+links are directed, a message from a to b waits until the a->b link is
+free (Bandwidth), then arrives after a sampled Latency. messages on one
+link never overtake each other, like on a TCP stream. Loss drops a
+message with the given probability and partitions drop everything
+between peers in different groups
+
+SetNodeBandwidth adds the access link of a peer on top, its uplink is
+shared by everything it sends and its downlink by everything it receives
+whoever the other end is
+
+{/comment} */
+
+type Sim struct {
+	now    time.Time
+	events eventQueue
+	seq    uint64
+	rng    *rand.Rand
+
+	defaultLink LinkConfig
+	links       map[linkKey]*link
+	access      map[string]*accessLink
+	partition   map[string]int
+
+	stats Stats
+}
+
+type Stats struct {
+	Events            int
+	MessagesSent      int
+	MessagesDelivered int
+	MessagesDropped   int
+	BytesSent         int64
+}
+
+// LinkConfig describes one direction of a link, the zero value is an
+// instant, unlimited and lossless link
+type LinkConfig struct {
+	Latency LatencyDist
+	// Bandwidth in bytes per second, 0 means unlimited
+	Bandwidth float64
+	// Loss is the probability a message is dropped
+	Loss float64
+}
+
+type linkKey struct {
+	from, to string
+}
+
+type link struct {
+	cfg         LinkConfig
+	busyUntil   time.Time
+	lastArrival time.Time
+}
+
+type accessLink struct {
+	up, down         float64
+	upBusy, downBusy time.Time
+}
+
+// Epoch is where every simulation's virtual clock starts
+var Epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func New(seed int64) *Sim {
+	return &Sim{
+		now:       Epoch,
+		rng:       rand.New(rand.NewSource(seed)),
+		links:     make(map[linkKey]*link),
+		access:    make(map[string]*accessLink),
+		partition: make(map[string]int),
+	}
+}
+
+func (s *Sim) Now() time.Time {
+	return s.now
+}
+
+// Elapsed is the virtual time since the simulation started
+func (s *Sim) Elapsed() time.Duration {
+	return s.now.Sub(Epoch)
+}
+
+// Rand is the simulation's seeded source, use it for anything that has
+// to be reproducible
+func (s *Sim) Rand() *rand.Rand {
+	return s.rng
+}
+
+func (s *Sim) Stats() Stats {
+	return s.stats
+}
+
+// Schedule runs fn after delay of virtual time
+func (s *Sim) Schedule(delay time.Duration, fn func()) {
+	s.AfterFunc(delay, fn)
+}
+
+// AfterFunc is Schedule with a stop function, like time.AfterFunc
+func (s *Sim) AfterFunc(delay time.Duration, fn func()) func() bool {
+	if delay < 0 {
+		delay = 0
+	}
+	return s.scheduleAt(s.now.Add(delay), fn)
+}
+
+func (s *Sim) scheduleAt(at time.Time, fn func()) func() bool {
+	s.seq++
+	ev := &event{at: at, seq: s.seq, fn: fn}
+	heap.Push(&s.events, ev)
+
+	return func() bool {
+		if ev.cancelled || ev.fired {
+			return false
+		}
+		ev.cancelled = true
+		return true
+	}
+}
+
+// Step runs the next event, false when there is nothing left
+func (s *Sim) Step() bool {
+	for s.events.Len() > 0 {
+		ev := heap.Pop(&s.events).(*event)
+		if ev.cancelled {
+			continue
+		}
+		s.now = ev.at
+		ev.fired = true
+		s.stats.Events++
+		ev.fn()
+		return true
+	}
+	return false
+}
+
+// Run executes events until none are left
+func (s *Sim) Run() {
+	for s.Step() {
+	}
+}
+
+// RunUntil executes events until done returns true or the queue is
+// empty, it reports whether done was reached
+func (s *Sim) RunUntil(done func() bool) bool {
+	for !done() {
+		if !s.Step() {
+			return done()
+		}
+	}
+	return true
+}
+
+// RunFor executes events for d of virtual time
+func (s *Sim) RunFor(d time.Duration) {
+	deadline := s.now.Add(d)
+	for {
+		for s.events.Len() > 0 && s.events.peek().cancelled {
+			heap.Pop(&s.events)
+		}
+		if s.events.Len() == 0 || s.events.peek().at.After(deadline) {
+			break
+		}
+		s.Step()
+	}
+	if s.now.Before(deadline) {
+		s.now = deadline
+	}
+}
+
+func (s *Sim) SetDefaultLink(cfg LinkConfig) {
+	s.defaultLink = cfg
+}
+
+// SetLink configures both directions between a and b
+func (s *Sim) SetLink(a, b string, cfg LinkConfig) {
+	s.linkFor(a, b).cfg = cfg
+	s.linkFor(b, a).cfg = cfg
+}
+
+func (s *Sim) linkFor(from, to string) *link {
+	key := linkKey{from: from, to: to}
+	l, ok := s.links[key]
+	if !ok {
+		l = &link{cfg: s.defaultLink}
+		s.links[key] = l
+	}
+	return l
+}
+
+// SetNodeBandwidth caps what id can send and receive in bytes per
+// second over all its links together, 0 leaves a direction unlimited
+func (s *Sim) SetNodeBandwidth(id string, up, down float64) {
+	s.access[id] = &accessLink{up: up, down: down}
+}
+
+// transmit is when size bytes that can start at from are through a pipe
+// of bw bytes per second that is busy until busy
+func transmit(from time.Time, busy *time.Time, size int, bw float64) time.Time {
+	if busy.After(from) {
+		from = *busy
+	}
+	if bw > 0 {
+		from = from.Add(time.Duration(float64(size) / bw * float64(time.Second)))
+	}
+	*busy = from
+	return from
+}
+
+// Partition splits the network, peers in different groups cannot reach
+// each other and peers not listed stay in group 0 with each other
+func (s *Sim) Partition(groups ...[]string) {
+	s.partition = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			s.partition[id] = i + 1
+		}
+	}
+}
+
+func (s *Sim) Heal() {
+	s.partition = make(map[string]int)
+}
+
+func (s *Sim) reachable(from, to string) bool {
+	return s.partition[from] == s.partition[to]
+}
+
+// Send puts size bytes on the from->to link and runs deliver when they
+// arrive, unless the message gets lost
+func (s *Sim) Send(from, to string, size int, deliver func()) {
+	s.stats.MessagesSent++
+	s.stats.BytesSent += int64(size)
+
+	l := s.linkFor(from, to)
+	if !s.reachable(from, to) || (l.cfg.Loss > 0 && s.rng.Float64() < l.cfg.Loss) {
+		s.stats.MessagesDropped++
+		return
+	}
+
+	depart := s.now
+	if up, ok := s.access[from]; ok {
+		depart = transmit(depart, &up.upBusy, size, up.up)
+	}
+	depart = transmit(depart, &l.busyUntil, size, l.cfg.Bandwidth)
+
+	arrive := depart
+	if l.cfg.Latency != nil {
+		arrive = arrive.Add(l.cfg.Latency.Sample(s.rng))
+	}
+	if arrive.Before(l.lastArrival) {
+		arrive = l.lastArrival
+	}
+	l.lastArrival = arrive
+
+	s.scheduleAt(arrive, func() {
+		// a partition that went up while the message was in flight
+		// still cuts it off
+		if !s.reachable(from, to) {
+			s.stats.MessagesDropped++
+			return
+		}
+		// the receiver's downlink is taken in arrival order
+		if down, ok := s.access[to]; ok && down.down > 0 {
+			s.scheduleAt(transmit(s.now, &down.downBusy, size, down.down), func() {
+				s.stats.MessagesDelivered++
+				deliver()
+			})
+			return
+		}
+		s.stats.MessagesDelivered++
+		deliver()
+	})
+}
+
+func (s *Sim) String() string {
+	return fmt.Sprintf("sim at +%s, %d events pending", s.Elapsed(), s.events.Len())
+}
+
+type event struct {
+	at        time.Time
+	seq       uint64
+	fn        func()
+	cancelled bool
+	fired     bool
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x any) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	ev := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return ev
+}
+
+func (q eventQueue) peek() *event {
+	return q[0]
+}
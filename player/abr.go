@@ -0,0 +1,42 @@
+package player
+
+import (
+	"time"
+
+	"ipld-benchmark/media"
+)
+
+// ABR picks the rendition index of the next segment, renditions are
+// ordered by bandwidth and throughput is in bits per second (0 before
+// anything was measured)
+type ABR interface {
+	Choose(renditions []media.Rendition, throughput float64, buffer time.Duration, current int) int
+}
+
+// FixedABR always plays the same rendition
+type FixedABR int
+
+func (f FixedABR) Choose([]media.Rendition, float64, time.Duration, int) int {
+	return int(f)
+}
+
+// ThroughputABR plays the best rendition that fits Safety times the
+// measured throughput, it starts on the lowest one
+type ThroughputABR struct {
+	Safety float64
+}
+
+func (a ThroughputABR) Choose(renditions []media.Rendition, throughput float64, _ time.Duration, _ int) int {
+	safety := a.Safety
+	if safety <= 0 {
+		safety = 0.8
+	}
+
+	choice := 0
+	for i, r := range renditions {
+		if float64(r.Bandwidth) <= throughput*safety {
+			choice = i
+		}
+	}
+	return choice
+}
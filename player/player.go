@@ -0,0 +1,248 @@
+package player
+
+import (
+	"time"
+
+	"ipld-benchmark/media"
+)
+
+type Config struct {
+	// StartupBuffer is how much media has to be buffered before the
+	// first frame, ResumeBuffer the same after a stall (defaults to
+	// StartupBuffer)
+	StartupBuffer time.Duration
+	ResumeBuffer  time.Duration
+	// MaxBuffer stops fetching while that much media is buffered
+	MaxBuffer time.Duration
+	// ABR picks the rendition of every segment, ThroughputABR by default
+	ABR ABR
+}
+
+func DefaultConfig() Config {
+	return Config{
+		StartupBuffer: 2 * time.Second,
+		MaxBuffer:     30 * time.Second,
+		ABR:           ThroughputABR{Safety: 0.8},
+	}
+}
+
+type State int
+
+const (
+	Starting State = iota
+	Playing
+	Stalled
+	Ended
+)
+
+func (s State) String() string {
+	switch s {
+	case Starting:
+		return "starting"
+	case Playing:
+		return "playing"
+	case Stalled:
+		return "stalled"
+	default:
+		return "ended"
+	}
+}
+
+type Stats struct {
+	TimeToFirstFrame time.Duration
+	Rebuffers        int
+	StallTime        time.Duration
+	BitrateSwitches  int
+	Segments         int
+	Bytes            int64
+	// AverageBitrate is weighted by segment duration, in bits per second
+	AverageBitrate float64
+	// SessionTime runs from the start to the last frame
+	SessionTime time.Duration
+}
+
+/* {comment}
+
+Player models a viewer, a fetcher hands it segments as they arrive and
+it plays them back at 1x. nothing is scheduled, the playhead is moved
+forward lazily whenever the player is told the time, which is enough to
+place every stall exactly since the buffer only grows on arrivals
+
+{/comment} */
+
+type Player struct {
+	cfg        Config
+	renditions []media.Rendition
+	total      time.Duration
+
+	state      State
+	started    time.Time
+	lastUpdate time.Time
+	playhead   time.Duration
+	buffered   time.Duration
+	stallStart time.Time
+
+	current    int
+	loaded     bool
+	throughput float64
+	bitrateSum float64
+	stats      Stats
+}
+
+// New starts a player at start for a title of length total, renditions
+// are ordered by bandwidth like media.Title keeps them
+func New(cfg Config, renditions []media.Rendition, total time.Duration, start time.Time) *Player {
+	def := DefaultConfig()
+	if cfg.StartupBuffer <= 0 {
+		cfg.StartupBuffer = def.StartupBuffer
+	}
+	if cfg.ResumeBuffer <= 0 {
+		cfg.ResumeBuffer = cfg.StartupBuffer
+	}
+	if cfg.MaxBuffer <= 0 {
+		cfg.MaxBuffer = def.MaxBuffer
+	}
+	if cfg.ABR == nil {
+		cfg.ABR = def.ABR
+	}
+
+	return &Player{
+		cfg:        cfg,
+		renditions: renditions,
+		total:      total,
+		started:    start,
+		lastUpdate: start,
+	}
+}
+
+func (p *Player) State() State {
+	return p.state
+}
+
+// Throughput is the current estimate in bits per second, 0 before the
+// first segment
+func (p *Player) Throughput() float64 {
+	return p.throughput
+}
+
+// BufferAhead is how much media is buffered past the playhead at now
+func (p *Player) BufferAhead(now time.Time) time.Duration {
+	p.advance(now)
+	return p.buffered - p.playhead
+}
+
+// Next picks the rendition of the next segment
+func (p *Player) Next(now time.Time) int {
+	choice := p.cfg.ABR.Choose(p.renditions, p.throughput, p.BufferAhead(now), p.current)
+	if choice < 0 || choice >= len(p.renditions) {
+		choice = 0
+	}
+	return choice
+}
+
+// Deadline is when seg has to be buffered to play without a stall, as
+// things stand at now
+func (p *Player) Deadline(now time.Time, seg media.Segment) time.Time {
+	p.advance(now)
+	return now.Add(seg.Start - p.playhead)
+}
+
+// Wait is how long the fetcher should hold off before fetching a
+// segment of next duration, so the buffer stays under MaxBuffer
+func (p *Player) Wait(now time.Time, next time.Duration) time.Duration {
+	over := p.BufferAhead(now) + next - p.cfg.MaxBuffer
+	if over <= 0 || p.state != Playing {
+		return 0
+	}
+	return over
+}
+
+// Loaded tells the player seg of rendition arrived at now, size bytes
+// that took fetchTime
+func (p *Player) Loaded(now time.Time, seg media.Segment, rendition int, size int64, fetchTime time.Duration) {
+	p.advance(now)
+
+	if p.loaded && rendition != p.current {
+		p.stats.BitrateSwitches++
+	}
+	p.loaded = true
+	p.current = rendition
+	p.stats.Segments++
+	p.stats.Bytes += size
+	p.bitrateSum += float64(p.renditions[rendition].Bandwidth) * seg.Duration.Seconds()
+
+	if fetchTime > 0 {
+		sample := float64(size) * 8 / fetchTime.Seconds()
+		if p.throughput == 0 {
+			p.throughput = sample
+		} else {
+			p.throughput = 0.5*p.throughput + 0.5*sample
+		}
+	}
+
+	p.buffered += seg.Duration
+	ahead := p.buffered - p.playhead
+	complete := p.buffered >= p.total
+
+	switch p.state {
+	case Starting:
+		if ahead >= p.cfg.StartupBuffer || complete {
+			p.state = Playing
+			p.stats.TimeToFirstFrame = now.Sub(p.started)
+		}
+	case Stalled:
+		if ahead >= p.cfg.ResumeBuffer || complete {
+			p.state = Playing
+			p.stats.StallTime += now.Sub(p.stallStart)
+		}
+	}
+}
+
+// Finish is called once every segment is loaded, it plays out the rest
+// of the buffer and returns the final stats
+func (p *Player) Finish(now time.Time) Stats {
+	p.advance(now)
+	if p.state == Starting || p.state == Stalled {
+		// Loaded already started playback once everything was in
+		p.state = Playing
+	}
+	if p.state == Playing {
+		end := now.Add(p.buffered - p.playhead)
+		p.playhead = p.buffered
+		p.state = Ended
+		p.stats.SessionTime = end.Sub(p.started)
+	}
+
+	stats := p.stats
+	if p.buffered > 0 {
+		stats.AverageBitrate = p.bitrateSum / p.buffered.Seconds()
+	}
+	return stats
+}
+
+func (p *Player) advance(now time.Time) {
+	if !now.After(p.lastUpdate) {
+		return
+	}
+	elapsed := now.Sub(p.lastUpdate)
+	p.lastUpdate = now
+
+	if p.state != Playing {
+		return
+	}
+	ahead := p.buffered - p.playhead
+	if elapsed < ahead {
+		p.playhead += elapsed
+		return
+	}
+
+	p.playhead = p.buffered
+	if p.buffered >= p.total {
+		p.state = Ended
+		p.stats.SessionTime = now.Add(ahead - elapsed).Sub(p.started)
+		return
+	}
+	p.state = Stalled
+	p.stallStart = now.Add(ahead - elapsed)
+	p.stats.Rebuffers++
+}
@@ -0,0 +1,96 @@
+package report
+
+import (
+	"time"
+
+	"ipld-benchmark/bench"
+)
+
+// Comparison is one operation of one scenario in two runs
+type Comparison struct {
+	Scenario  string
+	Operation string
+	BeforeRun string
+	AfterRun  string
+	// BeforeMedian and AfterMedian are the median time per run
+	BeforeMedian time.Duration
+	AfterMedian  time.Duration
+	// Delta is the change of the median in percent, positive is slower
+	Delta float64
+	// AllocsDelta is the change of the mean allocations in percent
+	AllocsDelta float64
+	U           float64
+	P           float64
+	// Significant is P below the significance level, Regression a
+	// significant slowdown past the threshold
+	Significant bool
+	Regression  bool
+	// SameEnvironment is false when the Go version or the CPU changed,
+	// the delta is then partly the machine's
+	SameEnvironment bool
+}
+
+type CompareOptions struct {
+	// Alpha is the significance level, 0.05 when zero
+	Alpha float64
+	// Threshold is how many percent slower a significant change has to
+	// be to count as a Regression
+	Threshold float64
+}
+
+/* {comment}
+
+Compare matches the scenarios two runs have in common and tests every
+operation's raw sample times with MannWhitney. a change is significant
+when P < Alpha, the median alone moves with noise. scenarios only one
+of the runs has are left out
+
+{/comment} */
+
+func Compare(before, after *Run, opts CompareOptions) []Comparison {
+	if opts.Alpha == 0 {
+		opts.Alpha = 0.05
+	}
+	old := make(map[string]*Record)
+	for _, rec := range before.Records {
+		old[rec.Scenario] = rec
+	}
+
+	var out []Comparison
+	for _, rec := range after.Records {
+		prev, ok := old[rec.Scenario]
+		if !ok {
+			continue
+		}
+		same := prev.Environment.GoVersion == rec.Environment.GoVersion && prev.Environment.CPU == rec.Environment.CPU
+		prevOps := prev.Stats.Named()
+		for i, op := range rec.Stats.Named() {
+			a, b := prevOps[i].Stats, op.Stats
+			u, p := bench.MannWhitney(a.Times, b.Times)
+			c := Comparison{
+				Scenario:        rec.Scenario,
+				Operation:       op.Name,
+				BeforeRun:       before.ID,
+				AfterRun:        after.ID,
+				BeforeMedian:    a.Time.Median,
+				AfterMedian:     b.Time.Median,
+				Delta:           percentChange(float64(a.Time.Median), float64(b.Time.Median)),
+				AllocsDelta:     percentChange(a.Allocs.Mean, b.Allocs.Mean),
+				U:               u,
+				P:               p,
+				Significant:     p < opts.Alpha,
+				SameEnvironment: same,
+			}
+			c.Regression = c.Significant && c.Delta > opts.Threshold
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func percentChange(from, to float64) float64 {
+	if from == 0 {
+		return 0
+	}
+	return (to - from) / from * 100
+}
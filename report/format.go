@@ -0,0 +1,310 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/olekukonko/tablewriter"
+	"github.com/olekukonko/tablewriter/tw"
+)
+
+// Formats Write knows, text and table are for people, the rest for
+// programs
+var Formats = []string{"text", "json", "jsonl", "csv", "table"}
+
+var ErrUnknownFormat = errors.New("unknown output format")
+
+type Options struct {
+	// Color highlights field names and headers of text and table
+	// output, the formats for programs never get any
+	Color bool
+}
+
+/* {comment}
+
+Write prints values, result structs of the same type, in format:
+
+	text   aligned "name  value" lines, a blank line between values
+	json   indented, an object for one value and an array for more
+	jsonl  one compact object per line
+	csv    a header of field names and a row per value
+	table  a row per field and a column per value
+
+text, csv and table flatten the structs: embedded ones disappear into
+their parent, nested ones prefix their fields with "Name." and slices
+are listed element by element, fields tagged `report:"-"` are left to
+the JSON. text and table print durations and
+floats to be read, csv keeps them exact (durations in nanoseconds like
+the JSON) so a spreadsheet can do the maths
+
+{/comment} */
+
+func Write(w io.Writer, format string, opts Options, values ...interface{}) error {
+	switch format {
+	case "text":
+		return writeText(w, opts, values)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if len(values) == 1 {
+			return enc.Encode(values[0])
+		}
+		return enc.Encode(values)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return writeCSV(w, values)
+	case "table":
+		return writeTable(w, opts, values)
+	}
+	return fmt.Errorf("%w %q", ErrUnknownFormat, format)
+}
+
+// Terminal says whether w is a terminal that wants color, NO_COLOR and
+// TERM=dumb turn it off
+func Terminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// palette is a no-op without Options.Color, fatih/color would otherwise
+// decide on its own from os.Stdout
+type palette struct {
+	name   func(a ...interface{}) string
+	header func(a ...interface{}) string
+}
+
+func newPalette(opts Options) palette {
+	paint := func(attrs ...color.Attribute) func(a ...interface{}) string {
+		c := color.New(attrs...)
+		if opts.Color {
+			c.EnableColor()
+		} else {
+			c.DisableColor()
+		}
+		return c.SprintFunc()
+	}
+	return palette{name: paint(color.FgCyan), header: paint(color.Bold)}
+}
+
+func writeText(w io.Writer, opts Options, values []interface{}) error {
+	p := newPalette(opts)
+	out := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, v := range values {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		for _, f := range fields(v) {
+			if f.list {
+				fmt.Fprintf(out, "%s\t%d\n", p.name(f.name), len(f.elems))
+				for _, el := range f.elems {
+					fmt.Fprintf(out, "  %s\n", textValue(el))
+				}
+				continue
+			}
+			fmt.Fprintf(out, "%s\t%s\n", p.name(f.name), textValue(f.value))
+		}
+	}
+	return out.Flush()
+}
+
+func writeCSV(w io.Writer, values []interface{}) error {
+	cw := csv.NewWriter(w)
+	for i, v := range values {
+		fs := fields(v)
+		if i == 0 {
+			header := make([]string, len(fs))
+			for j, f := range fs {
+				header[j] = f.name
+			}
+			cw.Write(header)
+		}
+		row := make([]string, len(fs))
+		for j, f := range fs {
+			if !f.list {
+				row[j] = csvValue(f.value)
+				continue
+			}
+			elems := make([]string, len(f.elems))
+			for k, el := range f.elems {
+				elems[k] = csvValue(el)
+			}
+			row[j] = strings.Join(elems, ";")
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeTable(w io.Writer, opts Options, values []interface{}) error {
+	p := newPalette(opts)
+	header := []string{p.header("FIELD")}
+	if len(values) == 1 {
+		header = append(header, p.header("VALUE"))
+	} else {
+		for i := range values {
+			header = append(header, p.header(fmt.Sprintf("#%d", i+1)))
+		}
+	}
+
+	// one column per value, the rows come from the first
+	columns := make([][]field, len(values))
+	for i, v := range values {
+		columns[i] = fields(v)
+	}
+	table := tablewriter.NewTable(w,
+		// the header is upper case already, auto format would upper
+		// case the color codes as well
+		tablewriter.WithHeaderAutoFormat(tw.Off),
+		tablewriter.WithRowAlignment(tw.AlignLeft),
+	)
+	table.Header(header)
+	for j, f := range columns[0] {
+		row := []string{p.name(f.name)}
+		for _, col := range columns {
+			row = append(row, tableValue(col[j]))
+		}
+		if err := table.Append(row); err != nil {
+			return err
+		}
+	}
+	return table.Render()
+}
+
+func tableValue(f field) string {
+	if !f.list {
+		return textValue(f.value)
+	}
+	elems := make([]string, len(f.elems))
+	for i, el := range f.elems {
+		elems[i] = textValue(el)
+	}
+	return strings.Join(elems, "\n")
+}
+
+// field is one flattened value, list fields keep their elements
+type field struct {
+	name  string
+	value reflect.Value
+	list  bool
+	elems []reflect.Value
+}
+
+func fields(v interface{}) []field {
+	var out []field
+	flatten(&out, "", reflect.ValueOf(v))
+	return out
+}
+
+func flatten(out *[]field, prefix string, v reflect.Value) {
+	v = deref(v)
+	if v.Kind() != reflect.Struct {
+		*out = append(*out, field{name: strings.TrimSuffix(prefix, ".") + "Value", value: v})
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || sf.Tag.Get("report") == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		name := prefix + sf.Name
+
+		switch {
+		case sf.Anonymous:
+			flatten(out, prefix, fv)
+		case isStruct(fv):
+			flatten(out, name+".", fv)
+		case fv.Kind() == reflect.Slice && fv.Type() != rawType:
+			f := field{name: name, list: true}
+			for j := 0; j < fv.Len(); j++ {
+				f.elems = append(f.elems, fv.Index(j))
+			}
+			*out = append(*out, f)
+		default:
+			*out = append(*out, field{name: name, value: fv})
+		}
+	}
+}
+
+// deref follows pointers, a nil one is its zero value so every value of
+// a type has the same fields
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Zero(v.Type().Elem())
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+var (
+	rawType  = reflect.TypeOf(json.RawMessage(nil))
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+func isStruct(v reflect.Value) bool {
+	v = deref(v)
+	if v.Kind() != reflect.Struct || v.Type() == timeType {
+		return false
+	}
+	_, stringer := v.Interface().(fmt.Stringer)
+	return !stringer
+}
+
+func textValue(v reflect.Value) string {
+	v = deref(v)
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return x.String()
+	case time.Time:
+		return x.Format(time.RFC3339)
+	case float64:
+		return fmt.Sprintf("%.4g", x)
+	case fmt.Stringer:
+		return x.String()
+	case json.RawMessage:
+		return string(x)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func csvValue(v reflect.Value) string {
+	v = deref(v)
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return strconv.FormatInt(int64(x), 10)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case fmt.Stringer:
+		return x.String()
+	case json.RawMessage:
+		return string(x)
+	}
+	return fmt.Sprint(v.Interface())
+}
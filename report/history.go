@@ -0,0 +1,149 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var ErrNoRun = errors.New("no such run")
+
+/* {comment}
+
+the history is a JSON Lines file of Records, every bench run appends
+its records with the same Run id. a record carries what it is keyed
+by: Commit, Environment.GoVersion, Environment.CPU and Scenario, so two
+runs compare scenario by scenario and a comparison can tell when the
+machine or toolchain changed underneath
+
+{/comment} */
+
+// DefaultHistory is where bench appends unless told otherwise
+const DefaultHistory = ".ipld-bench-history.jsonl"
+
+// Run is the records of one bench run, in the order they were written
+type Run struct {
+	ID      string
+	Commit  string
+	Time    time.Time
+	Records []*Record
+}
+
+// NewRunID names a run by when it started, runs sort by their ids
+func NewRunID(t time.Time) string {
+	return t.UTC().Format("20060102-150405.000")
+}
+
+// GitCommit is the commit of the working tree, "-dirty" when it has
+// changes and "unknown" outside a repository
+func GitCommit() string {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "unknown"
+	}
+	commit := strings.TrimSpace(string(out))
+	if status, err := exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output(); err == nil && len(strings.TrimSpace(string(status))) > 0 {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// AppendHistory adds records to the history at path, creating it
+func AppendHistory(path string, records ...*Record) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadHistory groups the records at path into runs, oldest first
+func ReadHistory(path string) ([]*Run, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeHistory(f)
+}
+
+func DecodeHistory(r io.Reader) ([]*Run, error) {
+	var runs []*Run
+	byID := make(map[string]*Run)
+	sc := bufio.NewScanner(r)
+	// a record with its raw samples is a long line
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(sc.Bytes(), rec); err != nil {
+			return nil, fmt.Errorf("history line %d : %w", line, err)
+		}
+		run, ok := byID[rec.Run]
+		if !ok {
+			run = &Run{ID: rec.Run, Commit: rec.Commit, Time: rec.Time}
+			byID[rec.Run] = run
+			runs = append(runs, run)
+		}
+		run.Records = append(run.Records, rec)
+	}
+	return runs, sc.Err()
+}
+
+/* {comment}
+
+FindRun picks a run by reference:
+
+	latest     the last run
+	previous   the one before it
+	<id>       a run id
+	<commit>   the last run of a commit, 4 or more leading hex digits
+
+{/comment} */
+
+func FindRun(runs []*Run, ref string) (*Run, error) {
+	switch ref {
+	case "latest":
+		if len(runs) > 0 {
+			return runs[len(runs)-1], nil
+		}
+	case "previous":
+		if len(runs) > 1 {
+			return runs[len(runs)-2], nil
+		}
+	default:
+		for _, run := range runs {
+			if run.ID == ref {
+				return run, nil
+			}
+		}
+		if len(ref) >= 4 {
+			for i := len(runs) - 1; i >= 0; i-- {
+				if strings.HasPrefix(runs[i].Commit, ref) {
+					return runs[i], nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w %q in %d runs", ErrNoRun, ref, len(runs))
+}
@@ -0,0 +1,135 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/myipld"
+)
+
+/* {comment}
+
+a Record is one benchmark run with everything needed to read it later
+without the command line that made it: what ran (scenario, structure,
+node count, codec), what it measured and where it ran. the writers in
+format.go turn records, or any other result struct, into text, JSON,
+JSON Lines, CSV or a table
+
+{/comment} */
+
+type Record struct {
+	// Run groups the records of one bench run in the history, Commit is
+	// the code it ran
+	Run    string
+	Commit string
+	// Scenario names the benchmark, structure/nodes/codec
+	Scenario  string
+	Structure string
+	Nodes     int
+	MaxLinks  int
+	Seed      int64
+	Codec     string
+	// Metrics are generation, traversal and (de)serialization together
+	// plus the shape of the DAG. TotalTime, NodesPerSecond and
+	// MemoryTotal here and in node creation are the medians and means of
+	// Stats, the GC and peaks are of a single run
+	Metrics            bench.DAGMetrics
+	NodeCreation       bench.PerformanceMetrics
+	SignedNodeCreation bench.PerformanceMetrics
+	// Stats are every operation sampled by the runner after Warmup
+	// unmeasured runs
+	Warmup int
+	Stats  bench.OperationStats
+	// Profiles are the profile files and hot functions of every phase of
+	// Metrics when bench ran with -profile
+	Profiles    *bench.Profiles `json:",omitempty"`
+	Environment Environment
+	Time        time.Time
+}
+
+// Environment is the machine and toolchain a record was taken on
+type Environment struct {
+	GoVersion  string
+	OS         string
+	Arch       string
+	CPU        string
+	NumCPU     int
+	GOMAXPROCS int
+	Hostname   string
+}
+
+// CurrentEnvironment describes this process, CPU is the model name when
+// the OS tells us and the architecture otherwise
+func CurrentEnvironment() Environment {
+	host, _ := os.Hostname()
+	return Environment{
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPU:        cpuModel(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Hostname:   host,
+	}
+}
+
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.GOARCH
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if ok && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return runtime.GOARCH
+}
+
+func Scenario(opts bench.GenerateOptions, codec string) string {
+	return fmt.Sprintf("%s/%d/%s", opts.Structure, opts.Nodes, codec)
+}
+
+// Benchmark measures the DAG opts describe and records it, c measures the
+// compressed size under the name codec (nil for none), ro says how to
+// sample the operations and prof profiles the phases (nil for none)
+func Benchmark(opts bench.GenerateOptions, codec string, c myipld.Compressor, ro bench.RunnerOptions, prof *bench.Profiler) (*Record, error) {
+	_, dagMetrics, profiles, err := bench.BenchmarkDAGOperationsProfiled(opts, c, prof)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := bench.SampleDAGOperations(opts, ro)
+	if err != nil {
+		return nil, err
+	}
+	// the one run above is for the GC, the sizes and the profiles, times
+	// come from the samples
+	sampled := stats.DAGPerformance(opts.Nodes)
+	dagMetrics.TotalTime = sampled.TotalTime
+	dagMetrics.NodesPerSecond = sampled.NodesPerSecond
+	dagMetrics.MemoryTotal = sampled.MemoryTotal
+	return &Record{
+		Scenario:           Scenario(opts, codec),
+		Structure:          opts.Structure.String(),
+		Nodes:              opts.Nodes,
+		MaxLinks:           opts.MaxLinks,
+		Seed:               opts.Seed,
+		Codec:              codec,
+		Metrics:            *dagMetrics,
+		NodeCreation:       stats.NodeCreation.Performance(opts.Nodes),
+		SignedNodeCreation: stats.SignedNodeCreation.Performance(opts.Nodes),
+		Warmup:             ro.Warmup,
+		Stats:              *stats,
+		Profiles:           profiles,
+		Environment:        CurrentEnvironment(),
+		Time:               time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,122 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"ipld-benchmark/myipld"
+)
+
+var ErrCommandFailed = errors.New("source command failed")
+
+// DefaultCommandTimeout bounds a source command unless told otherwise
+const DefaultCommandTimeout = time.Minute
+
+// stderrTail is how much of a command's stderr goes into an error
+const stderrTail = 4 << 10
+
+type CommandOptions struct {
+	// Timeout covers the whole run, DefaultCommandTimeout when zero
+	Timeout time.Duration
+	// Dir is the working directory, the current one when empty
+	Dir string
+}
+
+/* {comment}
+
+RunCommand runs command through sh -c and streams its stdout through
+Stream as it is written, so neither the output nor the node data has to
+fit in memory. the command fails the load if it exits non zero, runs
+past the timeout or writes something that is not a description, and
+the end of its stderr is in the error
+
+{/comment} */
+
+func RunCommand(ctx context.Context, command string, opts CommandOptions, bs myipld.Blockstore) (*Result, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("%w: empty command", ErrCommandFailed)
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = opts.Dir
+	stderr := &tailBuffer{max: stderrTail}
+	cmd.Stderr = stderr
+	// a child the shell started can hold the pipes open after the shell
+	// is killed, don't wait for it forever
+	cmd.WaitDelay = time.Second
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %q : %v", ErrCommandFailed, command, err)
+	}
+
+	// unblock the parser when the timeout hits, the output may not end
+	stop := context.AfterFunc(ctx, func() { stdout.Close() })
+	defer stop()
+
+	res, parseErr := Stream(stdout, bs)
+	if parseErr != nil {
+		// no point in letting it write the rest
+		cancel()
+	}
+	waitErr := cmd.Wait()
+
+	// an exit status means it stopped on its own, a kill from the cancel
+	// above has none
+	var exitErr *exec.ExitError
+	exited := errors.As(waitErr, &exitErr) && exitErr.ExitCode() > 0
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("%w: %q timed out after %s%s", ErrCommandFailed, command, timeout, stderr.suffix())
+	case exited:
+		return nil, fmt.Errorf("%w: %q : %v%s", ErrCommandFailed, command, waitErr, stderr.suffix())
+	case parseErr != nil:
+		return nil, fmt.Errorf("%q : %w%s", command, parseErr, stderr.suffix())
+	case waitErr != nil:
+		return nil, fmt.Errorf("%w: %q : %v%s", ErrCommandFailed, command, waitErr, stderr.suffix())
+	}
+	return res, nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+// suffix is ": stderr: ..." or nothing when it wrote none
+func (t *tailBuffer) suffix() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	msg := strings.TrimSpace(string(t.buf))
+	if msg == "" {
+		return ""
+	}
+	return " : stderr: " + msg
+}
@@ -0,0 +1,322 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"ipld-benchmark/myipld"
+)
+
+var (
+	ErrInvalidDescription = errors.New("invalid dag description")
+	ErrCycle              = errors.New("dag description has a cycle")
+)
+
+/* {comment}
+
+a DAG description is the JSON format from the README:
+
+	{"nodes": [{"id": "a", "data": {...}}, ...],
+	 "links": [{"source": "a", "target": "b", "name": "child"}, ...]}
+
+ids only name nodes inside the description. every node becomes a MyNode
+with its data as written (compacted) and its links in the order they
+are listed, so the same description always gives the same CIDs and a
+description exported from a DAG loads back to the same CIDs
+
+a node's CID covers its children's, so nodes are built children first,
+which only works if the links form a DAG. a cycle is an error that
+names the nodes on it
+
+{/comment} */
+
+type Description struct {
+	Nodes []Node `json:"nodes"`
+	Links []Link `json:"links"`
+}
+
+type Node struct {
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+type Link struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Name   string `json:"name"`
+}
+
+// Root is a node nothing links to
+type Root struct {
+	ID  string
+	Cid myipld.MyCID
+}
+
+type Result struct {
+	// Roots are in the order the description lists them
+	Roots []Root
+	Nodes int
+	Links int
+	// Bytes is the encoded size of every node written
+	Bytes int64
+}
+
+// Builder collects nodes and links in any order and builds them on
+// Finish, Load feeds it a whole description
+type Builder struct {
+	bs    myipld.Blockstore
+	index map[string]int
+	nodes []*pending
+	// links wait for a node that was not added yet
+	links     []Link
+	linkSeq   []int
+	linkCount int
+	// spool holds node data until it is built instead of memory, see
+	// Stream
+	spool    *os.File
+	spoolEnd int64
+}
+
+type pending struct {
+	id   string
+	data json.RawMessage
+	// off and size locate data in the spool
+	off  int64
+	size int
+	// children are indexes into nodes, one per link in link order
+	children []int
+	names    []string
+	seqs     []int
+	parents  []int
+	waiting  int
+	cid      myipld.MyCID
+	built    bool
+}
+
+func NewBuilder(bs myipld.Blockstore) *Builder {
+	return &Builder{bs: bs, index: make(map[string]int)}
+}
+
+func (b *Builder) AddNode(id string, data json.RawMessage) error {
+	if id == "" {
+		return fmt.Errorf("%w: node %d has no id", ErrInvalidDescription, len(b.nodes))
+	}
+	if _, ok := b.index[id]; ok {
+		return fmt.Errorf("%w: duplicate node id %q", ErrInvalidDescription, id)
+	}
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	n := &pending{id: id, data: data}
+	if b.spool != nil {
+		if _, err := b.spool.Write(data); err != nil {
+			return fmt.Errorf("spooling node %q : %w", id, err)
+		}
+		n.data, n.off, n.size = nil, b.spoolEnd, len(data)
+		b.spoolEnd += int64(len(data))
+	}
+	b.index[id] = len(b.nodes)
+	b.nodes = append(b.nodes, n)
+	return nil
+}
+
+// AddLink may name nodes that are added later, those are resolved on
+// Finish, a link between known nodes only keeps their indexes
+func (b *Builder) AddLink(source, target, name string) error {
+	if source == "" || target == "" {
+		return fmt.Errorf("%w: link %d needs a source and a target", ErrInvalidDescription, b.linkCount)
+	}
+	b.linkCount++
+	src, srcOK := b.index[source]
+	dst, dstOK := b.index[target]
+	if srcOK && dstOK {
+		b.wire(src, dst, name, b.linkCount)
+		return nil
+	}
+	b.links = append(b.links, Link{Source: source, Target: target, Name: name})
+	b.linkSeq = append(b.linkSeq, b.linkCount)
+	return nil
+}
+
+func (b *Builder) wire(src, dst int, name string, seq int) {
+	parent, child := b.nodes[src], b.nodes[dst]
+	parent.children = append(parent.children, dst)
+	parent.names = append(parent.names, name)
+	parent.seqs = append(parent.seqs, seq)
+	parent.waiting++
+	child.parents = append(child.parents, src)
+}
+
+// Finish builds every node children first, puts it into the Blockstore
+// and returns the roots
+func (b *Builder) Finish() (*Result, error) {
+	if len(b.nodes) == 0 {
+		return nil, fmt.Errorf("%w: no nodes", ErrInvalidDescription)
+	}
+	late := make(map[int]bool)
+	for i, l := range b.links {
+		src, ok := b.index[l.Source]
+		if !ok {
+			return nil, fmt.Errorf("%w: link from unknown node %q", ErrInvalidDescription, l.Source)
+		}
+		dst, ok := b.index[l.Target]
+		if !ok {
+			return nil, fmt.Errorf("%w: link to unknown node %q", ErrInvalidDescription, l.Target)
+		}
+		b.wire(src, dst, l.Name, b.linkSeq[i])
+		late[src] = true
+	}
+	// links wired late go back to where the description had them
+	for src := range late {
+		sort.Sort(byLinkOrder{b.nodes[src]})
+	}
+	b.links, b.linkSeq = nil, nil
+
+	res := &Result{Links: b.linkCount}
+	// leaves first, then whoever they completed, in description order
+	var ready []int
+	for i, n := range b.nodes {
+		if n.waiting == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		n := b.nodes[ready[0]]
+		ready = ready[1:]
+
+		size, err := b.build(n)
+		if err != nil {
+			return nil, err
+		}
+		res.Nodes++
+		res.Bytes += size
+		for _, p := range n.parents {
+			if b.nodes[p].waiting--; b.nodes[p].waiting == 0 {
+				ready = append(ready, p)
+			}
+		}
+	}
+
+	if res.Nodes < len(b.nodes) {
+		return nil, fmt.Errorf("%w: %s", ErrCycle, b.findCycle())
+	}
+	for _, n := range b.nodes {
+		if len(n.parents) == 0 {
+			res.Roots = append(res.Roots, Root{ID: n.id, Cid: n.cid})
+		}
+	}
+	return res, nil
+}
+
+func (b *Builder) build(n *pending) (int64, error) {
+	links := make([]myipld.MyLink, len(n.children))
+	for i, c := range n.children {
+		links[i] = myipld.MyLink{Name: n.names[i], Cid: b.nodes[c].cid}
+	}
+	data := n.data
+	if b.spool != nil {
+		data = make(json.RawMessage, n.size)
+		if _, err := b.spool.ReadAt(data, n.off); err != nil {
+			return 0, fmt.Errorf("node %q : reading spool : %w", n.id, err)
+		}
+	}
+	node, err := myipld.NewMyNodeWithLinks(data, links)
+	if err != nil {
+		return 0, fmt.Errorf("node %q : %w", n.id, err)
+	}
+	if err := myipld.PutNode(b.bs, node); err != nil {
+		return 0, fmt.Errorf("node %q : %w", n.id, err)
+	}
+	raw, _ := node.ToBytes()
+	n.cid, n.built = node.Cid, true
+	// the data is in the block now
+	n.data = nil
+	return int64(len(raw)), nil
+}
+
+type byLinkOrder struct {
+	n *pending
+}
+
+func (s byLinkOrder) Len() int           { return len(s.n.seqs) }
+func (s byLinkOrder) Less(i, j int) bool { return s.n.seqs[i] < s.n.seqs[j] }
+func (s byLinkOrder) Swap(i, j int) {
+	n := s.n
+	n.seqs[i], n.seqs[j] = n.seqs[j], n.seqs[i]
+	n.children[i], n.children[j] = n.children[j], n.children[i]
+	n.names[i], n.names[j] = n.names[j], n.names[i]
+}
+
+// findCycle follows unbuilt children until a node repeats, every unbuilt
+// node is on a cycle or above one so this always ends on one
+func (b *Builder) findCycle() string {
+	start := -1
+	for i, n := range b.nodes {
+		if !n.built {
+			start = i
+			break
+		}
+	}
+	pos := make(map[int]int)
+	var path []int
+	for cur := start; ; {
+		if at, ok := pos[cur]; ok {
+			path = append(path[at:], cur)
+			break
+		}
+		pos[cur] = len(path)
+		path = append(path, cur)
+		for _, c := range b.nodes[cur].children {
+			if !b.nodes[c].built {
+				cur = c
+				break
+			}
+		}
+	}
+
+	ids := make([]string, len(path))
+	for i, p := range path {
+		ids[i] = fmt.Sprintf("%q", b.nodes[p].id)
+	}
+	return strings.Join(ids, " -> ")
+}
+
+// Build loads a decoded description
+func Build(desc *Description, bs myipld.Blockstore) (*Result, error) {
+	b := NewBuilder(bs)
+	for _, n := range desc.Nodes {
+		if err := b.AddNode(n.ID, n.Data); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range desc.Links {
+		if err := b.AddLink(l.Source, l.Target, l.Name); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// Load reads a description from r and writes its nodes to bs, the
+// node data is kept in memory until it is built
+func Load(r io.Reader, bs myipld.Blockstore) (*Result, error) {
+	b := NewBuilder(bs)
+	if err := Decode(r, b); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+func LoadFile(path string, bs myipld.Blockstore) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f, bs)
+}
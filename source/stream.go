@@ -0,0 +1,110 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"ipld-benchmark/myipld"
+)
+
+/* {comment}
+
+Decode walks the description token by token and hands every node and
+link to the Builder as soon as it is read, only one of them is decoded
+at a time. "nodes" and "links" may come in either order, other fields
+are skipped
+
+{/comment} */
+
+func Decode(r io.Reader, b *Builder) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return invalid(err)
+		}
+		key, _ := tok.(string)
+
+		switch key {
+		case "nodes":
+			err = decodeArray(dec, func() error {
+				var n Node
+				if err := dec.Decode(&n); err != nil {
+					return invalid(err)
+				}
+				return b.AddNode(n.ID, n.Data)
+			})
+		case "links":
+			err = decodeArray(dec, func() error {
+				var l Link
+				if err := dec.Decode(&l); err != nil {
+					return invalid(err)
+				}
+				return b.AddLink(l.Source, l.Target, l.Name)
+			})
+		default:
+			var skip json.RawMessage
+			if err = dec.Decode(&skip); err != nil {
+				err = invalid(err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func decodeArray(dec *json.Decoder, each func() error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		if err := each(); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return invalid(err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("%w: expected %q at offset %d, got %v", ErrInvalidDescription, want, dec.InputOffset(), tok)
+	}
+	return nil
+}
+
+func invalid(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidDescription, err)
+}
+
+// Stream is Load for descriptions too big for memory: node data goes to
+// a temporary file as it is read and back out when its node is built,
+// what stays in memory is the ids and the links between them
+func Stream(r io.Reader, bs myipld.Blockstore) (*Result, error) {
+	spool, err := os.CreateTemp("", "dag-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	b := NewBuilder(bs)
+	b.spool = spool
+	if err := Decode(r, b); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
@@ -0,0 +1,203 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/myipld"
+)
+
+/* {comment}
+
+go test -bench=. -benchmem ./test/ runs these. every operation gets a
+sub-benchmark per structure and size (BenchmarkEncode/BinaryTreeDAG/1000)
+so -bench can pick one, and reports nodes/sec next to ns/op and
+bytes/node where a DAG is encoded. the DAGs are seeded so the same
+sub-benchmark always works on the same blocks
+
+{/comment} */
+
+var benchSizes = []int{100, 1000}
+
+// eachDAG runs fn as a sub-benchmark for every structure and size
+func eachDAG(b *testing.B, fn func(b *testing.B, root *myipld.MyNode, nodes []*myipld.MyNode)) {
+	for _, s := range bench.Structures() {
+		for _, n := range benchSizes {
+			b.Run(fmt.Sprintf("%s/%d", s, n), func(b *testing.B) {
+				root, nodes, err := bench.GenerateWithOptions(bench.GenerateOptions{Structure: s, Nodes: n, Seed: 1})
+				if err != nil {
+					b.Fatalf("Failed to generate %s: %v", s, err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				fn(b, root, nodes)
+			})
+		}
+	}
+}
+
+// reportNodes adds nodes/sec for perOp nodes handled every iteration
+func reportNodes(b *testing.B, perOp int) {
+	if secs := b.Elapsed().Seconds(); secs > 0 {
+		b.ReportMetric(float64(perOp*b.N)/secs, "nodes/sec")
+	}
+}
+
+// reportBytes adds the average encoded size of nodes
+func reportBytes(b *testing.B, nodes []*myipld.MyNode) {
+	size, _, err := bench.MeasureSerializedSize(nodes, nil)
+	if err != nil {
+		b.Fatalf("Failed to measure: %v", err)
+	}
+	b.ReportMetric(float64(size)/float64(len(nodes)), "bytes/node")
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	for _, s := range bench.Structures() {
+		for _, n := range benchSizes {
+			b.Run(fmt.Sprintf("%s/%d", s, n), func(b *testing.B) {
+				opts := bench.GenerateOptions{Structure: s, Nodes: n, Seed: 1}
+				b.ReportAllocs()
+				var nodes []*myipld.MyNode
+				for i := 0; i < b.N; i++ {
+					var err error
+					if _, nodes, err = bench.GenerateWithOptions(opts); err != nil {
+						b.Fatalf("Failed to generate %s: %v", s, err)
+					}
+				}
+				b.StopTimer()
+				reportNodes(b, n)
+				reportBytes(b, nodes)
+			})
+		}
+	}
+}
+
+func BenchmarkNodeCreation(b *testing.B) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		b.Fatalf("Failed to generate a key: %v", err)
+	}
+	for _, size := range []int{64, 1024, 16384} {
+		data := map[string]interface{}{"payload": strings.Repeat("x", size)}
+		for _, signed := range []bool{false, true} {
+			name := fmt.Sprintf("%dB", size)
+			if signed {
+				name += "/signed"
+			}
+			b.Run(name, func(b *testing.B) {
+				b.ReportAllocs()
+				var node *myipld.MyNode
+				for i := 0; i < b.N; i++ {
+					if node, err = myipld.NewMyNode(data); err != nil {
+						b.Fatalf("Failed to create a node: %v", err)
+					}
+					if signed {
+						if err := node.Sign(priv); err != nil {
+							b.Fatalf("Failed to sign: %v", err)
+						}
+					}
+				}
+				b.StopTimer()
+				reportNodes(b, 1)
+				reportBytes(b, []*myipld.MyNode{node})
+			})
+		}
+	}
+}
+
+// BenchmarkAddLink adds one link to a node that has links already, the
+// CID covers every link so the cost grows with them
+func BenchmarkAddLink(b *testing.B) {
+	target, err := myipld.NewMyNode("target")
+	if err != nil {
+		b.Fatalf("Failed to create the target: %v", err)
+	}
+	for _, links := range []int{0, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("links-%d", links), func(b *testing.B) {
+			node, err := myipld.NewMyNode("parent")
+			if err != nil {
+				b.Fatalf("Failed to create the parent: %v", err)
+			}
+			for i := 0; i < links; i++ {
+				node.AddLink(fmt.Sprintf("link-%d", i), target.Cid)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				node.Links = node.Links[:links]
+				if err := node.AddLink("new", target.Cid); err != nil {
+					b.Fatalf("Failed to add a link: %v", err)
+				}
+			}
+			b.StopTimer()
+			reportNodes(b, 1)
+			reportBytes(b, []*myipld.MyNode{node})
+		})
+	}
+}
+
+func BenchmarkTraversal(b *testing.B) {
+	eachDAG(b, func(b *testing.B, root *myipld.MyNode, nodes []*myipld.MyNode) {
+		for i := 0; i < b.N; i++ {
+			bench.BenchmarkTraversal(root, nodes)
+		}
+		b.StopTimer()
+		reportNodes(b, len(nodes))
+	})
+}
+
+// BenchmarkEncode encodes copies of the nodes, a node caches its bytes
+// so ToBytes on the node itself would not encode anything
+func BenchmarkEncode(b *testing.B) {
+	eachDAG(b, func(b *testing.B, root *myipld.MyNode, nodes []*myipld.MyNode) {
+		var total int64
+		for i := 0; i < b.N; i++ {
+			total = 0
+			for _, n := range nodes {
+				fresh := &myipld.MyNode{Data: n.Data, Links: n.Links, Signature: n.Signature}
+				raw, err := fresh.ToBytes()
+				if err != nil {
+					b.Fatalf("Failed to encode: %v", err)
+				}
+				total += int64(len(raw))
+			}
+		}
+		b.StopTimer()
+		b.SetBytes(total)
+		reportNodes(b, len(nodes))
+		reportBytes(b, nodes)
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	eachDAG(b, func(b *testing.B, root *myipld.MyNode, nodes []*myipld.MyNode) {
+		b.StopTimer()
+		blocks := make([][]byte, len(nodes))
+		var total int64
+		for i, n := range nodes {
+			raw, err := n.ToBytes()
+			if err != nil {
+				b.Fatalf("Failed to encode: %v", err)
+			}
+			blocks[i] = raw
+			total += int64(len(raw))
+		}
+		b.SetBytes(total)
+		b.StartTimer()
+
+		for i := 0; i < b.N; i++ {
+			for _, raw := range blocks {
+				if _, err := myipld.FromBytes(raw); err != nil {
+					b.Fatalf("Failed to decode: %v", err)
+				}
+			}
+		}
+		b.StopTimer()
+		reportNodes(b, len(nodes))
+		reportBytes(b, nodes)
+	})
+}
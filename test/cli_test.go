@@ -0,0 +1,166 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/cli"
+)
+
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := cli.Run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestSeededGeneration(t *testing.T) {
+	for _, s := range bench.Structures() {
+		opts := bench.GenerateOptions{Structure: s, Nodes: 50, Seed: 42}
+		a, _, err := bench.GenerateWithOptions(opts)
+		if err != nil {
+			t.Fatalf("Failed to generate %s: %v", s, err)
+		}
+		b, _, _ := bench.GenerateWithOptions(opts)
+		if a.Cid != b.Cid {
+			t.Errorf("Expected the same root for the same seed, %s differs", s)
+		}
+		opts.Seed = 43
+		if c, _, _ := bench.GenerateWithOptions(opts); c.Cid == a.Cid {
+			t.Errorf("Expected another seed to change the %s root", s)
+		}
+	}
+
+	for _, name := range []string{"linear", "BinaryTreeDAG", "Star", "random", "binary-tree"} {
+		if _, err := bench.ParseStructure(name); err != nil {
+			t.Errorf("Expected %q to parse: %v", name, err)
+		}
+	}
+}
+
+func TestCLIGenerateAndInspect(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.car"), filepath.Join(dir, "b.car")
+
+	code, out, stderr := runCLI(t, "generate", "-structure", "random", "-nodes", "40", "-seed", "3", "-o", a, "-format", "json")
+	if code != cli.ExitOK {
+		t.Fatalf("Failed to generate: %d %s", code, stderr)
+	}
+	var gen struct {
+		Root  string
+		Nodes int
+	}
+	if err := json.Unmarshal([]byte(out), &gen); err != nil || gen.Nodes != 40 {
+		t.Fatalf("Expected a json summary of 40 nodes, got %q: %v", out, err)
+	}
+	runCLI(t, "generate", "-structure", "random", "-nodes", "40", "-seed", "3", "-o", b)
+
+	code, out, _ = runCLI(t, "diff", a, b, "-format", "json")
+	var diff struct {
+		SameRoot bool
+		Shared   int
+	}
+	if err := json.Unmarshal([]byte(out), &diff); err != nil || code != cli.ExitOK || !diff.SameRoot || diff.Shared != 40 {
+		t.Errorf("Expected identical DAGs for the same seed, got %q", out)
+	}
+
+	code, out, _ = runCLI(t, "inspect", a)
+	if code != cli.ExitOK || !strings.Contains(out, gen.Root) || !strings.Contains(out, "random-link-to-") {
+		t.Errorf("Expected the root and its links, got %q", out)
+	}
+
+	code, out, _ = runCLI(t, "analyze", a)
+	if code != cli.ExitOK || !strings.Contains(out, "Shape.MaxDepth") {
+		t.Errorf("Expected the DAG shape, got %q", out)
+	}
+
+	// the CAR itself on stdout
+	code, out, _ = runCLI(t, "generate", "-nodes", "5", "-seed", "1")
+	if code != cli.ExitOK || len(out) == 0 || out[0] == '{' {
+		t.Errorf("Expected a CAR on stdout, got %d bytes", len(out))
+	}
+}
+
+func TestCLIExportImport(t *testing.T) {
+	dir := t.TempDir()
+	clip := filepath.Join(dir, "clip.bin")
+	// random bytes, repeated ones would dedupe into fewer chunks
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(clip, data, 0o644); err != nil {
+		t.Fatalf("Failed to write media file: %v", err)
+	}
+	asset := filepath.Join(dir, "clip.car")
+
+	code, out, stderr := runCLI(t, "import", clip, "-duration", "20s", "-segment", "5s", "-o", asset)
+	if code != cli.ExitOK || !strings.Contains(out, "Segments") {
+		t.Fatalf("Failed to import: %d %s", code, stderr)
+	}
+
+	code, out, _ = runCLI(t, "export", asset)
+	var desc struct {
+		Nodes []struct{ ID string }
+		Links []struct{ Source, Target, Name string }
+	}
+	if err := json.Unmarshal([]byte(out), &desc); err != nil || code != cli.ExitOK {
+		t.Fatalf("Failed to parse the export: %v", err)
+	}
+	// the manifest links 4 segments, every segment one chunk
+	if len(desc.Nodes) != 9 || len(desc.Links) != 8 || desc.Links[0].Name != "segment-00000" {
+		t.Errorf("Expected 9 nodes and 8 links, got %d and %d", len(desc.Nodes), len(desc.Links))
+	}
+
+	sub := filepath.Join(dir, "segment.car")
+	if code, _, stderr := runCLI(t, "export", asset, "-to", "car", "-root", desc.Links[0].Target, "-o", sub); code != cli.ExitOK {
+		t.Fatalf("Failed to export a subtree: %s", stderr)
+	}
+	code, out, _ = runCLI(t, "diff", asset, sub, "-format", "json")
+	var diff struct {
+		Shared  int
+		OnlyInB []string
+	}
+	if err := json.Unmarshal([]byte(out), &diff); err != nil || code != cli.ExitOK || diff.Shared != 2 || len(diff.OnlyInB) != 0 {
+		t.Errorf("Expected the segment and its chunk to be shared, got %q", out)
+	}
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{nil, cli.ExitUsage, "usage:"},
+		{[]string{"frobnicate"}, cli.ExitUsage, `unknown command "frobnicate"`},
+		{[]string{"bench", "-bogus"}, cli.ExitUsage, "ipld-benchmark: bench: flag provided but not defined"},
+		{[]string{"bench", "-structure", "blob"}, cli.ExitUsage, "unknown DAG structure"},
+		{[]string{"bench", "-codec", "zstd"}, cli.ExitUsage, "-codec must be one of"},
+		{[]string{"generate", "-hasher", "md5"}, cli.ExitUsage, "-hasher must be one of"},
+		{[]string{"analyze", "-format", "xml", "-nodes", "10"}, cli.ExitUsage, "-format must be one of"},
+		{[]string{"analyze", "-color", "red"}, cli.ExitUsage, `invalid value "red" for flag -color`},
+		{[]string{"generate", "-nodes", "10,20"}, cli.ExitUsage, "take a single value here"},
+		{[]string{"bench", "-samples", "0"}, cli.ExitUsage, "-samples must be positive"},
+		{[]string{"bench", "-profile-top", "0"}, cli.ExitUsage, "-profile-top must be positive"},
+		{[]string{"bench", "-profile-time", "0s"}, cli.ExitUsage, "-profile-time must be positive"},
+		{[]string{"diff", "only-one.car"}, cli.ExitUsage, "expected 2 argument(s), got 1"},
+		{[]string{"inspect", filepath.Join(t.TempDir(), "missing.car")}, cli.ExitError, "ipld-benchmark: inspect: open"},
+		{[]string{"help"}, cli.ExitOK, ""},
+		{[]string{"diff", "-h"}, cli.ExitOK, ""},
+	}
+	for _, c := range cases {
+		code, _, stderr := runCLI(t, c.args...)
+		if code != c.code || !strings.Contains(stderr, c.stderr) {
+			t.Errorf("%v: expected exit %d with %q, got %d with %q", c.args, c.code, c.stderr, code, stderr)
+		}
+	}
+
+	if code, out, _ := runCLI(t, "bench", "-h"); code != cli.ExitOK || !strings.Contains(out, "-max-links") {
+		t.Errorf("Expected the flags of bench on stdout, got %q", out)
+	}
+}
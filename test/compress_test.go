@@ -0,0 +1,85 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/myipld"
+)
+
+func TestCompressedBlockstore(t *testing.T) {
+	_, nodes, err := bench.GenerateDAG(bench.LinearDAG, 20)
+	if err != nil {
+		t.Fatalf("Failed to generate DAG: %v", err)
+	}
+
+	for _, c := range []myipld.Compressor{myipld.GzipCompressor{}, myipld.DeflateCompressor{}} {
+		t.Run(c.Name(), func(t *testing.T) {
+			bs := myipld.NewCompressedBlockstore(myipld.NewMemBlockstore(), c)
+			for _, node := range nodes {
+				if err := myipld.PutNode(bs, node); err != nil {
+					t.Fatalf("Failed to put node: %v", err)
+				}
+			}
+
+			for _, node := range nodes {
+				raw, _ := node.ToBytes()
+				got, err := bs.Get(node.Cid)
+				if err != nil {
+					t.Fatalf("Failed to get node %s: %v", node.Cid, err)
+				}
+				if !bytes.Equal(got, raw) {
+					t.Fatalf("Block %s did not round trip", node.Cid)
+				}
+
+				// the CID stays the hash of the uncompressed bytes
+				decoded, err := myipld.GetNode(bs, node.Cid)
+				if err != nil || decoded.Cid != node.Cid {
+					t.Fatalf("Expected block to decode to %s, got %v", node.Cid, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressedBlockstoreKeepsIncompressibleRaw(t *testing.T) {
+	inner := myipld.NewMemBlockstore()
+	bs := myipld.NewCompressedBlockstore(inner, myipld.GzipCompressor{})
+
+	data := []byte("x")
+	cid, _ := myipld.ComputeSHA256(data)
+	if err := bs.Put(cid, data); err != nil {
+		t.Fatalf("Failed to put block: %v", err)
+	}
+
+	size, _ := bs.StoredSize(cid)
+	if size != len(data)+1 {
+		t.Errorf("Expected tiny block to be stored raw (%d bytes), got %d", len(data)+1, size)
+	}
+}
+
+func TestLookupCompressor(t *testing.T) {
+	for _, name := range []string{"gzip", "deflate"} {
+		c, err := myipld.LookupCompressor(name)
+		if err != nil || c.Name() != name {
+			t.Errorf("Expected compressor %s, got %v (%v)", name, c, err)
+		}
+	}
+	if _, err := myipld.LookupCompressor("zstd"); err == nil {
+		t.Error("Expected error for unregistered compressor")
+	}
+}
+
+func TestSerializedSizeMetrics(t *testing.T) {
+	perf, _, err := bench.BenchmarkDAGOperations(bench.LinearDAG, 100)
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+	if perf.SerializedSize <= 0 || perf.CompressedSize <= 0 {
+		t.Fatalf("Expected both sizes to be reported, got raw=%d compressed=%d", perf.SerializedSize, perf.CompressedSize)
+	}
+	if perf.CompressedSize >= perf.SerializedSize {
+		t.Errorf("Expected JSON nodes to compress, raw=%d compressed=%d", perf.SerializedSize, perf.CompressedSize)
+	}
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/myipld"
+)
+
+func newEncryptor(t *testing.T, mode myipld.EncryptionMode) *myipld.Encryptor {
+	t.Helper()
+
+	key, err := myipld.NewEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	enc, err := myipld.NewEncryptor(key, mode)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+	return enc
+}
+
+func TestEncryptedBlockRoundTrip(t *testing.T) {
+	for _, mode := range []myipld.EncryptionMode{myipld.RandomNonceEncryption, myipld.ConvergentEncryption} {
+		t.Run(mode.String(), func(t *testing.T) {
+			enc := newEncryptor(t, mode)
+			node, _ := myipld.NewMyNode(map[string]interface{}{"content": "licensed-segment"})
+
+			cid, sealed, err := enc.SealNode(node)
+			if err != nil {
+				t.Fatalf("Failed to seal node: %v", err)
+			}
+			plain, _ := node.ToBytes()
+			if bytes.Contains(sealed, plain) {
+				t.Fatal("Sealed block contains the plaintext")
+			}
+
+			opened, err := enc.OpenNode(cid, sealed)
+			if err != nil {
+				t.Fatalf("Failed to open node: %v", err)
+			}
+			if opened.Cid != node.Cid {
+				t.Errorf("Expected %s after decryption, got %s", node.Cid, opened.Cid)
+			}
+		})
+	}
+}
+
+func TestConvergentEncryptionDedups(t *testing.T) {
+	key, _ := myipld.NewEncryptionKey()
+	encA, _ := myipld.NewEncryptor(key, myipld.ConvergentEncryption)
+	encB, _ := myipld.NewEncryptor(key, myipld.ConvergentEncryption)
+	node, _ := myipld.NewMyNode(map[string]interface{}{"content": "same-bytes"})
+
+	cidA, _, _ := encA.SealNode(node)
+	cidB, _, _ := encB.SealNode(node)
+	if cidA != cidB {
+		t.Errorf("Expected identical content to seal to the same CID, got %s and %s", cidA, cidB)
+	}
+
+	random, _ := myipld.NewEncryptor(key, myipld.RandomNonceEncryption)
+	cidC, _, _ := random.SealNode(node)
+	cidD, _, _ := random.SealNode(node)
+	if cidC == cidD {
+		t.Error("Expected random-nonce mode to produce distinct blocks")
+	}
+}
+
+func TestEncryptedBlockWrongKey(t *testing.T) {
+	for _, mode := range []myipld.EncryptionMode{myipld.RandomNonceEncryption, myipld.ConvergentEncryption} {
+		t.Run(mode.String(), func(t *testing.T) {
+			owner := newEncryptor(t, mode)
+			stranger := newEncryptor(t, mode)
+			node, _ := myipld.NewMyNode(map[string]interface{}{"content": "secret"})
+
+			cid, sealed, err := owner.SealNode(node)
+			if err != nil {
+				t.Fatalf("Failed to seal node: %v", err)
+			}
+			if _, err := stranger.OpenNode(cid, sealed); !errors.Is(err, myipld.ErrDecrypt) {
+				t.Fatalf("Expected ErrDecrypt with the wrong key, got %v", err)
+			}
+
+			sealed[len(sealed)-1] ^= 0xff
+			if _, err := owner.Open(sealed); !errors.Is(err, myipld.ErrDecrypt) {
+				t.Fatalf("Expected ErrDecrypt for a corrupted block, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedDAG(t *testing.T) {
+	root, nodes, err := bench.GenerateDAG(bench.StarDAG, 50)
+	if err != nil {
+		t.Fatalf("Failed to generate DAG: %v", err)
+	}
+
+	enc := newEncryptor(t, myipld.ConvergentEncryption)
+	bs := myipld.NewMemBlockstore()
+	rootCID, err := myipld.EncryptDAG(root, nodes, enc, bs)
+	if err != nil {
+		t.Fatalf("Failed to encrypt DAG: %v", err)
+	}
+	if bs.Len() != len(nodes) {
+		t.Fatalf("Expected %d encrypted blocks, got %d", len(nodes), bs.Len())
+	}
+
+	decRoot, decNodes, err := myipld.DecryptDAG(rootCID, bs, enc)
+	if err != nil {
+		t.Fatalf("Failed to decrypt DAG: %v", err)
+	}
+	if len(decNodes) != len(nodes) || len(decRoot.Links) != len(root.Links) {
+		t.Fatalf("Expected %d nodes and %d root links, got %d and %d", len(nodes), len(root.Links), len(decNodes), len(decRoot.Links))
+	}
+	if !bytes.Equal(decRoot.Data, root.Data) || decRoot.Cid != root.Cid {
+		t.Errorf("Expected the root back as %s, got %s", root.Cid, decRoot.Cid)
+	}
+
+	if _, _, err := myipld.DecryptDAG(rootCID, bs, newEncryptor(t, myipld.ConvergentEncryption)); !errors.Is(err, myipld.ErrDecrypt) {
+		t.Fatalf("Expected ErrDecrypt decoding with the wrong key, got %v", err)
+	}
+}
+
+func TestEncryptedSignedDAG(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	child, _ := myipld.NewMyNode(map[string]interface{}{"segment": 1})
+	if err := child.Sign(priv); err != nil {
+		t.Fatalf("Failed to sign child: %v", err)
+	}
+	root, _ := myipld.NewMyNodeWithLinks(map[string]interface{}{"title": "signed"}, []myipld.MyLink{{Name: "seg", Cid: child.Cid}})
+	if err := root.Sign(priv); err != nil {
+		t.Fatalf("Failed to sign root: %v", err)
+	}
+
+	for _, mode := range []myipld.EncryptionMode{myipld.RandomNonceEncryption, myipld.ConvergentEncryption} {
+		t.Run(mode.String(), func(t *testing.T) {
+			enc := newEncryptor(t, mode)
+			bs := myipld.NewMemBlockstore()
+			rootCID, err := myipld.EncryptDAG(root, []*myipld.MyNode{root, child}, enc, bs)
+			if err != nil {
+				t.Fatalf("Failed to encrypt DAG: %v", err)
+			}
+			decRoot, decNodes, err := myipld.DecryptDAG(rootCID, bs, enc)
+			if err != nil {
+				t.Fatalf("Failed to decrypt DAG: %v", err)
+			}
+			// the signatures used to be dropped on the way in
+			if decRoot.Cid != root.Cid || len(decNodes) != 2 || decNodes[1].Cid != child.Cid {
+				t.Fatalf("Expected the signed nodes back with their CIDs, got %s", decRoot.Cid)
+			}
+			for _, n := range decNodes {
+				if err := n.Verify(); err != nil {
+					t.Errorf("Expected %s to verify after decryption, got %v", n.Cid, err)
+				}
+			}
+		})
+	}
+}
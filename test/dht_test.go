@@ -0,0 +1,210 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/dht"
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/netsim"
+)
+
+func TestDHTKeyspace(t *testing.T) {
+	a := dht.PeerKey("peer-a")
+	if d := dht.Distance(a, a); d != (dht.Key{}) {
+		t.Errorf("Expected zero distance to self, got %s", d)
+	}
+	if cpl := dht.CommonPrefixLen(a, a); cpl != dht.KeyBits {
+		t.Errorf("Expected common prefix %d with self, got %d", dht.KeyBits, cpl)
+	}
+
+	b := a
+	b[0] ^= 0x10
+	if cpl := dht.CommonPrefixLen(a, b); cpl != 3 {
+		t.Errorf("Expected common prefix 3, got %d", cpl)
+	}
+}
+
+func TestRoutingTable(t *testing.T) {
+	self := dht.PeerKey("self")
+	table := dht.NewRoutingTable(self, 2)
+
+	for i := 0; i < 200; i++ {
+		table.Update(exchange.PeerID(fmt.Sprintf("peer-%d", i)))
+	}
+	// half the keyspace shares 0 bits with us, so bucket 0 is full long
+	// before we run out of peers
+	if table.Size() >= 200 || table.Size() == 0 {
+		t.Fatalf("Expected buckets to cap the table, got %d peers", table.Size())
+	}
+
+	target := dht.PeerKey("target")
+	nearest := table.NearestPeers(target, 5)
+	for i := 1; i < len(nearest); i++ {
+		if dht.Closer(dht.PeerKey(nearest[i]), dht.PeerKey(nearest[i-1]), target) {
+			t.Fatalf("NearestPeers not sorted by distance: %v", nearest)
+		}
+	}
+
+	table.Remove(nearest[0])
+	if again := table.NearestPeers(target, 1); again[0] == nearest[0] {
+		t.Error("Expected removed peer to be gone")
+	}
+}
+
+func TestDHTProvideAndFindOverMemNetwork(t *testing.T) {
+	nw := dht.NewMemNetwork()
+	nodes := make([]*dht.Node, 30)
+	for i := range nodes {
+		node, err := dht.NewNode(exchange.PeerID(fmt.Sprintf("peer-%d", i)), nw, dht.Config{K: 5, RPCTimeout: time.Second})
+		if err != nil {
+			t.Fatalf("Failed to create node: %v", err)
+		}
+		defer node.Close()
+		nodes[i] = node
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, node := range nodes[1:] {
+		if _, err := node.Bootstrap(ctx, nodes[0].ID()); err != nil {
+			t.Fatalf("Bootstrap of %s failed: %v", node.ID(), err)
+		}
+	}
+
+	cid := myipld.MyCID{Hash: [32]byte{1, 2, 3}}
+	key := dht.CIDKey(cid)
+	provided, err := nodes[7].Provide(ctx, key)
+	if err != nil {
+		t.Fatalf("Provide failed: %v", err)
+	}
+	if len(provided.Closest) != 5 {
+		t.Errorf("Expected the record on 5 peers, got %d", len(provided.Closest))
+	}
+
+	// announcements are fire and forget
+	time.Sleep(50 * time.Millisecond)
+
+	found, err := nodes[23].FindProviders(ctx, key)
+	if err != nil {
+		t.Fatalf("FindProviders failed: %v", err)
+	}
+	if len(found.Providers) != 1 || found.Providers[0] != nodes[7].ID() {
+		t.Errorf("Expected %s as provider, got %v", nodes[7].ID(), found.Providers)
+	}
+}
+
+func TestDHTProviderRecordsExpire(t *testing.T) {
+	sim := netsim.New(1)
+	sim.SetDefaultLink(netsim.LinkConfig{Latency: netsim.Constant(10 * time.Millisecond)})
+	nodes, err := bench.BuildDHT(sim, 20, dht.Config{ProviderTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to build DHT: %v", err)
+	}
+
+	key := dht.PeerKey("some content")
+	provide := nodes[3].NewProvide(key)
+	provide.Start()
+	sim.Run()
+	if provide.Err() != nil {
+		t.Fatalf("Provide failed: %v", provide.Err())
+	}
+
+	find := nodes[11].NewFindProviders(key)
+	find.Start()
+	sim.Run()
+	if find.Err() != nil {
+		t.Fatalf("Expected providers before the TTL, got %v", find.Err())
+	}
+
+	sim.RunFor(2 * time.Minute)
+	find = nodes[11].NewFindProviders(key)
+	find.Start()
+	sim.Run()
+	if !errors.Is(find.Err(), dht.ErrNoProviders) {
+		t.Errorf("Expected ErrNoProviders after the TTL, got %v", find.Err())
+	}
+}
+
+func TestDHTLookupSurvivesDeadPeers(t *testing.T) {
+	sim := netsim.New(2)
+	sim.SetDefaultLink(netsim.LinkConfig{Latency: netsim.Constant(10 * time.Millisecond)})
+	nodes, err := bench.BuildDHT(sim, 50, dht.Config{K: 8, RPCTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to build DHT: %v", err)
+	}
+
+	// cut a third of the network off, their rpcs time out
+	var dead []string
+	for _, node := range nodes[30:] {
+		dead = append(dead, string(node.ID()))
+	}
+	sim.Partition(dead)
+
+	lookup := nodes[0].NewFindNode(dht.PeerKey("anything"))
+	lookup.Start()
+	sim.Run()
+	if lookup.Err() != nil {
+		t.Fatalf("Lookup failed: %v", lookup.Err())
+	}
+	result := lookup.Result()
+	if result.Failures == 0 {
+		t.Error("Expected some rpcs to time out")
+	}
+	for _, id := range result.Closest {
+		for _, d := range dead {
+			if string(id) == d {
+				t.Errorf("Unreachable peer %s returned as closest", id)
+			}
+		}
+	}
+}
+
+func TestDHTLookupScaling(t *testing.T) {
+	link := netsim.LinkConfig{Latency: netsim.Uniform{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond}}
+
+	sizes := []int{10, 100, 1000, 10000}
+	if testing.Short() {
+		sizes = sizes[:3]
+	}
+	results, err := bench.DHTScaling(sizes, 20, 5, link)
+	if err != nil {
+		t.Fatalf("DHT scaling failed: %v", err)
+	}
+	for i, stats := range results {
+		if stats.Failures > 0 {
+			t.Errorf("%d peers: %d lookups did not find the provider", stats.Peers, stats.Failures)
+		}
+		// log2(1000) is ~10, kademlia should stay well under that
+		if stats.MaxHops > 6 {
+			t.Errorf("%d peers: expected few hops, got max %d", stats.Peers, stats.MaxHops)
+		}
+		t.Logf("%5d peers: %.2f hops (max %d), %.1f queries, mean latency %s",
+			stats.Peers, stats.MeanHops, stats.MaxHops, stats.MeanQueries, stats.MeanLatency)
+
+		// logarithmic, every 10x more peers adds a hop or so and never
+		// more than half of log2 of the network
+		if limit := math.Log2(float64(stats.Peers)) / 2; stats.MeanHops > limit {
+			t.Errorf("%d peers: expected at most %.1f mean hops, got %.2f", stats.Peers, limit, stats.MeanHops)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := results[i-1]
+		perDecade := (stats.MeanHops - prev.MeanHops) / math.Log10(float64(stats.Peers)/float64(prev.Peers))
+		if perDecade <= 0 || perDecade > 2 {
+			t.Errorf("%d to %d peers: expected mean hops to grow by 0 to 2 per 10x, got %.2f", prev.Peers, stats.Peers, perDecade)
+		}
+	}
+
+	again, _ := bench.BenchmarkDHTLookups(100, 20, 5, link)
+	if *again != *results[1] {
+		t.Error("Expected the same numbers for the same seed")
+	}
+}
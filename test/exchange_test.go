@@ -0,0 +1,138 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+)
+
+// newSwarm attaches numPeers peers to nw and spreads the DAG round robin
+// over every peer but the first, which is left empty to fetch
+func newSwarm(t *testing.T, nw exchange.Network, numPeers int, nodes []*myipld.MyNode) ([]*exchange.Peer, []exchange.PeerID) {
+	t.Helper()
+
+	peers := make([]*exchange.Peer, 0, numPeers)
+	ids := make([]exchange.PeerID, 0, numPeers)
+	for i := 0; i < numPeers; i++ {
+		id := exchange.PeerID(fmt.Sprintf("peer-%d", i))
+		p, err := exchange.NewPeer(id, myipld.NewMemBlockstore(), nw)
+		if err != nil {
+			t.Fatalf("Failed to create peer %s: %v", id, err)
+		}
+		t.Cleanup(func() { p.Close() })
+		peers = append(peers, p)
+		ids = append(ids, id)
+	}
+
+	for i, node := range nodes {
+		holder := peers[1+i%(numPeers-1)]
+		if err := myipld.PutNode(holder.Blockstore(), node); err != nil {
+			t.Fatalf("Failed to seed node: %v", err)
+		}
+	}
+	return peers, ids
+}
+
+// reachableNodes is the part of the DAG a fetch from root can see,
+// RandomDAG does not link every node from its root
+func reachableNodes(root *myipld.MyNode, nodes []*myipld.MyNode) []*myipld.MyNode {
+	nodeMap := make(map[myipld.MyCID]*myipld.MyNode, len(nodes))
+	for _, node := range nodes {
+		nodeMap[node.Cid] = node
+	}
+
+	visited := map[myipld.MyCID]bool{root.Cid: true}
+	reachable := []*myipld.MyNode{root}
+	for i := 0; i < len(reachable); i++ {
+		for _, link := range reachable[i].Links {
+			if !visited[link.Cid] {
+				visited[link.Cid] = true
+				reachable = append(reachable, nodeMap[link.Cid])
+			}
+		}
+	}
+	return reachable
+}
+
+func TestExchangeFetchesWholeDAG(t *testing.T) {
+	structures := []bench.DAGStructure{bench.LinearDAG, bench.BinaryTreeDAG, bench.StarDAG, bench.RandomDAG}
+
+	for _, numPeers := range []int{2, 5, 20, 50} {
+		for _, structure := range structures {
+			t.Run(fmt.Sprintf("%s-%dpeers", structure, numPeers), func(t *testing.T) {
+				root, nodes, err := bench.GenerateDAG(structure, 100)
+				if err != nil {
+					t.Fatalf("Failed to generate DAG: %v", err)
+				}
+
+				peers, ids := newSwarm(t, exchange.NewMemNetwork(), numPeers, nodes)
+				fetcher := peers[0]
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				stats, err := fetcher.Fetch(ctx, root.Cid, ids[1:])
+				if err != nil {
+					t.Fatalf("Fetch failed: %v", err)
+				}
+
+				reachable := reachableNodes(root, nodes)
+				for _, node := range reachable {
+					if _, err := myipld.GetNode(fetcher.Blockstore(), node.Cid); err != nil {
+						t.Fatalf("Fetcher is missing %s: %v", node.Cid, err)
+					}
+				}
+				if stats.Blocks != len(reachable) {
+					t.Errorf("Expected %d blocks fetched, got %d", len(reachable), stats.Blocks)
+				}
+			})
+		}
+	}
+}
+
+func TestExchangeMissingBlock(t *testing.T) {
+	root, nodes, _ := bench.GenerateDAG(bench.LinearDAG, 10)
+	peers, ids := newSwarm(t, exchange.NewMemNetwork(), 3, nodes)
+
+	// drop the leaf everywhere
+	for _, p := range peers {
+		p.Blockstore().Delete(nodes[0].Cid)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := peers[0].Fetch(ctx, root.Cid, ids[1:])
+	if !errors.Is(err, exchange.ErrBlockUnavailable) {
+		t.Fatalf("Expected ErrBlockUnavailable, got %v", err)
+	}
+}
+
+func TestExchangeRejectsCorruptBlocks(t *testing.T) {
+	root, nodes, _ := bench.GenerateDAG(bench.StarDAG, 10)
+	nw := exchange.NewMemNetwork()
+	peers, ids := newSwarm(t, nw, 3, nodes)
+
+	// a liar serving garbage for every block next to an honest full copy
+	liar, _ := exchange.NewPeer("liar", myipld.NewMemBlockstore(), nw)
+	defer liar.Close()
+	for _, node := range nodes {
+		liar.Blockstore().Put(node.Cid, []byte(`{"data":"garbage","links":null}`))
+		myipld.PutNode(peers[1].Blockstore(), node)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := peers[0].Fetch(ctx, root.Cid, append([]exchange.PeerID{"liar"}, ids[1:]...)); err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	for _, node := range nodes {
+		if _, err := myipld.GetNode(peers[0].Blockstore(), node.Cid); err != nil {
+			t.Fatalf("Fetcher stored a bad block for %s: %v", node.Cid, err)
+		}
+	}
+}
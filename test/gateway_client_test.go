@@ -0,0 +1,153 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ipld-benchmark/car"
+	"ipld-benchmark/gateway"
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+)
+
+func newTestServer(t *testing.T, h http.Handler) string {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// lyingGateway answers 200 with bytes that belong to no CID
+func lyingGateway(t *testing.T) string {
+	return newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "car" {
+			root, _ := car.ParseCid(r.URL.Path[len("/ipfs/"):])
+			// the root is fine, the extra block was never linked
+			cw, _ := car.NewWriter(w, root)
+			junk := []byte("junk")
+			junkCid, _ := myipld.ComputeSHA256(junk)
+			cw.Put(junkCid, junk)
+			return
+		}
+		w.Write([]byte("not the block you asked for"))
+	}))
+}
+
+func brokenGateway(t *testing.T) string {
+	return newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+}
+
+// slowGateway serves bs correctly after delay
+func slowGateway(t *testing.T, bs myipld.BlockGetter, delay time.Duration) string {
+	inner := gateway.NewServer(bs)
+	return newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			inner.ServeHTTP(w, r)
+		case <-r.Context().Done():
+		}
+	}))
+}
+
+func TestClientFailsOverAndVerifies(t *testing.T) {
+	good, title, _ := newGateway(t)
+	client, err := gateway.NewClient(gateway.ClientConfig{
+		Gateways: []string{lyingGateway(t), brokenGateway(t), good.URL},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// the client is a BlockGetter, media reads through it unchanged
+	fetched, err := media.LoadTitle(client, title.Node.Cid)
+	if err != nil {
+		t.Fatalf("Failed to load title through gateways: %v", err)
+	}
+	seg := fetched.Renditions[0].Asset.Segments[0]
+	if _, err := media.ReadSegment(client, seg.Cid); err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+
+	stats := client.Stats()
+	if stats[0].Failures != 1 || stats[1].Failures != 1 || stats[2].Wins == 0 {
+		t.Errorf("Expected one failure each before the good gateway took over, got %+v", stats)
+	}
+	// after that the good gateway is asked first
+	if stats[2].Requests <= stats[0].Requests {
+		t.Errorf("Expected failing gateways to be skipped, got %+v", stats)
+	}
+}
+
+func TestClientTimeoutAndRace(t *testing.T) {
+	_, title, bs := newGateway(t)
+	slow := slowGateway(t, bs, 2*time.Second)
+	fast := slowGateway(t, bs, 0)
+
+	client, _ := gateway.NewClient(gateway.ClientConfig{Gateways: []string{slow, fast}, Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	if _, err := client.Get(title.Node.Cid); err != nil {
+		t.Fatalf("Expected failover past the slow gateway, got %v", err)
+	}
+	if time.Since(start) > time.Second || client.Stats()[0].Failures != 1 {
+		t.Errorf("Expected the slow gateway to time out, took %s with %+v", time.Since(start), client.Stats())
+	}
+
+	racer, _ := gateway.NewClient(gateway.ClientConfig{Gateways: []string{slow, fast}, Race: 2})
+	start = time.Now()
+	if _, err := racer.Get(title.Node.Cid); err != nil {
+		t.Fatalf("Race failed: %v", err)
+	}
+	stats := racer.Stats()
+	if time.Since(start) > time.Second || stats[1].Wins != 1 || stats[0].Failures != 0 {
+		t.Errorf("Expected the fast gateway to win without blaming the slow one, got %+v", stats)
+	}
+}
+
+func TestClientFetchCAR(t *testing.T) {
+	good, title, bs := newGateway(t)
+	client, _ := gateway.NewClient(gateway.ClientConfig{Gateways: []string{lyingGateway(t), good.URL}})
+
+	all := make(map[myipld.MyCID]bool)
+	if err := collectAll(bs, title.Node.Cid, all); err != nil {
+		t.Fatalf("Failed to walk title: %v", err)
+	}
+
+	local := myipld.NewMemBlockstore()
+	n, err := client.FetchCAR(context.Background(), title.Node.Cid, gateway.CAROptions{Depth: -1}, local)
+	if err != nil {
+		t.Fatalf("FetchCAR failed: %v", err)
+	}
+	if n != len(all) || local.Len() != len(all) {
+		t.Errorf("Expected %d blocks, got %d stored %d", len(all), n, local.Len())
+	}
+	if client.Stats()[0].Failures != 1 {
+		t.Errorf("Expected the unlinked block to be rejected, got %+v", client.Stats())
+	}
+
+	manifests := myipld.NewMemBlockstore()
+	n, err = client.FetchCAR(context.Background(), title.Node.Cid, gateway.CAROptions{Scope: gateway.ScopeEntity, Depth: -1}, manifests)
+	if err != nil || n != 1+len(title.Renditions) {
+		t.Errorf("Expected the title and its manifests, got %d: %v", n, err)
+	}
+}
+
+func TestClientNotFound(t *testing.T) {
+	empty := newTestServer(t, gateway.NewServer(myipld.NewMemBlockstore()))
+	client, _ := gateway.NewClient(gateway.ClientConfig{Gateways: []string{empty, empty}})
+
+	missing, _ := myipld.ComputeSHA256([]byte("missing"))
+	if _, err := client.Get(missing); !errors.Is(err, myipld.ErrNotFound) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+
+	broken, _ := gateway.NewClient(gateway.ClientConfig{Gateways: []string{brokenGateway(t)}})
+	if _, err := broken.Get(missing); !errors.Is(err, gateway.ErrAllGatewaysFailed) {
+		t.Errorf("Expected all gateways to fail, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/car"
+	"ipld-benchmark/gateway"
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+)
+
+func newGateway(t *testing.T) (*httptest.Server, *media.Title, myipld.Blockstore) {
+	t.Helper()
+	bs := myipld.NewMemBlockstore()
+	title, err := bench.SyntheticTitle("gateway", 8*time.Second, 2*time.Second, playbackLadder, 1, bs)
+	if err != nil {
+		t.Fatalf("Failed to build title: %v", err)
+	}
+	srv := httptest.NewServer(gateway.NewServer(bs))
+	t.Cleanup(srv.Close)
+	return srv, title, bs
+}
+
+func gatewayGet(t *testing.T, url string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readCAR checks every block against its CID
+func readCAR(t *testing.T, r io.Reader) ([]myipld.MyCID, []myipld.MyCID) {
+	t.Helper()
+	cr, err := car.NewReader(r)
+	if err != nil {
+		t.Fatalf("Failed to read car header: %v", err)
+	}
+	var blocks []myipld.MyCID
+	for {
+		c, data, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read car: %v", err)
+		}
+		if got, _ := myipld.ComputeSHA256(data); got != c {
+			t.Fatalf("Block %s does not match its cid", c)
+		}
+		blocks = append(blocks, c)
+	}
+	return cr.Roots, blocks
+}
+
+func TestCARRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	root, _ := myipld.ComputeSHA256([]byte("root"))
+	cw, err := car.NewWriter(&buf, root)
+	if err != nil {
+		t.Fatalf("Failed to write header: %v", err)
+	}
+	for _, s := range []string{"root", "child"} {
+		c, _ := myipld.ComputeSHA256([]byte(s))
+		if err := cw.Put(c, []byte(s)); err != nil {
+			t.Fatalf("Failed to write block: %v", err)
+		}
+	}
+
+	roots, blocks := readCAR(t, &buf)
+	if len(roots) != 1 || roots[0] != root || len(blocks) != 2 {
+		t.Fatalf("Expected 1 root and 2 blocks, got %v and %d", roots, len(blocks))
+	}
+
+	parsed, err := car.ParseCid(car.ToCid(root).String())
+	if err != nil || parsed != root {
+		t.Errorf("Expected the CIDv1 to parse back, got %v", err)
+	}
+	if parsed, err := car.ParseCid(root.Hex()); err != nil || parsed != root {
+		t.Errorf("Expected the hex cid to parse, got %v", err)
+	}
+	if _, err := car.NewReader(strings.NewReader("\x05hello")); err == nil {
+		t.Error("Expected a garbage header to fail")
+	}
+	// {"roots": [2^62 of them]}, used to panic in makeslice
+	huge := "\x10\xa2\x65roots\x9b\x40\x00\x00\x00\x00\x00\x00\x00"
+	if _, err := car.NewReader(strings.NewReader(huge)); !errors.Is(err, car.ErrInvalidCAR) {
+		t.Errorf("Expected a header claiming 2^62 roots to be invalid, got %v", err)
+	}
+}
+
+func TestGatewayRawBlock(t *testing.T) {
+	srv, title, bs := newGateway(t)
+	url := srv.URL + "/ipfs/" + car.ToCid(title.Node.Cid).String()
+	want, _ := bs.Get(title.Node.Cid)
+
+	resp := gatewayGet(t, url, map[string]string{"Accept": gateway.ContentTypeRaw})
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, want) {
+		t.Fatalf("Expected the title block, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != gateway.ContentTypeRaw {
+		t.Errorf("Expected content type %s, got %s", gateway.ContentTypeRaw, ct)
+	}
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("Expected an immutable cache header, got %q", cc)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+
+	ranged := gatewayGet(t, url+"?format=raw", map[string]string{"Range": "bytes=0-9"})
+	part, _ := io.ReadAll(ranged.Body)
+	if ranged.StatusCode != http.StatusPartialContent || !bytes.Equal(part, want[:10]) {
+		t.Errorf("Expected 10 bytes of partial content, got %d with %d bytes", ranged.StatusCode, len(part))
+	}
+
+	for _, match := range []string{etag, `"other", ` + etag, "*"} {
+		cached := gatewayGet(t, url+"?format=raw", map[string]string{"If-None-Match": match})
+		if cached.StatusCode != http.StatusNotModified {
+			t.Errorf("Expected 304 for If-None-Match %s, got %d", match, cached.StatusCode)
+		}
+	}
+
+	// the hex form of MyCID works too
+	hex := gatewayGet(t, srv.URL+"/ipfs/"+title.Node.Cid.Hex()+"?format=raw", nil)
+	if hex.StatusCode != http.StatusOK {
+		t.Errorf("Expected the hex cid to be served, got %d", hex.StatusCode)
+	}
+}
+
+func TestGatewayCAR(t *testing.T) {
+	srv, title, bs := newGateway(t)
+	root := car.ToCid(title.Node.Cid).String()
+	accept := map[string]string{"Accept": gateway.ContentTypeCAR}
+
+	all := make(map[myipld.MyCID]bool)
+	if err := collectAll(bs, title.Node.Cid, all); err != nil {
+		t.Fatalf("Failed to walk title: %v", err)
+	}
+
+	resp := gatewayGet(t, srv.URL+"/ipfs/"+root, accept)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), gateway.ContentTypeCAR) {
+		t.Fatalf("Expected a car, got %s", resp.Header.Get("Content-Type"))
+	}
+	roots, blocks := readCAR(t, resp.Body)
+	if len(roots) != 1 || roots[0] != title.Node.Cid || len(blocks) != len(all) {
+		t.Errorf("Expected the whole title of %d blocks, got %d", len(all), len(blocks))
+	}
+
+	block := gatewayGet(t, srv.URL+"/ipfs/"+root+"?dag-scope=block", accept)
+	if _, blocks := readCAR(t, block.Body); len(blocks) != 1 {
+		t.Errorf("Expected 1 block for dag-scope=block, got %d", len(blocks))
+	}
+	if block.Header.Get("ETag") == resp.Header.Get("ETag") {
+		t.Error("Expected the ETag to depend on the scope")
+	}
+
+	etag := resp.Header.Get("ETag")
+	for match, status := range map[string]int{
+		etag:                           http.StatusNotModified,
+		`"other", ` + etag:             http.StatusNotModified,
+		`"other",` + etag + `, "more"`: http.StatusNotModified,
+		"W/" + etag:                    http.StatusNotModified,
+		"*":                            http.StatusNotModified,
+		`"other", "more"`:              http.StatusOK,
+	} {
+		cached := gatewayGet(t, srv.URL+"/ipfs/"+root, map[string]string{"Accept": gateway.ContentTypeCAR, "If-None-Match": match})
+		if cached.StatusCode != status {
+			t.Errorf("Expected %d for If-None-Match %s, got %d", status, match, cached.StatusCode)
+		}
+	}
+
+	shallow := gatewayGet(t, srv.URL+"/ipfs/"+root+"?depth=1", accept)
+	if _, blocks := readCAR(t, shallow.Body); len(blocks) != 1+len(title.Renditions) {
+		t.Errorf("Expected the title and %d manifests, got %d blocks", len(title.Renditions), len(blocks))
+	}
+
+	// the path blocks come first, then the segment and its chunks
+	r := title.Renditions[0]
+	seg := r.Asset.Segments[1]
+	path := "/" + media.RenditionName(r.Name) + "/" + media.SegmentName(seg.Index)
+	entity := gatewayGet(t, srv.URL+"/ipfs/"+root+path+"?dag-scope=entity", accept)
+	_, blocks = readCAR(t, entity.Body)
+	node, _ := myipld.GetNode(bs, seg.Cid)
+	if len(blocks) != 3+len(node.Links) || blocks[0] != title.Node.Cid || blocks[2] != seg.Cid {
+		t.Errorf("Expected title, manifest, segment and %d chunks, got %d blocks", len(node.Links), len(blocks))
+	}
+}
+
+func collectAll(bs myipld.BlockGetter, c myipld.MyCID, seen map[myipld.MyCID]bool) error {
+	if seen[c] {
+		return nil
+	}
+	seen[c] = true
+	node, err := myipld.GetNode(bs, c)
+	if err != nil {
+		return err
+	}
+	for _, link := range node.Links {
+		if err := collectAll(bs, link.Cid, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestGatewayErrors(t *testing.T) {
+	srv, title, _ := newGateway(t)
+	root := car.ToCid(title.Node.Cid).String()
+	missing, _ := myipld.ComputeSHA256([]byte("missing"))
+
+	cases := []struct {
+		path   string
+		accept string
+		status int
+	}{
+		{"/ipfs/" + root, "text/html", http.StatusNotAcceptable},
+		{"/ipfs/not-a-cid?format=raw", "", http.StatusBadRequest},
+		{"/ipfs/" + car.ToCid(missing).String() + "?format=raw", "", http.StatusNotFound},
+		{"/ipfs/" + root + "/no-such-link?format=car", "", http.StatusNotFound},
+		{"/ipfs/" + root + "/x?format=raw", "", http.StatusBadRequest},
+		{"/ipfs/" + root + "?format=car&dag-scope=some", "", http.StatusBadRequest},
+		{"/ipfs/" + root, gateway.ContentTypeCAR + "; version=2", http.StatusNotAcceptable},
+	}
+	for _, c := range cases {
+		resp := gatewayGet(t, srv.URL+c.path, map[string]string{"Accept": c.accept})
+		if resp.StatusCode != c.status {
+			t.Errorf("%s (%s): expected %d, got %d", c.path, c.accept, c.status, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(srv.URL+"/ipfs/"+root, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", resp.StatusCode)
+	}
+}
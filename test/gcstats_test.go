@@ -0,0 +1,75 @@
+package test
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"ipld-benchmark/bench"
+)
+
+var gcSink [][]byte
+
+func TestCollectMetricsGC(t *testing.T) {
+	m, err := bench.CollectMetrics(func() error {
+		for i := 0; i < 64; i++ {
+			b := make([]byte, 1<<20)
+			// untouched pages are not resident
+			for j := 0; j < len(b); j += 4096 {
+				b[j] = 1
+			}
+			gcSink = append(gcSink, b)
+		}
+		runtime.GC()
+		runtime.GC()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to collect metrics: %v", err)
+	}
+	if m.NumGC < 2 {
+		t.Errorf("Expected at least the 2 collections of the run, got %d", m.NumGC)
+	}
+	if m.GCPauseTotal <= 0 || m.GCPauseMax <= 0 || m.GCPauseMax > m.GCPauseTotal {
+		t.Errorf("Expected pauses with the longest within the total, got %v and %v", m.GCPauseTotal, m.GCPauseMax)
+	}
+	if m.GCPercentage <= 0 || m.GCPercentage > 100 {
+		t.Errorf("Expected a GC share between 0 and 100, got %g", m.GCPercentage)
+	}
+	if m.PeakHeapInuse < 64<<20 {
+		t.Errorf("Expected the 64MB kept to show in the peak heap, got %d", m.PeakHeapInuse)
+	}
+	if m.MemoryAlloc < 60<<20 {
+		t.Errorf("Expected the heap to grow by about 64MB, got %d", m.MemoryAlloc)
+	}
+	if _, err := os.Stat("/proc/self/status"); err == nil && m.PeakRSS < 64<<20 {
+		t.Errorf("Expected the 64MB in the peak RSS, got %d", m.PeakRSS)
+	}
+
+	// the heap shrinks, that used to wrap around to almost 2^64
+	m, err = bench.CollectMetrics(func() error {
+		gcSink = nil
+		runtime.GC()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to collect metrics: %v", err)
+	}
+	if m.MemoryAlloc != 0 || m.HeapObjects >= 0 {
+		t.Errorf("Expected no growth and fewer objects, got %d bytes and %d objects", m.MemoryAlloc, m.HeapObjects)
+	}
+
+	if m, err = bench.CollectMetrics(func() error { return nil }); err != nil || m.NumGC != 0 || m.GCPercentage != 0 {
+		t.Errorf("Expected no collections in an empty run, got %d and %g%% (%v)", m.NumGC, m.GCPercentage, err)
+	}
+}
+
+func TestDAGOperationsGC(t *testing.T) {
+	_, dag, err := bench.BenchmarkDAGOperationsWithOptions(bench.GenerateOptions{Structure: bench.RandomDAG, Nodes: 2000, Seed: 6}, nil)
+	if err != nil {
+		t.Fatalf("Failed to benchmark: %v", err)
+	}
+	if dag.PeakHeapInuse == 0 || dag.GCPauseMax > dag.GCPauseTotal {
+		t.Errorf("Expected the phases combined, got %+v", dag.PerformanceMetrics)
+	}
+}
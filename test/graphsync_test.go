@@ -0,0 +1,161 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+)
+
+func newPair(t *testing.T, nodes []*myipld.MyNode) (*exchange.Peer, *exchange.Peer) {
+	t.Helper()
+
+	nw := exchange.NewMemNetwork()
+	seeder, err := exchange.NewPeer("seeder", myipld.NewMemBlockstore(), nw)
+	if err != nil {
+		t.Fatalf("Failed to create seeder: %v", err)
+	}
+	fetcher, err := exchange.NewPeer("fetcher", myipld.NewMemBlockstore(), nw)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	t.Cleanup(func() {
+		seeder.Close()
+		fetcher.Close()
+	})
+
+	for _, node := range nodes {
+		myipld.PutNode(seeder.Blockstore(), node)
+	}
+	return seeder, fetcher
+}
+
+func TestGraphsyncFetchesSelectedDAG(t *testing.T) {
+	for _, structure := range []bench.DAGStructure{bench.LinearDAG, bench.BinaryTreeDAG, bench.StarDAG, bench.RandomDAG} {
+		t.Run(structure.String(), func(t *testing.T) {
+			root, nodes, _ := bench.GenerateDAG(structure, 200)
+			_, fetcher := newPair(t, nodes)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			stats, err := fetcher.GraphsyncFetch(ctx, root.Cid, "seeder", exchange.SelectAll())
+			if err != nil {
+				t.Fatalf("Graphsync fetch failed: %v", err)
+			}
+
+			reachable := reachableNodes(root, nodes)
+			if stats.Blocks != len(reachable) || stats.RoundTrips != 1 {
+				t.Errorf("Expected %d blocks in 1 round trip, got %d in %d", len(reachable), stats.Blocks, stats.RoundTrips)
+			}
+			for _, node := range reachable {
+				if _, err := myipld.GetNode(fetcher.Blockstore(), node.Cid); err != nil {
+					t.Fatalf("Fetcher is missing %s: %v", node.Cid, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGraphsyncDepthSelector(t *testing.T) {
+	root, nodes, _ := bench.GenerateDAG(bench.LinearDAG, 50)
+	_, fetcher := newPair(t, nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := fetcher.GraphsyncFetch(ctx, root.Cid, "seeder", exchange.Selector{MaxDepth: 4})
+	if err != nil {
+		t.Fatalf("Graphsync fetch failed: %v", err)
+	}
+	if stats.Blocks != 5 {
+		t.Errorf("Expected root plus 4 levels, got %d blocks", stats.Blocks)
+	}
+}
+
+func TestGraphsyncMissingBlock(t *testing.T) {
+	root, nodes, _ := bench.GenerateDAG(bench.LinearDAG, 10)
+	seeder, fetcher := newPair(t, nodes)
+	seeder.Blockstore().Delete(nodes[3].Cid)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := fetcher.GraphsyncFetch(ctx, root.Cid, "seeder", exchange.SelectAll()); !errors.Is(err, exchange.ErrBlockUnavailable) {
+		t.Fatalf("Expected ErrBlockUnavailable, got %v", err)
+	}
+}
+
+// pushyResponder answers every graphsync request with a block nobody asked for
+type pushyResponder struct {
+	tr exchange.Transport
+}
+
+func (r *pushyResponder) HandleMessage(from exchange.PeerID, msg exchange.Message) {
+	data := []byte(`{"data":"unrelated","links":null}`)
+	cid, _ := myipld.ComputeSHA256(data)
+	r.tr.Send(from, exchange.Message{
+		Type:      exchange.GraphsyncResponse,
+		Cid:       cid,
+		Data:      data,
+		RequestID: msg.RequestID,
+		Meta:      exchange.BlockMeta{Present: true},
+	})
+}
+
+func TestGraphsyncRejectsUnrequestedBlocks(t *testing.T) {
+	nw := exchange.NewMemNetwork()
+	responder := &pushyResponder{}
+	tr, _ := nw.Attach("pushy", responder)
+	responder.tr = tr
+	defer tr.Close()
+
+	fetcher, _ := exchange.NewPeer("fetcher", myipld.NewMemBlockstore(), nw)
+	defer fetcher.Close()
+
+	root, _ := myipld.NewMyNode(map[string]interface{}{"content": "root"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := fetcher.GraphsyncFetch(ctx, root.Cid, "pushy", exchange.SelectAll()); !errors.Is(err, exchange.ErrGraphsyncProtocol) {
+		t.Fatalf("Expected ErrGraphsyncProtocol, got %v", err)
+	}
+}
+
+func TestGraphsyncWireRoundTrip(t *testing.T) {
+	cid, _ := myipld.ComputeSHA256([]byte("root"))
+	messages := []exchange.Message{
+		{Type: exchange.GraphsyncRequest, Cid: cid, RequestID: 7, Selector: exchange.Selector{MaxDepth: 3, LinkPrefix: "left"}},
+		{Type: exchange.GraphsyncResponse, Cid: cid, RequestID: 7, Data: []byte("root"), Meta: exchange.BlockMeta{Depth: 2, Index: 9, Present: true}},
+		{Type: exchange.GraphsyncComplete, Cid: cid, RequestID: 7, Meta: exchange.BlockMeta{Status: exchange.GraphsyncPartial}},
+	}
+
+	for _, want := range messages {
+		got, err := exchange.UnmarshalMessage(exchange.MarshalMessage(want))
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", want.Type, err)
+		}
+		if got.RequestID != want.RequestID || got.Selector != want.Selector || got.Meta != want.Meta || string(got.Data) != string(want.Data) {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestCompareExchangeProtocols(t *testing.T) {
+	results, err := bench.CompareExchangeProtocols(30, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Comparison failed: %v", err)
+	}
+	if len(results) != 8 {
+		t.Fatalf("Expected 8 results, got %d", len(results))
+	}
+
+	for _, r := range results {
+		if r.Protocol == "graphsync" && r.RoundTrips != 1 {
+			t.Errorf("%s: expected graphsync to take 1 round trip, got %d", r.Structure, r.RoundTrips)
+		}
+		if r.Protocol == "bitswap" && r.Structure == bench.LinearDAG && r.RoundTrips < 30 {
+			t.Errorf("Expected bitswap to need a round trip per level on LinearDAG, got %d", r.RoundTrips)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/cli"
+	"ipld-benchmark/report"
+)
+
+func TestMannWhitney(t *testing.T) {
+	low := []float64{1, 2, 3, 4, 5, 6, 7, 8}
+	high := []float64{9, 10, 11, 12, 13, 14, 15, 16}
+	if u, p := bench.MannWhitney(low, high); u != 0 || p > 0.01 {
+		t.Errorf("Expected U 0 and a tiny p for separated samples, got %g %g", u, p)
+	}
+	if u, p := bench.MannWhitney(high, low); u != 64 || p > 0.01 {
+		t.Errorf("Expected U 64 the other way round, got %g %g", u, p)
+	}
+	odd := []float64{1, 4, 5, 8, 9, 12, 13, 16}
+	even := []float64{2, 3, 6, 7, 10, 11, 14, 15}
+	if _, p := bench.MannWhitney(odd, even); p < 0.5 {
+		t.Errorf("Expected overlapping samples to be no difference, got p %g", p)
+	}
+	same := []float64{5, 5, 5, 5}
+	if _, p := bench.MannWhitney(same, same); p != 1 {
+		t.Errorf("Expected all ties to give p 1, got %g", p)
+	}
+}
+
+// sampledRecord is a record whose operations all took around median
+func sampledRecord(run, commit, scenario string, median time.Duration) *report.Record {
+	rec := &report.Record{Run: run, Commit: commit, Scenario: scenario, Time: time.Now()}
+	times := make([]float64, 10)
+	for i := range times {
+		times[i] = float64(median) * (0.98 + 0.004*float64(i))
+	}
+	for _, op := range rec.Stats.Named() {
+		op.Stats.Samples = len(times)
+		op.Stats.Times = times
+		op.Stats.Time.Median = median
+	}
+	rec.Environment = report.CurrentEnvironment()
+	return rec
+}
+
+func TestHistoryCompare(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	runs := []struct {
+		id, commit string
+		median     time.Duration
+	}{
+		{"20260101-000000.000", "aaaa1111", time.Millisecond},
+		{"20260102-000000.000", "bbbb2222", time.Millisecond},
+		{"20260103-000000.000", "cccc3333", 2 * time.Millisecond},
+	}
+	for _, r := range runs {
+		err := report.AppendHistory(path,
+			sampledRecord(r.id, r.commit, "LinearDAG/100/gzip", r.median),
+			sampledRecord(r.id, r.commit, "StarDAG/100/gzip", r.median))
+		if err != nil {
+			t.Fatalf("Failed to append to the history: %v", err)
+		}
+	}
+
+	history, err := report.ReadHistory(path)
+	if err != nil {
+		t.Fatalf("Failed to read the history: %v", err)
+	}
+	if len(history) != 3 || len(history[0].Records) != 2 || history[1].Commit != "bbbb2222" {
+		t.Fatalf("Expected 3 runs of 2 records, got %d", len(history))
+	}
+	for ref, want := range map[string]string{"latest": "cccc3333", "previous": "bbbb2222", "aaaa": "aaaa1111", runs[1].id: "bbbb2222"} {
+		if run, err := report.FindRun(history, ref); err != nil || run.Commit != want {
+			t.Errorf("Expected %s to find %s, got %v", ref, want, err)
+		}
+	}
+	if _, err := report.FindRun(history, "abc"); !errors.Is(err, report.ErrNoRun) {
+		t.Errorf("Expected a short unknown ref to find nothing, got %v", err)
+	}
+
+	steady := report.Compare(history[0], history[1], report.CompareOptions{Threshold: 5})
+	if len(steady) != 12 {
+		t.Fatalf("Expected 6 operations of 2 scenarios, got %d", len(steady))
+	}
+	for _, c := range steady {
+		if c.Significant || c.Regression || c.Delta != 0 || !c.SameEnvironment {
+			t.Errorf("Expected no change between equal runs, got %+v", c)
+		}
+	}
+	for _, c := range report.Compare(history[1], history[2], report.CompareOptions{Threshold: 5}) {
+		if !c.Significant || !c.Regression || c.Delta != 100 {
+			t.Errorf("Expected twice as slow to be a regression, got %+v", c)
+		}
+	}
+
+	code, out, stderr := runCLI(t, "compare", "-history", path, "-format", "csv", "-fail-on-regression", "10%")
+	if code != cli.ExitRegression || !strings.Contains(stderr, "12 operation(s) more than 10% slower") {
+		t.Errorf("Expected the regression gate to fail, got %d %q", code, stderr)
+	}
+	if strings.Count(out, "\n") != 13 {
+		t.Errorf("Expected the comparison to print anyway, got %q", out)
+	}
+	if code, _, stderr := runCLI(t, "compare", "-history", path, "aaaa", "bbbb", "-fail-on-regression", "10"); code != cli.ExitOK {
+		t.Errorf("Expected equal runs to pass the gate, got %d %q", code, stderr)
+	}
+	if code, _, _ := runCLI(t, "compare", "-history", path, "-fail-on-regression", "-3"); code != cli.ExitUsage {
+		t.Errorf("Expected a negative threshold to be a usage error, got %d", code)
+	}
+
+	// only the second scenario moved to another machine
+	moved := sampledRecord("20260104-000000.000", "dddd4444", "StarDAG/100/gzip", 2*time.Millisecond)
+	moved.Environment.CPU = "another CPU"
+	if err := report.AppendHistory(path, sampledRecord("20260104-000000.000", "dddd4444", "LinearDAG/100/gzip", 2*time.Millisecond), moved); err != nil {
+		t.Fatalf("Failed to append to the history: %v", err)
+	}
+	_, _, stderr = runCLI(t, "compare", "-history", path)
+	if strings.Count(stderr, "differ between") != 1 || !strings.Contains(stderr, "StarDAG/100/gzip: the Go version or CPU differ") {
+		t.Errorf("Expected a warning naming the scenario that moved, got %q", stderr)
+	}
+
+	// bench appends a run of its own
+	code, _, stderr = runCLI(t, "bench", "-structure", "linear", "-nodes", "20", "-samples", "3", "-history", path, "-format", "json")
+	if code != cli.ExitOK {
+		t.Fatalf("Failed to bench into the history: %s", stderr)
+	}
+	history, _ = report.ReadHistory(path)
+	if latest := history[len(history)-1]; len(history) != 5 || latest.Commit == "" || len(latest.Records[0].Stats.Generate.Times) != 3 {
+		t.Errorf("Expected bench to append a run with its samples, got %d runs", len(history))
+	}
+}
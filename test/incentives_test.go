@@ -0,0 +1,149 @@
+package test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/netsim"
+)
+
+func TestLedgersTrackExchange(t *testing.T) {
+	root, nodes, err := bench.GenerateDAG(bench.BinaryTreeDAG, 50)
+	if err != nil {
+		t.Fatalf("Failed to generate DAG: %v", err)
+	}
+	peers, ids := newSwarm(t, exchange.NewMemNetwork(), 2, nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stats, err := peers[0].Fetch(ctx, root.Cid, ids[1:])
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	got, served := peers[0].Ledger(ids[1]), peers[1].Ledger(ids[0])
+	if got.BlocksReceived != stats.Blocks || int(got.BytesReceived) != stats.Bytes {
+		t.Errorf("Expected %d blocks / %d bytes received, ledger has %d / %d", stats.Blocks, stats.Bytes, got.BlocksReceived, got.BytesReceived)
+	}
+	if served.BytesSent != got.BytesReceived || served.BytesReceived != 0 {
+		t.Errorf("Expected both ledgers to agree, sent %d received %d", served.BytesSent, got.BytesReceived)
+	}
+	if served.DebtRatio() != float64(served.BytesSent) {
+		t.Errorf("Expected the debt ratio to be bytes sent over 1, got %f", served.DebtRatio())
+	}
+	if len(peers[1].Ledgers()) != 1 {
+		t.Errorf("Expected one ledger, got %d", len(peers[1].Ledgers()))
+	}
+}
+
+func TestSendProbability(t *testing.T) {
+	if p := exchange.SendProbability(0); p < 0.99 {
+		t.Errorf("Expected a peer with no debt to be served, got %f", p)
+	}
+	if p := exchange.SendProbability(2); math.Abs(p-0.5) > 1e-9 {
+		t.Errorf("Expected a coin flip at r = 2, got %f", p)
+	}
+	if p := exchange.SendProbability(4); p > 0.01 {
+		t.Errorf("Expected a deep debtor to be refused, got %f", p)
+	}
+}
+
+func TestChokerSlots(t *testing.T) {
+	now := netsim.Epoch
+	c := exchange.NewChoker(exchange.ChokerConfig{UploadSlots: 2}, 1)
+
+	ledgers := []*exchange.Ledger{{Peer: "a"}, {Peer: "b"}, {Peer: "c"}, {Peer: "d"}, {Peer: "e"}}
+	// two regular slots and the optimistic one are handed out right away
+	for i, l := range ledgers {
+		if allowed := c.Allow(l, now); allowed != (i < 3) {
+			t.Errorf("Peer %s: expected allowed %v, got %v", l.Peer, i < 3, allowed)
+		}
+	}
+
+	// d and e sent us the most, they keep regular slots
+	ledgers[3].BytesReceived = 1000
+	ledgers[4].BytesReceived = 500
+	for _, l := range ledgers {
+		l.Pending = 1
+	}
+	c.Rechoke(ledgers, now.Add(10*time.Second))
+	unchoked := c.Unchoked()
+	if len(unchoked) != 3 || !containsID(unchoked, "d") || !containsID(unchoked, "e") {
+		t.Errorf("Expected d, e and one optimistic unchoke, got %v", unchoked)
+	}
+}
+
+func containsID(ids []exchange.PeerID, id exchange.PeerID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFreeRiderScenario(t *testing.T) {
+	cfg := bench.IncentiveConfig{
+		Contributors: 6,
+		FreeRiders:   3,
+		ShardSize:    1 << 20,
+		Seed:         1,
+		Link:         netsim.LinkConfig{Latency: netsim.Constant(20 * time.Millisecond)},
+		Upload:       256 << 10,
+		Grace:        256 << 10,
+	}
+	results, err := bench.CompareIncentives(cfg, []string{"none", "choke", "debt-ratio"})
+	if err != nil {
+		t.Fatalf("Scenario failed: %v", err)
+	}
+	none, choke, debt := results[0], results[1], results[2]
+
+	if none.Incomplete != 0 || none.FreeRiderTime > 2*none.ContributorTime {
+		t.Errorf("Expected free-riders to keep up without a policy, %s against %s", none.FreeRiderTime, none.ContributorTime)
+	}
+	if choke.ContributorTime >= none.ContributorTime || choke.FreeRiderTime <= choke.ContributorTime {
+		t.Errorf("Expected choking to favour contributors, %s (none %s) against free-riders %s",
+			choke.ContributorTime, none.ContributorTime, choke.FreeRiderTime)
+	}
+	// past the grace bytes a free-rider's debt only grows
+	if debt.Incomplete != cfg.FreeRiders {
+		t.Errorf("Expected %d free-riders to starve, got %d incomplete", cfg.FreeRiders, debt.Incomplete)
+	}
+	for _, p := range debt.Peers {
+		if !p.FreeRider && (!p.Complete || p.ShareRatio < 0.5) {
+			t.Errorf("Expected contributor %s to finish sharing, got complete %v ratio %.2f", p.Peer, p.Complete, p.ShareRatio)
+		}
+	}
+
+	cfg.Policy = "choke"
+	again, _ := bench.SimulateIncentives(cfg)
+	if again.VirtualTime != choke.VirtualTime {
+		t.Error("Expected the same result for the same seed")
+	}
+}
+
+func TestFairShare(t *testing.T) {
+	result, err := bench.FairShare(bench.IncentiveConfig{
+		Contributors: 5,
+		ShardSize:    512 << 10,
+		Seed:         2,
+		Link:         netsim.LinkConfig{Latency: netsim.Constant(20 * time.Millisecond)},
+		Upload:       128 << 10,
+		Policy:       "choke",
+	})
+	if err != nil {
+		t.Fatalf("Scenario failed: %v", err)
+	}
+	if result.Incomplete != 0 || result.ContributorFairness < 0.9 {
+		t.Errorf("Expected everyone done with a fair share, got %d incomplete and index %.3f", result.Incomplete, result.ContributorFairness)
+	}
+	for _, p := range result.Peers {
+		if math.Abs(p.ShareRatio-1) > 0.01 {
+			t.Errorf("Expected %s to give as much as it got, ratio %.3f", p.Peer, p.ShareRatio)
+		}
+	}
+}
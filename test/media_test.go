@@ -0,0 +1,136 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+)
+
+func fakeMedia(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestImportFixedDurationSegments(t *testing.T) {
+	data := fakeMedia(1<<20, 1)
+	bs := myipld.NewMemBlockstore()
+
+	asset, err := media.Import(bytes.NewReader(data), int64(len(data)), media.ImportOptions{
+		Name:            "clip.ts",
+		Duration:        10 * time.Second,
+		SegmentDuration: 4 * time.Second,
+		ChunkSize:       64 << 10,
+	}, bs)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	if len(asset.Segments) != 3 {
+		t.Fatalf("Expected 3 segments, got %d", len(asset.Segments))
+	}
+	if last := asset.Segments[2]; last.Duration != 2*time.Second || last.Start != 8*time.Second {
+		t.Errorf("Expected the last segment at 8s for 2s, got %s for %s", last.Start, last.Duration)
+	}
+
+	var joined []byte
+	for _, seg := range asset.Segments {
+		got, err := media.ReadSegment(bs, seg.Cid)
+		if err != nil {
+			t.Fatalf("Failed to read segment %d: %v", seg.Index, err)
+		}
+		if int64(len(got)) != seg.Length {
+			t.Errorf("Segment %d: expected %d bytes, got %d", seg.Index, seg.Length, len(got))
+		}
+		joined = append(joined, got...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Error("Segments do not add up to the original file")
+	}
+
+	loaded, err := media.LoadAsset(bs, asset.Manifest.Cid)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if loaded.Name != "clip.ts" || len(loaded.Segments) != 3 || loaded.Segments[1] != asset.Segments[1] {
+		t.Errorf("Loaded asset differs: %+v", loaded.Segments)
+	}
+
+	if seg, ok := asset.SegmentAt(5 * time.Second); !ok || seg.Index != 1 {
+		t.Errorf("Expected segment 1 at 5s, got %d", seg.Index)
+	}
+	if _, ok := asset.SegmentAt(10 * time.Second); ok {
+		t.Error("Expected no segment past the end")
+	}
+}
+
+func TestImportSuppliedCuts(t *testing.T) {
+	data := fakeMedia(5000, 2)
+	cuts := []media.Cut{
+		{Time: 0, Offset: 0},
+		{Time: 2500 * time.Millisecond, Offset: 1800},
+		{Time: 6 * time.Second, Offset: 3100},
+	}
+
+	asset, err := media.Import(bytes.NewReader(data), int64(len(data)), media.ImportOptions{
+		Duration: 9 * time.Second,
+		Cuts:     cuts,
+	}, myipld.NewMemBlockstore())
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	want := []time.Duration{2500 * time.Millisecond, 3500 * time.Millisecond, 3 * time.Second}
+	for i, seg := range asset.Segments {
+		if seg.Duration != want[i] {
+			t.Errorf("Segment %d: expected %s, got %s", i, want[i], seg.Duration)
+		}
+	}
+	if asset.TargetDuration != 3500*time.Millisecond {
+		t.Errorf("Expected target duration 3.5s, got %s", asset.TargetDuration)
+	}
+
+	bad := []media.Cut{{Time: 0, Offset: 0}, {Time: time.Second, Offset: 9000}}
+	_, err = media.Import(bytes.NewReader(data), int64(len(data)), media.ImportOptions{Duration: 9 * time.Second, Cuts: bad}, myipld.NewMemBlockstore())
+	if !errors.Is(err, media.ErrInvalidCuts) {
+		t.Errorf("Expected ErrInvalidCuts, got %v", err)
+	}
+}
+
+func TestWriteHLS(t *testing.T) {
+	data := fakeMedia(3000, 3)
+	asset, err := media.Import(bytes.NewReader(data), int64(len(data)), media.ImportOptions{
+		Duration:        5500 * time.Millisecond,
+		SegmentDuration: 2 * time.Second,
+	}, myipld.NewMemBlockstore())
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	var out strings.Builder
+	if err := media.WriteHLS(&out, asset, ""); err != nil {
+		t.Fatalf("WriteHLS failed: %v", err)
+	}
+	playlist := out.String()
+
+	for _, line := range []string{
+		"#EXTM3U",
+		"#EXT-X-TARGETDURATION:2",
+		"#EXTINF:1.500,",
+		"/ipfs/" + asset.Segments[0].Cid.Hex(),
+		"#EXT-X-ENDLIST",
+	} {
+		if !strings.Contains(playlist, line+"\n") {
+			t.Errorf("Expected %q in playlist:\n%s", line, playlist)
+		}
+	}
+	if n := strings.Count(playlist, "#EXTINF"); n != 3 {
+		t.Errorf("Expected 3 segments in playlist, got %d", n)
+	}
+}
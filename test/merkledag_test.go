@@ -0,0 +1,48 @@
+package test
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/cli"
+)
+
+func TestHeadToHead(t *testing.T) {
+	ro := bench.RunnerOptions{Samples: 3}
+	for _, s := range bench.Structures() {
+		impls, err := bench.HeadToHead(bench.GenerateOptions{Structure: s, Nodes: 30, Seed: 9}, ro)
+		if err != nil {
+			t.Fatalf("Failed to run %s head to head: %v", s, err)
+		}
+		if len(impls) != 2 || impls[0].Name != "myipld" || impls[1].Name != "merkledag" {
+			t.Fatalf("Expected myipld then merkledag, got %d implementations", len(impls))
+		}
+		for _, impl := range impls {
+			if impl.Blocks != 30 {
+				t.Errorf("Expected %s %s to store 30 blocks, got %d", s, impl.Name, impl.Blocks)
+			}
+			if impl.BlockBytes <= 0 || impl.MaxBlock <= 0 || impl.Generate.Time <= 0 || impl.Deserialize.Allocs <= 0 {
+				t.Errorf("Expected %s %s to be measured, got %+v", s, impl.Name, impl)
+			}
+		}
+	}
+}
+
+func TestCLIVersus(t *testing.T) {
+	code, out, stderr := runCLI(t, "versus", "-structure", "binary", "-nodes", "20,40", "-seed", "2", "-samples", "3", "-format", "csv")
+	if code != cli.ExitOK {
+		t.Fatalf("Failed to run versus: %s", stderr)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil || len(rows) != 5 {
+		t.Fatalf("Expected a header and 2 rows per size, got %d (%v)", len(rows), err)
+	}
+	if rows[0][0] != "Scenario" || rows[0][1] != "Name" {
+		t.Fatalf("Expected Scenario and Name first, got %v", rows[0])
+	}
+	if rows[1][0] != "BinaryTreeDAG/20" || rows[1][1] != "myipld" || rows[2][1] != "merkledag" || rows[4][0] != "BinaryTreeDAG/40" {
+		t.Errorf("Expected both implementations for every size, got %v", rows[1:])
+	}
+}
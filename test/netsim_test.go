@@ -0,0 +1,169 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/netsim"
+)
+
+func TestSimClockOrdersEvents(t *testing.T) {
+	sim := netsim.New(1)
+
+	var order []int
+	sim.Schedule(30*time.Millisecond, func() { order = append(order, 3) })
+	sim.Schedule(10*time.Millisecond, func() { order = append(order, 1) })
+	sim.Schedule(10*time.Millisecond, func() { order = append(order, 2) })
+	stop := sim.AfterFunc(20*time.Millisecond, func() { order = append(order, 99) })
+	stop()
+	sim.Run()
+
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Fatalf("Expected events 1,2,3, got %v", order)
+	}
+	if sim.Elapsed() != 30*time.Millisecond {
+		t.Errorf("Expected virtual clock at 30ms, got %s", sim.Elapsed())
+	}
+}
+
+func TestSimLinkModel(t *testing.T) {
+	sim := netsim.New(1)
+	// 1000 bytes per second and 50ms latency: a 500 byte message takes
+	// 500ms to put on the wire plus 50ms to arrive
+	sim.SetLink("a", "b", netsim.LinkConfig{Latency: netsim.Constant(50 * time.Millisecond), Bandwidth: 1000})
+
+	var arrivals []time.Duration
+	for i := 0; i < 2; i++ {
+		sim.Send("a", "b", 500, func() { arrivals = append(arrivals, sim.Elapsed()) })
+	}
+	sim.Run()
+
+	if len(arrivals) != 2 || arrivals[0] != 550*time.Millisecond || arrivals[1] != 1050*time.Millisecond {
+		t.Fatalf("Expected arrivals at 550ms and 1050ms, got %v", arrivals)
+	}
+}
+
+func TestSimPartitionAndLoss(t *testing.T) {
+	sim := netsim.New(1)
+	delivered := 0
+
+	sim.Partition([]string{"a"}, []string{"b"})
+	sim.Send("a", "b", 10, func() { delivered++ })
+	sim.Heal()
+	sim.Send("a", "b", 10, func() { delivered++ })
+	sim.SetLink("a", "c", netsim.LinkConfig{Loss: 1})
+	sim.Send("a", "c", 10, func() { delivered++ })
+	sim.Run()
+
+	stats := sim.Stats()
+	if delivered != 1 || stats.MessagesDropped != 2 {
+		t.Fatalf("Expected 1 delivered and 2 dropped, got %d and %d", delivered, stats.MessagesDropped)
+	}
+}
+
+func TestSimulatedFetchIsReproducible(t *testing.T) {
+	root, nodes, err := bench.GenerateDAG(bench.BinaryTreeDAG, 500)
+	if err != nil {
+		t.Fatalf("Failed to generate DAG: %v", err)
+	}
+
+	cfg := bench.SimFetchConfig{
+		NumPeers: 20,
+		Seed:     42,
+		Link: netsim.LinkConfig{
+			Latency:   netsim.Normal{Mean: 20 * time.Millisecond, StdDev: 5 * time.Millisecond},
+			Bandwidth: 1 << 20,
+		},
+	}
+	first, err := bench.SimulateFetch(root, nodes, cfg)
+	if err != nil {
+		t.Fatalf("Simulated fetch failed: %v", err)
+	}
+	second, _ := bench.SimulateFetch(root, nodes, cfg)
+	if first.VirtualTime != second.VirtualTime || first.Network != second.Network {
+		t.Fatalf("Expected identical runs for one seed, got %s and %s", first.VirtualTime, second.VirtualTime)
+	}
+	if first.Fetch.Blocks != len(nodes) {
+		t.Errorf("Expected %d blocks, got %d", len(nodes), first.Fetch.Blocks)
+	}
+
+	cfg.Seed = 7
+	other, _ := bench.SimulateFetch(root, nodes, cfg)
+	if other.VirtualTime == first.VirtualTime {
+		t.Errorf("Expected a different seed to change the timing, both took %s", first.VirtualTime)
+	}
+}
+
+// the acceptance scenario, a 10k node BinaryTreeDAG across 100 peers
+func TestSimulatedFetchAtScale(t *testing.T) {
+	if testing.Short() {
+		t.Skip("10000 nodes over 100 peers")
+	}
+	root, nodes, err := bench.GenerateWithOptions(bench.GenerateOptions{Structure: bench.BinaryTreeDAG, Nodes: 10000, Seed: 42})
+	if err != nil {
+		t.Fatalf("Failed to generate DAG: %v", err)
+	}
+	cfg := bench.SimFetchConfig{
+		NumPeers: 100,
+		Seed:     42,
+		Link: netsim.LinkConfig{
+			Latency:   netsim.Normal{Mean: 20 * time.Millisecond, StdDev: 5 * time.Millisecond},
+			Bandwidth: 1 << 20,
+		},
+	}
+	first, err := bench.SimulateFetch(root, nodes, cfg)
+	if err != nil {
+		t.Fatalf("Simulated fetch failed: %v", err)
+	}
+	second, err := bench.SimulateFetch(root, nodes, cfg)
+	if err != nil {
+		t.Fatalf("Simulated fetch failed: %v", err)
+	}
+	if first.VirtualTime != second.VirtualTime {
+		t.Errorf("Expected the same virtual time for one seed, got %s and %s", first.VirtualTime, second.VirtualTime)
+	}
+	if first.Network.MessagesSent != second.Network.MessagesSent || first.Network.MessagesDelivered != second.Network.MessagesDelivered || first.Network.MessagesDropped != second.Network.MessagesDropped {
+		t.Errorf("Expected the same messages for one seed, got %+v and %+v", first.Network, second.Network)
+	}
+	if first.Fetch.Blocks != len(nodes) {
+		t.Errorf("Expected %d blocks, got %d", len(nodes), first.Fetch.Blocks)
+	}
+}
+
+func TestSimulatedFetchSurvivesLoss(t *testing.T) {
+	root, nodes, _ := bench.GenerateDAG(bench.StarDAG, 100)
+
+	result, err := bench.SimulateFetch(root, nodes, bench.SimFetchConfig{
+		NumPeers:     5,
+		Seed:         3,
+		Link:         netsim.LinkConfig{Latency: netsim.Constant(10 * time.Millisecond), Loss: 0.1},
+		RetryTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Simulated fetch over a lossy network failed: %v", err)
+	}
+	if result.Network.MessagesDropped == 0 {
+		t.Error("Expected some messages to be dropped")
+	}
+}
+
+func TestSimulatedGraphsyncIsFaster(t *testing.T) {
+	root, nodes, _ := bench.GenerateDAG(bench.LinearDAG, 100)
+	cfg := bench.SimFetchConfig{NumPeers: 2, Seed: 1, Link: netsim.LinkConfig{Latency: netsim.Constant(10 * time.Millisecond)}}
+
+	bitswap, err := bench.SimulateFetch(root, nodes, cfg)
+	if err != nil {
+		t.Fatalf("Bitswap fetch failed: %v", err)
+	}
+	cfg.Protocol = "graphsync"
+	graphsync, err := bench.SimulateFetch(root, nodes, cfg)
+	if err != nil {
+		t.Fatalf("Graphsync fetch failed: %v", err)
+	}
+
+	// 100 levels at one 20ms round trip each against a single round trip
+	if bitswap.VirtualTime < 2*time.Second || graphsync.VirtualTime != 20*time.Millisecond {
+		t.Errorf("Expected ~2s for bitswap and 20ms for graphsync, got %s and %s", bitswap.VirtualTime, graphsync.VirtualTime)
+	}
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/media"
+	"ipld-benchmark/netsim"
+	"ipld-benchmark/player"
+)
+
+func TestPlayerStallAccounting(t *testing.T) {
+	t0 := netsim.Epoch
+	ladder := []media.Rendition{{Name: "low", Bandwidth: 1000}, {Name: "high", Bandwidth: 2000}}
+	p := player.New(player.Config{StartupBuffer: 2 * time.Second, ABR: player.FixedABR(0)}, ladder, 6*time.Second, t0)
+
+	seg := func(i int) media.Segment {
+		return media.Segment{Index: i, Start: time.Duration(i) * 2 * time.Second, Duration: 2 * time.Second}
+	}
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+
+	p.Loaded(at(time.Second), seg(0), 0, 500, time.Second)
+	if p.State() != player.Playing {
+		t.Fatalf("Expected playback to start, got %s", p.State())
+	}
+	// the buffer runs dry at 3s, the next segment lands at 5s
+	p.Loaded(at(5*time.Second), seg(1), 1, 500, 4*time.Second)
+	p.Loaded(at(5500*time.Millisecond), seg(2), 1, 500, 500*time.Millisecond)
+	stats := p.Finish(at(5500 * time.Millisecond))
+
+	if stats.TimeToFirstFrame != time.Second {
+		t.Errorf("Expected TTFF 1s, got %s", stats.TimeToFirstFrame)
+	}
+	if stats.Rebuffers != 1 || stats.StallTime != 2*time.Second {
+		t.Errorf("Expected 1 rebuffer of 2s, got %d for %s", stats.Rebuffers, stats.StallTime)
+	}
+	if stats.BitrateSwitches != 1 {
+		t.Errorf("Expected 1 switch, got %d", stats.BitrateSwitches)
+	}
+	// 6s of media + 1s startup + 2s stalled
+	if stats.SessionTime != 9*time.Second {
+		t.Errorf("Expected a 9s session, got %s", stats.SessionTime)
+	}
+}
+
+func TestThroughputABR(t *testing.T) {
+	abr := player.ThroughputABR{Safety: 0.5}
+	if got := abr.Choose(media.DefaultLadder, 0, 0, 0); got != 0 {
+		t.Errorf("Expected the lowest rendition without a measurement, got %d", got)
+	}
+	// half of 6Mbit/s fits 2.8Mbit/s 720p but not 5Mbit/s 1080p
+	if got := abr.Choose(media.DefaultLadder, 6_000_000, 0, 0); media.DefaultLadder[got].Name != "720p" {
+		t.Errorf("Expected 720p, got %s", media.DefaultLadder[got].Name)
+	}
+}
+
+var playbackLadder = []media.Rendition{
+	{Name: "240p", Width: 426, Height: 240, Bandwidth: 40_000},
+	{Name: "480p", Width: 854, Height: 480, Bandwidth: 120_000},
+	{Name: "1080p", Width: 1920, Height: 1080, Bandwidth: 400_000},
+}
+
+func TestSimulatedPlayback(t *testing.T) {
+	cfg := bench.PlaybackConfig{
+		NumPeers: 4,
+		Seed:     1,
+		Link:     netsim.LinkConfig{Latency: netsim.Constant(20 * time.Millisecond), Bandwidth: 1 << 20},
+	}
+
+	fast, err := bench.BenchmarkPlayback(20*time.Second, 2*time.Second, playbackLadder, cfg)
+	if err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	if fast.Rebuffers != 0 || fast.Segments != 10 {
+		t.Errorf("Expected 10 segments without stalls, got %d segments and %d rebuffers", fast.Segments, fast.Rebuffers)
+	}
+	if fast.BitrateSwitches == 0 || fast.AverageBitrate <= float64(playbackLadder[0].Bandwidth) {
+		t.Errorf("Expected ABR to climb the ladder, got %d switches at %.0fbps", fast.BitrateSwitches, fast.AverageBitrate)
+	}
+	if fast.TimeToFirstFrame <= 0 || fast.TimeToFirstFrame > time.Second {
+		t.Errorf("Expected a quick start, got %s", fast.TimeToFirstFrame)
+	}
+
+	again, _ := bench.BenchmarkPlayback(20*time.Second, 2*time.Second, playbackLadder, cfg)
+	if again.TimeToFirstFrame != fast.TimeToFirstFrame || again.SessionTime != fast.SessionTime {
+		t.Error("Expected the same playback for the same seed")
+	}
+
+	// even 240p needs 5KB/s, at 4KB/s the player has to stall
+	cfg.Link.Bandwidth = 4 << 10
+	cfg.Player = player.Config{ABR: player.FixedABR(0)}
+	slow, err := bench.BenchmarkPlayback(20*time.Second, 2*time.Second, playbackLadder, cfg)
+	if err != nil {
+		t.Fatalf("Playback failed: %v", err)
+	}
+	if slow.Rebuffers == 0 || slow.StallTime == 0 {
+		t.Errorf("Expected stalls on a slow link, got %d rebuffers", slow.Rebuffers)
+	}
+	if slow.SessionTime < 20*time.Second+slow.StallTime {
+		t.Errorf("Session %s shorter than media plus stalls %s", slow.SessionTime, slow.StallTime)
+	}
+
+	cfg.Protocol = "graphsync"
+	if _, err := bench.BenchmarkPlayback(10*time.Second, 2*time.Second, playbackLadder, cfg); err != nil {
+		t.Fatalf("Graphsync playback failed: %v", err)
+	}
+}
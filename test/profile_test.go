@@ -0,0 +1,75 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/cli"
+)
+
+func TestProfiledDAGOperations(t *testing.T) {
+	opts := bench.GenerateOptions{Structure: bench.LinearDAG, Nodes: 2000, Seed: 4}
+	if _, _, profiles, err := bench.BenchmarkDAGOperationsProfiled(opts, nil, nil); err != nil || profiles != nil {
+		t.Fatalf("Expected no profiles without a profiler, got %v (%v)", profiles, err)
+	}
+
+	dir := t.TempDir()
+	prof := &bench.Profiler{Dir: dir, Name: "LinearDAG/2000/none", Top: 3, MinTime: 200 * time.Millisecond}
+	_, _, profiles, err := bench.BenchmarkDAGOperationsProfiled(opts, nil, prof)
+	if err != nil {
+		t.Fatalf("Failed to profile: %v", err)
+	}
+	phases := map[string]bench.PhaseProfile{
+		"generate":    profiles.Generate,
+		"traverse":    profiles.Traverse,
+		"serialize":   profiles.Serialize,
+		"deserialize": profiles.Deserialize,
+	}
+	for phase, pp := range phases {
+		if len(pp.Files) != 6 {
+			t.Errorf("Expected 6 files for %s, got %v", phase, pp.Files)
+		}
+		for _, kind := range []string{"cpu.pprof", "heap.pprof", "allocs.pprof", "block.pprof", "mutex.pprof", "trace.out"} {
+			path := filepath.Join(dir, "LinearDAG-2000-none."+phase+"."+kind)
+			if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+				t.Errorf("Expected a non empty %s (%v)", path, err)
+			}
+		}
+		// a phase this short used to end before the first sample
+		if len(pp.TopCPU) == 0 || len(pp.TopCPU) > 3 || len(pp.TopAllocs) == 0 || len(pp.TopAllocs) > 3 {
+			t.Errorf("Expected 1 to 3 hot functions for %s, got %d and %d", phase, len(pp.TopCPU), len(pp.TopAllocs))
+		}
+		if pp.Runs < 1 {
+			t.Errorf("Expected %s to run, got %d runs", phase, pp.Runs)
+		}
+		for _, h := range append(pp.TopCPU, pp.TopAllocs...) {
+			if strings.HasPrefix(h.Function, "runtime/pprof.") || strings.HasPrefix(h.Function, "github.com/google/pprof/") {
+				t.Errorf("Expected the profilers to be left out of %s, got %s", phase, h)
+			}
+		}
+	}
+
+	top := profiles.Generate.TopAllocs
+	if top[0].Share <= 0 || top[0].Unit != "bytes" || !strings.Contains(top[0].String(), top[0].Function) {
+		t.Errorf("Expected a share in bytes, got %+v", top[0])
+	}
+}
+
+func TestCLIBenchProfile(t *testing.T) {
+	dir := t.TempDir()
+	code, out, stderr := runCLI(t, "bench", "-structure", "star", "-nodes", "50", "-samples", "2", "-history", "", "-profile", dir, "-profile-top", "2", "-profile-time", "100ms", "-format", "text")
+	if code != cli.ExitOK {
+		t.Fatalf("Failed to bench with -profile: %s", stderr)
+	}
+	if !strings.Contains(out, "Profiles.Traverse.TopAllocs  2") || !strings.Contains(out, "bench.BenchmarkTraversal") {
+		t.Errorf("Expected the hot functions of traverse in the report, got\n%s", out)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "StarDAG-50-gzip.*"))
+	if len(files) != 24 {
+		t.Errorf("Expected 6 files for each of the 4 phases, got %d", len(files))
+	}
+}
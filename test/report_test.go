@@ -0,0 +1,141 @@
+package test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/cli"
+	"ipld-benchmark/report"
+)
+
+func sampleRecords() []interface{} {
+	rec := func(structure string, total time.Duration) *report.Record {
+		r := &report.Record{Scenario: structure + "/10/gzip", Structure: structure, Nodes: 10, Codec: "gzip"}
+		r.Metrics.TotalTime = total
+		r.Metrics.NodesPerSecond = 12345.678
+		r.Metrics.MaxDepth = 4
+		r.Environment = report.CurrentEnvironment()
+		return r
+	}
+	return []interface{}{rec("LinearDAG", 1500*time.Microsecond), rec("StarDAG", 2*time.Millisecond)}
+}
+
+func TestReportFormats(t *testing.T) {
+	records := sampleRecords()
+	write := func(format string, opts report.Options, values ...interface{}) string {
+		var buf bytes.Buffer
+		if err := report.Write(&buf, format, opts, values...); err != nil {
+			t.Fatalf("Failed to write %s: %v", format, err)
+		}
+		return buf.String()
+	}
+
+	var one report.Record
+	if err := json.Unmarshal([]byte(write("json", report.Options{}, records[0])), &one); err != nil || one.Metrics.MaxDepth != 4 {
+		t.Errorf("Expected one JSON object, got %+v (%v)", one, err)
+	}
+	var many []report.Record
+	if err := json.Unmarshal([]byte(write("json", report.Options{}, records...)), &many); err != nil || len(many) != 2 {
+		t.Errorf("Expected a JSON array of 2, got %d (%v)", len(many), err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(write("jsonl", report.Options{}, records...)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 JSON lines, got %d", len(lines))
+	}
+	var second report.Record
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil || second.Structure != "StarDAG" {
+		t.Errorf("Expected the second line to be StarDAG, got %+v (%v)", second, err)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(write("csv", report.Options{}, records...))).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %d (%v)", len(rows), err)
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	for _, name := range []string{"Scenario", "Metrics.TotalTime", "Metrics.MaxDepth", "NodeCreation.TotalTime", "Environment.GoVersion"} {
+		if _, ok := col[name]; !ok {
+			t.Errorf("Expected a %s column, got %v", name, rows[0])
+		}
+	}
+	if got := rows[1][col["Metrics.TotalTime"]]; got != "1500000" {
+		t.Errorf("Expected the duration in nanoseconds, got %s", got)
+	}
+	if got := rows[1][col["Metrics.NodesPerSecond"]]; got != "12345.678" {
+		t.Errorf("Expected the exact float, got %s", got)
+	}
+
+	table := write("table", report.Options{}, records...)
+	if !strings.Contains(table, "Metrics.MaxDepth") || !strings.Contains(table, "1.5ms") || strings.Contains(table, "\x1b[") {
+		t.Errorf("Expected a plain table with readable values, got\n%s", table)
+	}
+	if colored := write("table", report.Options{Color: true}, records...); !strings.Contains(colored, "\x1b[") {
+		t.Error("Expected color codes with Color set")
+	}
+	if text := write("text", report.Options{}, records[0]); !strings.Contains(text, "Environment.GoVersion") {
+		t.Errorf("Expected the environment in text, got\n%s", text)
+	}
+
+	if err := report.Write(&bytes.Buffer{}, "xml", report.Options{}, records...); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
+
+func TestCLIBenchMatrix(t *testing.T) {
+	code, out, stderr := runCLI(t, "bench", "-structure", "linear,star", "-nodes", "20,40", "-seed", "5", "-format", "csv", "-history", "")
+	if code != cli.ExitOK {
+		t.Fatalf("Failed to run the bench matrix: %s", stderr)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil || len(rows) != 5 {
+		t.Fatalf("Expected a header and 4 rows, got %d (%v)", len(rows), err)
+	}
+	scenario := -1
+	for i, name := range rows[0] {
+		if name == "Scenario" {
+			scenario = i
+		}
+	}
+	if scenario < 0 || rows[1][scenario] != "LinearDAG/20/gzip" || rows[4][scenario] != "StarDAG/40/gzip" {
+		t.Errorf("Expected every structure with every size, got %v", rows)
+	}
+
+	_, out, _ = runCLI(t, "bench", "-structure", "all", "-nodes", "10", "-format", "jsonl", "-history", "")
+	if n := strings.Count(out, "\n"); n != len(bench.Structures()) {
+		t.Errorf("Expected a line per structure, got %d", n)
+	}
+
+	// a buffer is no terminal, auto stays plain
+	if _, out, _ := runCLI(t, "analyze", "-nodes", "10", "-format", "table"); strings.Contains(out, "\x1b[") || !strings.Contains(out, "Shape.MaxDepth") {
+		t.Errorf("Expected a plain table, got\n%s", out)
+	}
+	if _, out, _ := runCLI(t, "analyze", "-nodes", "10", "-color", "always"); !strings.Contains(out, "\x1b[") {
+		t.Error("Expected -color always to color text output")
+	}
+}
+
+func TestBenchmarkTimesFromStats(t *testing.T) {
+	opts := bench.GenerateOptions{Structure: bench.BinaryTreeDAG, Nodes: 50, Seed: 3}
+	r, err := report.Benchmark(opts, "none", nil, bench.RunnerOptions{Samples: 3}, nil)
+	if err != nil {
+		t.Fatalf("Failed to benchmark: %v", err)
+	}
+	s := r.Stats
+	if want := s.Generate.Time.Median + s.Traverse.Time.Median + s.Serialize.Time.Median + s.Deserialize.Time.Median; r.Metrics.TotalTime != want {
+		t.Errorf("Expected the total of the medians %v, got %v", want, r.Metrics.TotalTime)
+	}
+	if r.NodeCreation.TotalTime != s.NodeCreation.Time.Median || r.SignedNodeCreation.TotalTime != s.SignedNodeCreation.Time.Median {
+		t.Errorf("Expected node creation from its samples, got %v and %v", r.NodeCreation.TotalTime, r.SignedNodeCreation.TotalTime)
+	}
+	if r.Metrics.SerializedSize <= 0 || r.Metrics.MaxDepth <= 0 {
+		t.Errorf("Expected the sizes and shape still measured, got %+v", r.Metrics)
+	}
+}
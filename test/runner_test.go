@@ -0,0 +1,98 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+)
+
+func TestSummarize(t *testing.T) {
+	s := bench.Summarize([]float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1})
+	near := func(name string, got, want float64) {
+		if math.Abs(got-want) > 1e-3 {
+			t.Errorf("Expected %s %g, got %g", name, want, got)
+		}
+	}
+	near("mean", s.Mean, 5.5)
+	near("median", s.Median, 5.5)
+	near("stddev", s.StdDev, 3.02765)
+	near("p90", s.P90, 9.1)
+	near("p99", s.P99, 9.91)
+	near("min", s.Min, 1)
+	near("max", s.Max, 10)
+	// t(9) = 2.262
+	near("ci low", s.CILow, 5.5-2.262*3.02765/math.Sqrt(10))
+	near("ci high", s.CIHigh, 5.5+2.262*3.02765/math.Sqrt(10))
+
+	one := bench.Summarize([]float64{7})
+	if one.Mean != 7 || one.StdDev != 0 || one.CILow != 7 || one.CIHigh != 7 {
+		t.Errorf("Expected a single sample to be its own interval, got %+v", one)
+	}
+	if (bench.Summarize(nil) != bench.Summary{}) {
+		t.Error("Expected no samples to summarize to zero")
+	}
+}
+
+var sink []byte
+
+func TestSampleRunner(t *testing.T) {
+	calls := 0
+	stats, err := bench.Sample(bench.RunnerOptions{Warmup: 2, Samples: 8}, func() error {
+		calls++
+		d := 2 * time.Millisecond
+		if calls == 5 {
+			// one slow sample among 8
+			d = 60 * time.Millisecond
+		}
+		time.Sleep(d)
+		sink = make([]byte, 1<<20)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to sample: %v", err)
+	}
+	if calls != 10 || stats.Samples != 8 || len(stats.Times) != 8 {
+		t.Fatalf("Expected 2 warmup runs and 8 samples, got %d calls and %+v", calls, stats)
+	}
+	if stats.Dropped < 1 || stats.Time.Max >= 60*time.Millisecond {
+		t.Errorf("Expected the slow sample dropped, got %d dropped and max %s", stats.Dropped, stats.Time.Max)
+	}
+	if stats.Bytes.Min < 1<<20 || stats.Allocs.Min < 1 {
+		t.Errorf("Expected at least a MiB in one allocation per run, got %+v", stats.Bytes)
+	}
+	if stats.Time.CILow > stats.Time.Mean || stats.Time.CIHigh < stats.Time.Mean {
+		t.Errorf("Expected the interval around the mean, got %+v", stats.Time)
+	}
+
+	// keeping every sample of a 1ms / 20ms alternation is too noisy
+	calls = 0
+	noisy, _ := bench.Sample(bench.RunnerOptions{Samples: 6, OutlierK: -1}, func() error {
+		calls++
+		time.Sleep(time.Duration(1+19*(calls%2)) * time.Millisecond)
+		return nil
+	})
+	if noisy.Dropped != 0 || !noisy.HighVariance {
+		t.Errorf("Expected high variance with nothing dropped, got %+v", noisy)
+	}
+}
+
+func TestSampleDAGOperations(t *testing.T) {
+	stats, err := bench.SampleDAGOperations(bench.GenerateOptions{Structure: bench.BinaryTreeDAG, Nodes: 100, Seed: 1}, bench.RunnerOptions{Samples: 5})
+	if err != nil {
+		t.Fatalf("Failed to sample the DAG operations: %v", err)
+	}
+	for name, s := range map[string]bench.Stats{"generate": stats.Generate, "traverse": stats.Traverse, "signed node creation": stats.SignedNodeCreation} {
+		if s.Samples != 5 || s.Time.Mean <= 0 {
+			t.Errorf("Expected 5 timed samples of %s, got %+v", name, s)
+		}
+	}
+	if stats.Generate.Allocs.Mean < 100 {
+		t.Errorf("Expected an allocation per generated node at least, got %g", stats.Generate.Allocs.Mean)
+	}
+	// a cached encoding of the root used to be all serialize did
+	if stats.Serialize.Allocs.Mean < 100 || stats.Deserialize.Allocs.Mean < 100 {
+		t.Errorf("Expected every node coded, got %g and %g allocations", stats.Serialize.Allocs.Mean, stats.Deserialize.Allocs.Mean)
+	}
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/netsim"
+	"ipld-benchmark/player"
+)
+
+func TestStrategyPickOrder(t *testing.T) {
+	now := netsim.Epoch
+	queue := []exchange.Request{
+		{Session: 2, Seq: 1, Providers: 3, Deadline: now.Add(8 * time.Second)},
+		{Session: 1, Seq: 2, Providers: 2, Deadline: now.Add(20 * time.Second)},
+		{Session: 3, Seq: 1, Providers: 1, Deadline: now.Add(5 * time.Second)},
+		{Session: 4, Seq: 1, Providers: 2, Deadline: now.Add(time.Second)},
+	}
+
+	cases := []struct {
+		strategy exchange.Strategy
+		want     int
+	}{
+		{exchange.Sequential{}, 1},
+		{exchange.RarestFirst{}, 2},
+		// session 4 is due within the urgency
+		{exchange.DeadlinePriority{}, 3},
+		{exchange.Endgame{Base: exchange.RarestFirst{}}, 2},
+	}
+	for _, c := range cases {
+		if got := c.strategy.Pick(queue, now); got != c.want {
+			t.Errorf("%s picked %d, expected %d", c.strategy.Name(), got, c.want)
+		}
+	}
+
+	// nothing urgent, the rarest within the window beats the earliest
+	queue[3].Deadline = now.Add(4 * time.Second)
+	if got := (exchange.DeadlinePriority{Urgency: time.Second}).Pick(queue, now); got != 2 {
+		t.Errorf("Expected the rarest block in the window, got %d", got)
+	}
+
+	for _, name := range []string{"sequential", "rarest-first", "deadline", "endgame"} {
+		s, err := exchange.StrategyByName(name)
+		if err != nil || s.Name() != name {
+			t.Errorf("Failed to look up strategy %s: %v", name, err)
+		}
+	}
+	if _, err := exchange.StrategyByName("random"); err == nil {
+		t.Error("Expected an unknown strategy to fail")
+	}
+}
+
+func TestSimNodeBandwidth(t *testing.T) {
+	sim := netsim.New(1)
+	// two senders share the 1000 bytes per second downlink of c
+	sim.SetNodeBandwidth("c", 0, 1000)
+
+	var arrivals []time.Duration
+	sim.Send("a", "c", 500, func() { arrivals = append(arrivals, sim.Elapsed()) })
+	sim.Send("b", "c", 500, func() { arrivals = append(arrivals, sim.Elapsed()) })
+	sim.Run()
+
+	if len(arrivals) != 2 || arrivals[0] != 500*time.Millisecond || arrivals[1] != time.Second {
+		t.Fatalf("Expected arrivals at 500ms and 1s, got %v", arrivals)
+	}
+}
+
+func TestScheduledFetchCompletes(t *testing.T) {
+	root, nodes, err := bench.GenerateDAG(bench.BinaryTreeDAG, 200)
+	if err != nil {
+		t.Fatalf("Failed to generate DAG: %v", err)
+	}
+
+	for _, strategy := range []exchange.Strategy{exchange.Sequential{}, exchange.RarestFirst{}, exchange.DeadlinePriority{}, exchange.Endgame{}} {
+		result, err := bench.SimulateFetch(root, nodes, bench.SimFetchConfig{
+			NumPeers:    5,
+			Seed:        1,
+			Link:        netsim.LinkConfig{Latency: netsim.Constant(10 * time.Millisecond), Bandwidth: 1 << 20},
+			Strategy:    strategy,
+			MaxInflight: 3,
+		})
+		if err != nil {
+			t.Fatalf("%s fetch failed: %v", strategy.Name(), err)
+		}
+		if result.Fetch.Blocks != len(nodes) {
+			t.Errorf("%s: expected %d blocks, got %d", strategy.Name(), len(nodes), result.Fetch.Blocks)
+		}
+	}
+}
+
+func TestCompareStrategies(t *testing.T) {
+	bs := myipld.NewMemBlockstore()
+	// 1.5MB segments, 6 blocks each
+	title, err := bench.SyntheticTitle("strategies", 36*time.Second, 6*time.Second, []media.Rendition{{Name: "hd", Bandwidth: 2_000_000}}, 1, bs)
+	if err != nil {
+		t.Fatalf("Failed to build title: %v", err)
+	}
+
+	cfg := bench.PlaybackConfig{
+		NumPeers:       6,
+		Seed:           3,
+		Link:           netsim.LinkConfig{Latency: netsim.LogNormal{Median: 40 * time.Millisecond, Sigma: 0.6}, Bandwidth: 400 << 10},
+		Player:         player.Config{ABR: player.FixedABR(0)},
+		Lookahead:      4,
+		Replicas:       3,
+		ViewerDownlink: 300 << 10,
+		MaxInflight:    4,
+	}
+	strategies := []exchange.Strategy{exchange.Sequential{}, exchange.RarestFirst{}, exchange.DeadlinePriority{}, exchange.Endgame{}}
+	results, err := bench.CompareStrategies(title, bs, cfg, strategies)
+	if err != nil {
+		t.Fatalf("Comparison failed: %v", err)
+	}
+
+	byName := make(map[string]*bench.PlaybackMetrics)
+	for _, r := range results {
+		if r.Metrics.Segments != 6 {
+			t.Errorf("%s: expected 6 segments, got %d", r.Strategy, r.Metrics.Segments)
+		}
+		byName[r.Strategy] = r.Metrics
+	}
+	// rarest-first spreads the downlink over segments nobody is waiting for yet
+	if byName["rarest-first"].TimeToFirstFrame <= byName["deadline"].TimeToFirstFrame {
+		t.Errorf("Expected deadline to start before rarest-first, got %s and %s",
+			byName["deadline"].TimeToFirstFrame, byName["rarest-first"].TimeToFirstFrame)
+	}
+	if byName["endgame"].DuplicateBlocks == 0 || byName["sequential"].DuplicateBlocks != 0 {
+		t.Errorf("Expected duplicates only in endgame, got %d and %d",
+			byName["endgame"].DuplicateBlocks, byName["sequential"].DuplicateBlocks)
+	}
+
+	again, _ := bench.CompareStrategies(title, bs, cfg, strategies[1:2])
+	if again[0].Metrics.SessionTime != byName["rarest-first"].SessionTime {
+		t.Error("Expected the same result for the same seed")
+	}
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"ipld-benchmark/myipld"
+)
+
+func newSignedNode(t *testing.T) (*myipld.MyNode, ed25519.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	node, err := myipld.NewMyNode(map[string]interface{}{"content": "signed"})
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	if err := node.Sign(priv); err != nil {
+		t.Fatalf("Failed to sign node: %v", err)
+	}
+	return node, pub
+}
+
+func TestSignedNodeRoundTrip(t *testing.T) {
+	node, pub := newSignedNode(t)
+
+	data, err := node.ToBytes()
+	if err != nil {
+		t.Fatalf("Failed to serialize signed node: %v", err)
+	}
+
+	decoded, err := myipld.FromBytesVerified(data, myipld.NewKeyRing(pub))
+	if err != nil {
+		t.Fatalf("Expected trusted signature to verify: %v", err)
+	}
+	if decoded.Cid != node.Cid {
+		t.Errorf("CID changed across round trip: %s != %s", decoded.Cid, node.Cid)
+	}
+
+	contentCID, err := node.ContentCID()
+	if err != nil {
+		t.Fatalf("Failed to compute content CID: %v", err)
+	}
+	if contentCID == node.Cid {
+		t.Error("Expected node CID to cover the signature envelope")
+	}
+}
+
+func TestSignedNodeRejectsUntrustedKey(t *testing.T) {
+	node, _ := newSignedNode(t)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	data, _ := node.ToBytes()
+	_, err := myipld.FromBytesVerified(data, myipld.NewKeyRing(otherPub))
+	if !errors.Is(err, myipld.ErrUntrustedKey) {
+		t.Fatalf("Expected ErrUntrustedKey, got %v", err)
+	}
+}
+
+func TestSignedNodeRejectsTampering(t *testing.T) {
+	node, pub := newSignedNode(t)
+
+	node.Data = []byte(`{"content":"tampered"}`)
+	if err := node.Verify(); !errors.Is(err, myipld.ErrInvalidSignature) {
+		t.Fatalf("Expected ErrInvalidSignature for tampered data, got %v", err)
+	}
+
+	unsigned, _ := myipld.NewMyNode(map[string]interface{}{"content": "plain"})
+	data, _ := unsigned.ToBytes()
+	if _, err := myipld.FromBytesVerified(data, myipld.NewKeyRing(pub)); !errors.Is(err, myipld.ErrNotSigned) {
+		t.Fatalf("Expected ErrNotSigned for unsigned node, got %v", err)
+	}
+}
+
+func TestAddLinkDropsSignature(t *testing.T) {
+	node, _ := newSignedNode(t)
+	child, _ := myipld.NewMyNode(map[string]interface{}{"content": "child"})
+
+	if err := node.AddLink("child", child.Cid); err != nil {
+		t.Fatalf("Failed to add link: %v", err)
+	}
+	if node.Signature != nil {
+		t.Error("Expected AddLink to drop the stale signature")
+	}
+}
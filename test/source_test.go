@@ -0,0 +1,238 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ipld-benchmark/cli"
+	"ipld-benchmark/myipld"
+	"ipld-benchmark/source"
+)
+
+const diamond = `{
+  "nodes": [
+    {"id": "a", "data": {"content": "top"}},
+    {"id": "b", "data": {"content": "left"}},
+    {"id": "c", "data": {"content": "right"}},
+    {"id": "d", "data": {"content": "bottom"}},
+    {"id": "e", "data": "another root"}
+  ],
+  "links": [
+    {"source": "a", "target": "b", "name": "left"},
+    {"source": "a", "target": "c", "name": "right"},
+    {"source": "b", "target": "d", "name": "down"},
+    {"source": "c", "target": "d", "name": "down"}
+  ]
+}`
+
+func TestLoadDescription(t *testing.T) {
+	bs := myipld.NewMemBlockstore()
+	res, err := source.Load(strings.NewReader(diamond), bs)
+	if err != nil {
+		t.Fatalf("Failed to load description: %v", err)
+	}
+	if res.Nodes != 5 || res.Links != 4 || bs.Len() != 5 {
+		t.Fatalf("Expected 5 nodes and 4 links, got %+v with %d blocks", res, bs.Len())
+	}
+	if len(res.Roots) != 2 || res.Roots[0].ID != "a" || res.Roots[1].ID != "e" {
+		t.Fatalf("Expected roots a and e, got %+v", res.Roots)
+	}
+
+	top, err := myipld.GetNode(bs, res.Roots[0].Cid)
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	if len(top.Links) != 2 || top.Links[0].Name != "left" || top.Links[1].Name != "right" {
+		t.Errorf("Expected the links in description order, got %+v", top.Links)
+	}
+	left, _ := myipld.GetNode(bs, top.Links[0].Cid)
+	right, _ := myipld.GetNode(bs, top.Links[1].Cid)
+	if left.Links[0].Cid != right.Links[0].Cid {
+		t.Error("Expected both sides to link the same bottom block")
+	}
+
+	again, _ := source.Load(strings.NewReader(diamond), myipld.NewMemBlockstore())
+	if again.Roots[0].Cid != res.Roots[0].Cid {
+		t.Error("Expected the same description to give the same CIDs")
+	}
+}
+
+func TestLoadDescriptionErrors(t *testing.T) {
+	cycle := `{"nodes": [{"id": "d"}, {"id": "a"}, {"id": "b"}, {"id": "c"}],
+	  "links": [{"source": "d", "target": "a"}, {"source": "a", "target": "b"},
+	            {"source": "b", "target": "c"}, {"source": "c", "target": "a"}]}`
+	_, err := source.Load(strings.NewReader(cycle), myipld.NewMemBlockstore())
+	if !errors.Is(err, source.ErrCycle) || !strings.Contains(err.Error(), `"a" -> "b" -> "c" -> "a"`) {
+		t.Errorf("Expected the cycle to be named, got %v", err)
+	}
+
+	self := `{"nodes": [{"id": "a"}], "links": [{"source": "a", "target": "a"}]}`
+	if _, err := source.Load(strings.NewReader(self), myipld.NewMemBlockstore()); !errors.Is(err, source.ErrCycle) {
+		t.Errorf("Expected a self link to be a cycle, got %v", err)
+	}
+
+	invalid := []string{
+		`{"nodes": [{"id": "a"}, {"id": "a"}]}`,
+		`{"nodes": [{"id": "a"}], "links": [{"source": "a", "target": "x"}]}`,
+		`{"nodes": [{"data": 1}]}`,
+		`{"nodes": []}`,
+		`{"nodes": [`,
+	}
+	for _, desc := range invalid {
+		if _, err := source.Load(strings.NewReader(desc), myipld.NewMemBlockstore()); !errors.Is(err, source.ErrInvalidDescription) {
+			t.Errorf("%s: expected an invalid description, got %v", desc, err)
+		}
+	}
+}
+
+func TestDescriptionRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	original, desc, loaded := filepath.Join(dir, "a.car"), filepath.Join(dir, "a.json"), filepath.Join(dir, "b.car")
+
+	runCLI(t, "generate", "-structure", "binary", "-nodes", "63", "-seed", "9", "-o", original)
+	if code, _, stderr := runCLI(t, "export", original, "-o", desc); code != cli.ExitOK {
+		t.Fatalf("Failed to export: %s", stderr)
+	}
+	if code, _, stderr := runCLI(t, "import", "-from", "json", desc, "-o", loaded); code != cli.ExitOK {
+		t.Fatalf("Failed to import the description: %s", stderr)
+	}
+
+	_, out, _ := runCLI(t, "diff", original, loaded, "-format", "json")
+	var diff struct {
+		SameRoot bool
+		Shared   int
+	}
+	if err := json.Unmarshal([]byte(out), &diff); err != nil || !diff.SameRoot || diff.Shared != 63 {
+		t.Errorf("Expected the exported DAG to load back to the same CIDs, got %s", out)
+	}
+}
+
+// chainDescription writes a chain of n nodes, links first when asked
+func chainDescription(w io.Writer, n int, linksFirst bool) {
+	nodes := func() {
+		fmt.Fprint(w, `"nodes": [`)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"id": "n%d", "data": {"index": %d, "payload": "%064d"}}`, i, i, i)
+		}
+		fmt.Fprint(w, "]")
+	}
+	links := func() {
+		fmt.Fprint(w, `"links": [`)
+		for i := 1; i < n; i++ {
+			if i > 1 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"source": "n%d", "target": "n%d", "name": "next"}`, i-1, i)
+		}
+		fmt.Fprint(w, "]")
+	}
+
+	fmt.Fprint(w, `{"comment": {"ignored": [1, 2, 3]}, `)
+	if linksFirst {
+		links()
+		fmt.Fprint(w, ", ")
+		nodes()
+	} else {
+		nodes()
+		fmt.Fprint(w, ", ")
+		links()
+	}
+	fmt.Fprint(w, "}")
+}
+
+func TestStreamDescription(t *testing.T) {
+	const n = 20000
+	pr, pw := io.Pipe()
+	go func() {
+		chainDescription(pw, n, false)
+		pw.Close()
+	}()
+
+	res, err := source.Stream(pr, myipld.NewMemBlockstore())
+	if err != nil {
+		t.Fatalf("Failed to stream description: %v", err)
+	}
+	if res.Nodes != n || res.Links != n-1 || len(res.Roots) != 1 || res.Roots[0].ID != "n0" {
+		t.Fatalf("Expected a chain of %d under n0, got %d nodes and roots %+v", n, res.Nodes, res.Roots)
+	}
+
+	// links before nodes resolve late but build the same DAG
+	var buf bytes.Buffer
+	chainDescription(&buf, 100, true)
+	late, err := source.Load(&buf, myipld.NewMemBlockstore())
+	if err != nil {
+		t.Fatalf("Failed to load links first: %v", err)
+	}
+	buf.Reset()
+	chainDescription(&buf, 100, false)
+	early, _ := source.Stream(&buf, myipld.NewMemBlockstore())
+	if late.Roots[0].Cid != early.Roots[0].Cid {
+		t.Error("Expected the order of nodes and links not to change the CIDs")
+	}
+
+	// c only shows up after the links (a second "nodes" is fine for the
+	// stream), a's links keep their order anyway
+	mixed := `{"nodes": [{"id": "b"}, {"id": "a"}], "links": [{"source": "a", "target": "b", "name": "1"},
+	  {"source": "a", "target": "c", "name": "2"}, {"source": "a", "target": "b", "name": "3"}],
+	  "nodes": [{"id": "c"}]}`
+	bs := myipld.NewMemBlockstore()
+	res, err = source.Load(strings.NewReader(mixed), bs)
+	if err != nil {
+		t.Fatalf("Failed to load mixed links: %v", err)
+	}
+	node, _ := myipld.GetNode(bs, res.Roots[0].Cid)
+	if len(node.Links) != 3 || node.Links[0].Name != "1" || node.Links[1].Name != "2" || node.Links[2].Name != "3" {
+		t.Errorf("Expected links 1, 2, 3 in order, got %+v", node.Links)
+	}
+}
+
+func TestRunSourceCommand(t *testing.T) {
+	script := "sh testdata/dag_source.sh"
+	ctx := context.Background()
+
+	res, err := source.RunCommand(ctx, script+" tree 31", source.CommandOptions{}, myipld.NewMemBlockstore())
+	if err != nil {
+		t.Fatalf("Failed to run source command: %v", err)
+	}
+	if res.Nodes != 31 || len(res.Roots) != 1 || res.Roots[0].ID != "n0" {
+		t.Errorf("Expected a tree of 31 under n0, got %+v", res)
+	}
+
+	_, err = source.RunCommand(ctx, script+" fail", source.CommandOptions{}, myipld.NewMemBlockstore())
+	if !errors.Is(err, source.ErrCommandFailed) || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "ran out of nodes") {
+		t.Errorf("Expected the exit status and stderr in the error, got %v", err)
+	}
+
+	start := time.Now()
+	_, err = source.RunCommand(ctx, script+" slow", source.CommandOptions{Timeout: 200 * time.Millisecond}, myipld.NewMemBlockstore())
+	if !errors.Is(err, source.ErrCommandFailed) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the timeout to stop the command, took %s", time.Since(start))
+	}
+
+	if _, err := source.RunCommand(ctx, script+" garbage", source.CommandOptions{}, myipld.NewMemBlockstore()); !errors.Is(err, source.ErrInvalidDescription) {
+		t.Errorf("Expected an invalid description, got %v", err)
+	}
+
+	code, out, _ := runCLI(t, "analyze", "-source-cmd", script+" tree 7", "-format", "json")
+	if code != cli.ExitOK || !strings.Contains(out, `"Nodes": 7`) {
+		t.Errorf("Expected analyze to read the command's DAG, got %d %s", code, out)
+	}
+	code, _, stderr := runCLI(t, "import", "-source-cmd", script+" fail")
+	if code != cli.ExitError || !strings.Contains(stderr, "ipld-benchmark: import: source command failed") {
+		t.Errorf("Expected a runtime error, got %d %s", code, stderr)
+	}
+}
@@ -0,0 +1,261 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/exchange"
+	"ipld-benchmark/myipld"
+)
+
+func TestWireFraming(t *testing.T) {
+	cid, _ := myipld.ComputeSHA256([]byte("block"))
+	messages := []exchange.Message{
+		{Type: exchange.WantHave, Cid: cid},
+		{Type: exchange.Block, Cid: cid, Data: []byte("block")},
+	}
+
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		if err := exchange.WriteFrame(&buf, exchange.MarshalMessage(msg)); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, want := range messages {
+		payload, err := exchange.ReadFrame(r)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		got, err := exchange.UnmarshalMessage(payload)
+		if err != nil {
+			t.Fatalf("Failed to decode message: %v", err)
+		}
+		if got.Type != want.Type || got.Cid != want.Cid || !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestWireRejectsHugeFrame(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	if _, err := exchange.ReadFrame(bufio.NewReader(&buf)); !errors.Is(err, exchange.ErrFrameTooLarge) {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestTCPExchange(t *testing.T) {
+	for _, structure := range []bench.DAGStructure{bench.LinearDAG, bench.BinaryTreeDAG} {
+		t.Run(structure.String(), func(t *testing.T) {
+			_, stats, err := bench.BenchmarkBlockExchange(structure, 200, 5, "tcp")
+			if err != nil {
+				t.Fatalf("TCP exchange failed: %v", err)
+			}
+			if stats.Blocks != 200 {
+				t.Errorf("Expected 200 blocks over TCP, got %d", stats.Blocks)
+			}
+		})
+	}
+}
+
+// TestHelperSeeder is not a test, it is the seeder process
+// TestTCPExchangeAcrossProcesses starts from this test binary
+func TestHelperSeeder(t *testing.T) {
+	spec := os.Getenv(bench.SeederEnv)
+	if spec == "" {
+		t.Skip("only runs as a helper process")
+	}
+	if err := bench.RunSeeder(spec, os.Stdout, os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestTCPExchangeAcrossProcesses(t *testing.T) {
+	seeder := exec.Command(os.Args[0], "-test.run=^TestHelperSeeder$")
+	seeder.Stderr = os.Stderr
+	_, stats, err := bench.BenchmarkBlockExchangeProcess(bench.BinaryTreeDAG, 500, seeder)
+	if err != nil {
+		t.Fatalf("Failed to fetch from the seeder process: %v", err)
+	}
+	if stats.Blocks != 500 {
+		t.Errorf("Expected 500 blocks from the seeder process, got %d", stats.Blocks)
+	}
+}
+
+func TestTCPReconnectsAfterIdleTimeout(t *testing.T) {
+	root, nodes, _ := bench.GenerateDAG(bench.LinearDAG, 20)
+
+	seedNet, fetchNet := exchange.NewTCPNetwork(), exchange.NewTCPNetwork()
+	seedNet.IdleTimeout = 50 * time.Millisecond
+	fetchNet.IdleTimeout = 50 * time.Millisecond
+
+	seeder, err := exchange.NewPeer("seeder", myipld.NewMemBlockstore(), seedNet)
+	if err != nil {
+		t.Fatalf("Failed to create seeder: %v", err)
+	}
+	defer seeder.Close()
+	fetcher, err := exchange.NewPeer("fetcher", myipld.NewMemBlockstore(), fetchNet)
+	if err != nil {
+		t.Fatalf("Failed to create fetcher: %v", err)
+	}
+	defer fetcher.Close()
+
+	seedAddr, _ := seedNet.Lookup("seeder")
+	fetchAddr, _ := fetchNet.Lookup("fetcher")
+	fetchNet.AddPeer("seeder", seedAddr)
+	seedNet.AddPeer("fetcher", fetchAddr)
+
+	for _, node := range nodes[:10] {
+		myipld.PutNode(seeder.Blockstore(), node)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := fetcher.Fetch(ctx, nodes[9].Cid, []exchange.PeerID{"seeder"}); err != nil {
+		t.Fatalf("First fetch failed: %v", err)
+	}
+
+	// let both ends drop the idle connection, the next fetch has to redial
+	time.Sleep(200 * time.Millisecond)
+	for _, node := range nodes[10:] {
+		myipld.PutNode(seeder.Blockstore(), node)
+	}
+	if _, err := fetcher.Fetch(ctx, root.Cid, []exchange.PeerID{"seeder"}); err != nil {
+		t.Fatalf("Fetch after idle timeout failed: %v", err)
+	}
+}
+
+func TestTCPDropsSilentPeer(t *testing.T) {
+	// a listener that accepts and never answers, like the far end of a
+	// half-open connection, it reports when our side hangs up
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	hungUp := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+		close(hungUp)
+	}()
+
+	nw := exchange.NewTCPNetwork()
+	nw.IdleTimeout = 100 * time.Millisecond
+	nw.AddPeer("silent", ln.Addr().String())
+	p, err := exchange.NewPeer("fetcher", myipld.NewMemBlockstore(), nw)
+	if err != nil {
+		t.Fatalf("Failed to create peer: %v", err)
+	}
+	defer p.Close()
+
+	root, _ := myipld.NewMyNode(map[string]interface{}{"content": "nobody-has-me"})
+	p.NewSession(root.Cid, []exchange.PeerID{"silent"}).Start()
+
+	select {
+	case <-hungUp:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the idle connection to be closed")
+	}
+}
+
+// ignoreMessages is a Handler for a transport that only sends
+type ignoreMessages struct{}
+
+func (ignoreMessages) HandleMessage(exchange.PeerID, exchange.Message) {}
+
+func TestTCPDropsConnectionCutMidFrame(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	// the first connection stalls until released, so a write times out
+	// halfway through a frame, later ones are read as they come
+	release := make(chan struct{})
+	messages := make(chan error, 16)
+	firstEnd := make(chan error, 1)
+	go func() {
+		for first := true; ; first = false {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(first bool) {
+				defer conn.Close()
+				if first {
+					<-release
+				}
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				r := bufio.NewReader(conn)
+				_, err := exchange.ReadFrame(r)
+				for err == nil {
+					var payload []byte
+					if payload, err = exchange.ReadFrame(r); err == nil {
+						_, err = exchange.UnmarshalMessage(payload)
+						messages <- err
+					}
+				}
+				if first {
+					firstEnd <- err
+				}
+			}(first)
+		}
+	}()
+
+	nw := exchange.NewTCPNetwork()
+	nw.WriteTimeout = 200 * time.Millisecond
+	nw.AddPeer("sink", ln.Addr().String())
+	tr, err := nw.Attach("sender", ignoreMessages{})
+	if err != nil {
+		t.Fatalf("Failed to attach: %v", err)
+	}
+	defer tr.Close()
+
+	// more than the socket buffers hold, the stalled connection breaks
+	const sends = 4
+	big := exchange.Message{Type: exchange.Block, Data: bytes.Repeat([]byte{7}, 7<<20)}
+	errs := make(chan error, sends)
+	for i := 0; i < sends; i++ {
+		go func() { errs <- tr.Send("sink", big) }()
+	}
+	for i := 0; i < sends; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected the send to be retried on a fresh connection, got %v", err)
+		}
+	}
+	close(release)
+
+	// cut off mid frame and closed, nothing was written after the cut
+	if err := <-firstEnd; !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected the broken connection to end mid frame, got %v", err)
+	}
+	for i := 0; i < sends; i++ {
+		select {
+		case err := <-messages:
+			if err != nil {
+				t.Errorf("Expected whole messages, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d messages, got %d", sends, i)
+		}
+	}
+}
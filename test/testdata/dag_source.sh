@@ -0,0 +1,59 @@
+#!/bin/sh
+# dag_source.sh <mode> [nodes]
+#
+# a DAG source for the source tests, writes a description of a binary
+# tree of <nodes> nodes (15 by default) to stdout
+#
+#   tree     the whole description
+#   fail     half of it, a complaint on stderr and exit 3
+#   slow     half of it, then hangs
+#   garbage  something that is not a description
+
+mode=${1:-tree}
+n=${2:-15}
+
+nodes() {
+	i=0
+	while [ "$i" -lt "$n" ]; do
+		[ "$i" -gt 0 ] && printf ','
+		printf '{"id":"n%d","data":{"index":%d,"label":"node %d"}}\n' "$i" "$i" "$i"
+		i=$((i + 1))
+	done
+}
+
+links() {
+	i=1
+	while [ "$i" -lt "$n" ]; do
+		[ "$i" -gt 1 ] && printf ','
+		printf '{"source":"n%d","target":"n%d","name":"child-%d"}\n' $(((i - 1) / 2)) "$i" "$i"
+		i=$((i + 1))
+	done
+}
+
+case "$mode" in
+tree)
+	printf '{"nodes":['
+	nodes
+	printf '],"links":['
+	links
+	printf ']}\n'
+	;;
+fail)
+	printf '{"nodes":['
+	nodes
+	echo "dag_source: ran out of nodes" >&2
+	exit 3
+	;;
+slow)
+	printf '{"nodes":['
+	nodes
+	sleep 30
+	;;
+garbage)
+	echo "this is not json"
+	;;
+*)
+	echo "dag_source: unknown mode $mode" >&2
+	exit 2
+	;;
+esac
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ipld-benchmark/bench"
+	"ipld-benchmark/media"
+	"ipld-benchmark/myipld"
+)
+
+var testLadder = []media.Rendition{
+	{Name: "360p", Width: 640, Height: 360, Bandwidth: 80_000},
+	{Name: "240p", Width: 426, Height: 240, Bandwidth: 40_000},
+	{Name: "720p", Width: 1280, Height: 720, Bandwidth: 160_000},
+}
+
+func TestTitleRenditionsAreAligned(t *testing.T) {
+	bs := myipld.NewMemBlockstore()
+	title, err := bench.SyntheticTitle("movie", 10*time.Second, 3*time.Second, testLadder, 1, bs)
+	if err != nil {
+		t.Fatalf("Failed to import title: %v", err)
+	}
+
+	if title.Renditions[0].Name != "240p" || title.Renditions[2].Name != "720p" {
+		t.Errorf("Expected renditions sorted by bandwidth, got %s..%s", title.Renditions[0].Name, title.Renditions[2].Name)
+	}
+	if title.SegmentCount() != 4 {
+		t.Fatalf("Expected 4 segments, got %d", title.SegmentCount())
+	}
+
+	loaded, err := media.LoadTitle(bs, title.Node.Cid)
+	if err != nil {
+		t.Fatalf("Failed to load title: %v", err)
+	}
+	for i, r := range loaded.Renditions {
+		if r.Asset.Manifest.Cid != title.Renditions[i].Asset.Manifest.Cid {
+			t.Errorf("Rendition %s loaded a different manifest", r.Name)
+		}
+		for j, seg := range r.Asset.Segments {
+			if seg.Start != loaded.Renditions[0].Asset.Segments[j].Start {
+				t.Errorf("Segment %d of %s is not aligned", j, r.Name)
+			}
+		}
+	}
+}
+
+func TestRenditionSwitchNeedsOnlyNewSegments(t *testing.T) {
+	full := myipld.NewMemBlockstore()
+	title, err := bench.SyntheticTitle("movie", 12*time.Second, 2*time.Second, testLadder, 2, full)
+	if err != nil {
+		t.Fatalf("Failed to import title: %v", err)
+	}
+
+	plan, err := title.PlaybackSegments("240p", media.Switch{At: 2, Rendition: "720p"}, media.Switch{At: 4, Rendition: "360p"})
+	if err != nil {
+		t.Fatalf("Failed to plan playback: %v", err)
+	}
+
+	// a player only has the segments it played, nothing else of any
+	// rendition, and can still play every one of them
+	player := myipld.NewMemBlockstore()
+	for _, seg := range plan {
+		if err := copySubtree(full, player, seg.Cid); err != nil {
+			t.Fatalf("Failed to copy segment %d: %v", seg.Index, err)
+		}
+	}
+	hd, _ := title.Rendition("720p")
+	for i, seg := range plan {
+		if _, err := media.ReadSegment(player, seg.Cid); err != nil {
+			t.Fatalf("Segment %d not playable: %v", i, err)
+		}
+		if want := i >= 2 && i < 4; want != (seg.Cid == hd.Asset.Segments[i].Cid) {
+			t.Errorf("Segment %d came from the wrong rendition", i)
+		}
+	}
+	if has, _ := player.Has(hd.Asset.Segments[0].Cid); has {
+		t.Error("Expected no 720p segment from before the switch")
+	}
+
+	if _, err := title.PlaybackSegments("8k"); !errors.Is(err, media.ErrUnknownRendition) {
+		t.Errorf("Expected ErrUnknownRendition, got %v", err)
+	}
+}
+
+func copySubtree(from myipld.BlockGetter, to myipld.Blockstore, cid myipld.MyCID) error {
+	node, err := myipld.GetNode(from, cid)
+	if err != nil {
+		return err
+	}
+	if err := myipld.PutNode(to, node); err != nil {
+		return err
+	}
+	for _, link := range node.Links {
+		if err := copySubtree(from, to, link.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWriteDASHAndMaster(t *testing.T) {
+	bs := myipld.NewMemBlockstore()
+	title, err := bench.SyntheticTitle("movie", 5*time.Second, 2*time.Second, testLadder, 3, bs)
+	if err != nil {
+		t.Fatalf("Failed to import title: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := media.WriteDASH(&out, title, ""); err != nil {
+		t.Fatalf("WriteDASH failed: %v", err)
+	}
+
+	var doc struct {
+		Duration string `xml:"mediaPresentationDuration,attr"`
+		Reps     []struct {
+			ID       string `xml:"id,attr"`
+			Timeline []struct {
+				D int `xml:"d,attr"`
+			} `xml:"SegmentList>SegmentTimeline>S"`
+			URLs []struct {
+				Media string `xml:"media,attr"`
+			} `xml:"SegmentList>SegmentURL"`
+		} `xml:"Period>AdaptationSet>Representation"`
+	}
+	if err := xml.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("MPD is not valid XML: %v", err)
+	}
+	if doc.Duration != "PT5.000S" || len(doc.Reps) != 3 {
+		t.Fatalf("Unexpected MPD: duration %s, %d representations", doc.Duration, len(doc.Reps))
+	}
+	rep := doc.Reps[1]
+	if rep.ID != "360p" || len(rep.URLs) != 3 || rep.Timeline[2].D != 1000 {
+		t.Errorf("Unexpected representation %+v", rep)
+	}
+	if rep.URLs[0].Media != "/ipfs/"+title.Renditions[1].Asset.Segments[0].Cid.Hex() {
+		t.Errorf("Expected CID segment URL, got %s", rep.URLs[0].Media)
+	}
+
+	var master strings.Builder
+	if err := media.WriteHLSMaster(&master, title); err != nil {
+		t.Fatalf("WriteHLSMaster failed: %v", err)
+	}
+	if !strings.Contains(master.String(), "BANDWIDTH=160000,RESOLUTION=1280x720\n720p.m3u8\n") {
+		t.Errorf("Unexpected master playlist:\n%s", master.String())
+	}
+}
+
+func TestRenditionSharing(t *testing.T) {
+	data := fakeMedia(40_000, 4)
+	sources := []media.RenditionSource{
+		{Rendition: media.Rendition{Name: "a", Bandwidth: 1}, Reader: bytes.NewReader(data), Size: int64(len(data))},
+		{Rendition: media.Rendition{Name: "b", Bandwidth: 2}, Reader: bytes.NewReader(data), Size: int64(len(data))},
+		{Rendition: media.Rendition{Name: "c", Bandwidth: 3}, Reader: bytes.NewReader(fakeMedia(40_000, 5)), Size: 40_000},
+	}
+	bs := myipld.NewMemBlockstore()
+	title, err := media.ImportTitle(media.TitleOptions{Name: "dup", Duration: 4 * time.Second, SegmentDuration: time.Second}, sources, bs)
+	if err != nil {
+		t.Fatalf("Failed to import title: %v", err)
+	}
+
+	sharing, err := bench.MeasureRenditionSharing(title, bs)
+	if err != nil {
+		t.Fatalf("Failed to measure sharing: %v", err)
+	}
+	// a and b are the same bytes, only their manifests differ by name
+	a := sharing.Renditions[0]
+	if sharing.SharedBlocks != a.Blocks-1 {
+		t.Errorf("Expected %d shared blocks, got %d", a.Blocks-1, sharing.SharedBlocks)
+	}
+	if sharing.UniqueBlocks != sharing.TotalBlocks-sharing.SharedBlocks {
+		t.Errorf("Expected %d unique blocks, got %d", sharing.TotalBlocks-sharing.SharedBlocks, sharing.UniqueBlocks)
+	}
+
+	independent, err := bench.BenchmarkRenditionSharing(2*time.Second, time.Second, 6)
+	if err != nil {
+		t.Fatalf("Sharing benchmark failed: %v", err)
+	}
+	if independent.SharedBlocks != 0 || len(independent.Renditions) != len(media.DefaultLadder) {
+		t.Errorf("Expected independent renditions to share nothing, got %d shared", independent.SharedBlocks)
+	}
+}